@@ -0,0 +1,526 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v2"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultFile is read if no config file is given explicitly. It is optional, all settings have defaults.
+const DefaultFile = "tradingServer.yaml"
+
+// EnvPrefix starts the names of environment variables overriding settings, e.g. TRADINGSERVER_SERVER_LISTEN
+// overrides server.listen. TRADINGSERVER_CONFIG names the config file.
+const EnvPrefix = "TRADINGSERVER_"
+
+// Config holds all settings of the server. Settings are read from the config file, then overridden by environment
+// variables and finally by command line flags.
+type Config struct {
+	Server         ServerConfig         `yaml:"server"`
+	Storage        StorageConfig        `yaml:"storage"`
+	Log            LogConfig            `yaml:"log"`
+	RateLimits     RateLimitConfig      `yaml:"rate_limits"`
+	Accounts       AccountsConfig       `yaml:"accounts"`
+	Sessions       SessionsConfig       `yaml:"sessions"`
+	Lockout        LockoutConfig        `yaml:"lockout"`
+	TOTP           TOTPConfig           `yaml:"totp"`
+	Mail           MailConfig           `yaml:"mail"`
+	PriceVariation PriceVariationConfig `yaml:"price_variation"`
+}
+
+type ServerConfig struct {
+	// Listen is the address the HTTP server listens on
+	Listen string `yaml:"listen"`
+	// PublicURL is the address users reach the server at, links in mails point there
+	PublicURL string `yaml:"public_url"`
+	// ShutdownTimeout limits the time requests in flight get to finish when the server is stopped
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// QuoteKey signs the quotes, instances sharing a database need the same key. Empty generates one on start.
+	QuoteKey string `yaml:"quote_key"`
+}
+
+type StorageConfig struct {
+	// Backend is one of sqlite, postgres or memory
+	Backend string `yaml:"backend"`
+	// DSN is the sqlite database file or the PostgreSQL connection URL
+	DSN string `yaml:"dsn"`
+}
+
+type LogConfig struct {
+	// File receives the server's log messages as JSON lines
+	File string `yaml:"file"`
+	// Level is the lowest level logged: debug, info, warn or error
+	Level string `yaml:"level"`
+	// MaxSizeMB is the size in megabytes at which the file is rotated
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxBackups is the number of rotated files kept, MaxAge the time they are kept. Either keeps all if zero.
+	MaxBackups int           `yaml:"max_backups"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	// GinFile is no longer used, the messages of the gin framework go to File. It is accepted for old config files.
+	GinFile string `yaml:"gin_file,omitempty"`
+}
+
+type RateLimitConfig struct {
+	// Groups holds the limits of every route group
+	Groups map[string]RateLimit `yaml:"groups"`
+	// Tiers override the limits of route groups for the users assigned to the tier, see the settier command
+	Tiers map[string]map[string]RateLimit `yaml:"tiers"`
+}
+
+// RateLimit allows Rate requests per second on average and bursts of up to Burst requests
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// RateLimit returns the limit of a route group for the given user tier
+func (c RateLimitConfig) RateLimit(group string, tier string) (RateLimit, bool) {
+	if limit, ok := c.Tiers[tier][group]; ok {
+		return limit, true
+	}
+	limit, ok := c.Groups[group]
+	return limit, ok
+}
+
+type AccountsConfig struct {
+	// StartingBalance is granted to new accounts
+	StartingBalance Decimal `yaml:"starting_balance"`
+	// AuthCacheTTL is the time a verified login and password are accepted again without hashing the password,
+	// 0 disables the cache
+	AuthCacheTTL time.Duration `yaml:"auth_cache_ttl"`
+	// MinPasswordLength is the minimum number of characters of passwords chosen by users
+	MinPasswordLength int `yaml:"min_password_length"`
+	// EmailConfirmationTTL is the time a user has to confirm a new email address
+	EmailConfirmationTTL time.Duration `yaml:"email_confirmation_ttl"`
+	// Registration is closed (accounts are created by admins only), invite (POST /register requires an invite code)
+	// or open (anyone can register)
+	Registration string `yaml:"registration"`
+}
+
+type SessionsConfig struct {
+	// AccessTokenTTL is the time an access token issued by /login authenticates requests
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl"`
+	// RefreshTokenTTL is the time a refresh token can be exchanged for new tokens
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+}
+
+// LockoutConfig throttles password guessing. Once a login or a client address reaches its threshold of failed
+// password attempts, it is locked for Duration. Every further failure doubles the lockout up to MaxDuration.
+type LockoutConfig struct {
+	LoginThreshold   int           `yaml:"login_threshold"`
+	AddressThreshold int           `yaml:"address_threshold"`
+	Duration         time.Duration `yaml:"duration"`
+	MaxDuration      time.Duration `yaml:"max_duration"`
+	// ResetAfter is the time without failures after which the failures of a login or address are forgotten
+	ResetAfter time.Duration `yaml:"reset_after"`
+}
+
+type TOTPConfig struct {
+	// Issuer names the server in authenticator apps
+	Issuer string `yaml:"issuer"`
+	// SellThreshold requires users with two-factor authentication to confirm sells worth more with a code, 0 never does
+	SellThreshold Decimal `yaml:"sell_threshold"`
+}
+
+type MailConfig struct {
+	// Backend is one of log (write mails to the server log), file (append them to File) or smtp
+	Backend string `yaml:"backend"`
+	// From is the sender address of all mails
+	From string `yaml:"from"`
+	// File receives the mails of the file backend
+	File string `yaml:"file"`
+	// SMTPAddr is the host:port of the mail server used by the smtp backend
+	SMTPAddr string `yaml:"smtp_addr"`
+	// SMTPUser and SMTPPassword authenticate at the mail server if set
+	SMTPUser     string `yaml:"smtp_user"`
+	SMTPPassword string `yaml:"smtp_password"`
+}
+
+type PriceVariationConfig struct {
+	// MaxDeviation limits the prices to the start price +- MaxDeviation * start price
+	MaxDeviation float64 `yaml:"max_deviation"`
+	// MinChangeInterval and MaxChangeInterval bound the time a price takes to reach its next target
+	MinChangeInterval time.Duration `yaml:"min_change_interval"`
+	MaxChangeInterval time.Duration `yaml:"max_change_interval"`
+	// MinUpdateInterval is the minimum time between two price updates of an asset
+	MinUpdateInterval time.Duration `yaml:"min_update_interval"`
+}
+
+// Decimal is a decimal number written as a plain number in the config file
+type Decimal struct {
+	decimal.Decimal
+}
+
+func (d *Decimal) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	var err error
+	d.Decimal, err = decimal.NewFromString(s)
+	return err
+}
+
+func (d Decimal) MarshalYAML() (interface{}, error) {
+	// plain number if that is exact, a string otherwise
+	if f, exact := d.Float64(); exact {
+		return f, nil
+	}
+	return d.String(), nil
+}
+
+// Default returns the settings used unless configured otherwise
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Listen:          ":8002",
+			PublicURL:       "http://localhost:8002",
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Storage: StorageConfig{
+			Backend: "sqlite",
+			DSN:     "database.sqlite3",
+		},
+		Log: LogConfig{
+			File:       "tradingServer.log",
+			Level:      "info",
+			MaxSizeMB:  100,
+			MaxBackups: 5,
+			MaxAge:     30 * 24 * time.Hour,
+		},
+		RateLimits: RateLimitConfig{
+			Groups: map[string]RateLimit{
+				"index":    {Rate: 10, Burst: 10},
+				"rates":    {Rate: 20, Burst: 20},
+				"history":  {Rate: 5, Burst: 5},
+				"candles":  {Rate: 5, Burst: 5},
+				"auth":     {Rate: 100, Burst: 100},
+				"login":    {Rate: 1, Burst: 10},
+				"register": {Rate: 0.01, Burst: 5},
+			},
+			Tiers: map[string]map[string]RateLimit{},
+		},
+		Accounts: AccountsConfig{
+			StartingBalance:      Decimal{decimal.NewFromInt(100)},
+			AuthCacheTTL:         5 * time.Minute,
+			MinPasswordLength:    10,
+			EmailConfirmationTTL: 24 * time.Hour,
+			Registration:         "closed",
+		},
+		Sessions: SessionsConfig{
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+		},
+		Lockout: LockoutConfig{
+			LoginThreshold:   5,
+			AddressThreshold: 20,
+			Duration:         time.Minute,
+			MaxDuration:      time.Hour,
+			ResetAfter:       24 * time.Hour,
+		},
+		TOTP: TOTPConfig{
+			Issuer: "tradingServer",
+		},
+		Mail: MailConfig{
+			Backend: "log",
+			From:    "tradingServer@localhost",
+			File:    "mail.log",
+		},
+		PriceVariation: PriceVariationConfig{
+			MaxDeviation:      0.05,
+			MinChangeInterval: time.Second,
+			MaxChangeInterval: 10 * time.Second,
+			MinUpdateInterval: 200 * time.Millisecond,
+		},
+	}
+}
+
+// Load reads the config file, applies the overrides of the environment and of the given key=value pairs and
+// validates the result. Without a file name the file named by TRADINGSERVER_CONFIG or DefaultFile is read if it exists.
+func Load(file string, overrides []string) (*Config, error) {
+	c := Default()
+
+	explicit := file != ""
+	if !explicit {
+		file = os.Getenv(EnvPrefix + "CONFIG")
+		explicit = file != ""
+	}
+	if !explicit {
+		file = DefaultFile
+	}
+
+	buf, err := os.ReadFile(file)
+	if err == nil {
+		// rate limits of groups not given in the file keep their defaults, the strict decoder rejects keys set already
+		defaultRateLimits := c.RateLimits.Groups
+		c.RateLimits.Groups = nil
+		if err = yaml.UnmarshalStrict(buf, c); err != nil {
+			return nil, fmt.Errorf("config file %v: %v", file, err)
+		}
+		if c.RateLimits.Groups == nil {
+			c.RateLimits.Groups = make(map[string]RateLimit)
+		}
+		for group, limit := range defaultRateLimits {
+			if _, ok := c.RateLimits.Groups[group]; !ok {
+				c.RateLimits.Groups[group] = limit
+			}
+		}
+		if c.RateLimits.Tiers == nil {
+			c.RateLimits.Tiers = make(map[string]map[string]RateLimit)
+		}
+	} else if explicit || !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read config file: %v", err)
+	}
+
+	for _, key := range c.Keys() {
+		env := EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		if value, ok := os.LookupEnv(env); ok {
+			if err = c.Set(key, value); err != nil {
+				return nil, fmt.Errorf("environment variable %v: %v", env, err)
+			}
+		}
+	}
+
+	for _, o := range overrides {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid setting '%v', expected key=value", o)
+		}
+		if err = c.Set(kv[0], kv[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate checks the settings for consistency
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Server.Listen != "", "server.listen must be set")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.QuoteKey == "" || len(c.Server.QuoteKey) >= 32, "server.quote_key must have at least 32 characters")
+	if u, err := url.Parse(c.Server.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		check(false, "server.public_url must be an http or https URL, not '%v'", c.Server.PublicURL)
+	}
+
+	switch c.Storage.Backend {
+	case "sqlite", "postgres":
+		check(c.Storage.DSN != "", "storage.dsn must be set for backend %v", c.Storage.Backend)
+	case "memory":
+	default:
+		check(false, "storage.backend must be one of sqlite, postgres or memory, not '%v'", c.Storage.Backend)
+	}
+
+	check(c.Log.File != "", "log.file must be set")
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "log.level must be one of debug, info, warn or error, not '%v'", c.Log.Level)
+	}
+	check(c.Log.MaxSizeMB > 0, "log.max_size_mb must be positive")
+	check(c.Log.MaxBackups >= 0, "log.max_backups must not be negative")
+	check(c.Log.MaxAge >= 0, "log.max_age must not be negative")
+
+	for group, limit := range c.RateLimits.Groups {
+		check(limit.Rate > 0 && limit.Burst > 0, "rate_limits.groups.%v: rate and burst must be positive", group)
+	}
+	for tier, groups := range c.RateLimits.Tiers {
+		for group, limit := range groups {
+			_, known := c.RateLimits.Groups[group]
+			check(known, "rate_limits.tiers.%v: unknown group %v", tier, group)
+			check(limit.Rate > 0 && limit.Burst > 0, "rate_limits.tiers.%v.%v: rate and burst must be positive", tier, group)
+		}
+	}
+
+	check(!c.Accounts.StartingBalance.IsNegative(), "accounts.starting_balance must not be negative")
+	check(c.Accounts.AuthCacheTTL >= 0, "accounts.auth_cache_ttl must not be negative")
+	check(c.Accounts.MinPasswordLength > 0, "accounts.min_password_length must be positive")
+	check(c.Accounts.EmailConfirmationTTL > 0, "accounts.email_confirmation_ttl must be positive")
+	switch c.Accounts.Registration {
+	case "closed", "invite", "open":
+	default:
+		check(false, "accounts.registration must be one of closed, invite or open, not '%v'", c.Accounts.Registration)
+	}
+
+	check(c.Sessions.AccessTokenTTL > 0, "sessions.access_token_ttl must be positive")
+	check(c.Sessions.RefreshTokenTTL >= c.Sessions.AccessTokenTTL,
+		"sessions.refresh_token_ttl must not be less than sessions.access_token_ttl")
+
+	lo := c.Lockout
+	check(lo.LoginThreshold > 0, "lockout.login_threshold must be positive")
+	check(lo.AddressThreshold > 0, "lockout.address_threshold must be positive")
+	check(lo.Duration > 0, "lockout.duration must be positive")
+	check(lo.MaxDuration >= lo.Duration, "lockout.max_duration must not be less than lockout.duration")
+	check(lo.ResetAfter >= lo.MaxDuration, "lockout.reset_after must not be less than lockout.max_duration")
+
+	check(c.TOTP.Issuer != "" && !strings.Contains(c.TOTP.Issuer, ":"), "totp.issuer must be set and must not contain ':'")
+	check(!c.TOTP.SellThreshold.IsNegative(), "totp.sell_threshold must not be negative")
+
+	check(c.Mail.From != "", "mail.from must be set")
+	switch c.Mail.Backend {
+	case "log":
+	case "file":
+		check(c.Mail.File != "", "mail.file must be set for backend file")
+	case "smtp":
+		check(c.Mail.SMTPAddr != "", "mail.smtp_addr must be set for backend smtp")
+	default:
+		check(false, "mail.backend must be one of log, file or smtp, not '%v'", c.Mail.Backend)
+	}
+
+	pv := c.PriceVariation
+	check(pv.MaxDeviation >= 0 && pv.MaxDeviation < 1, "price_variation.max_deviation must be in [0,1)")
+	check(pv.MinChangeInterval > 0, "price_variation.min_change_interval must be positive")
+	check(pv.MaxChangeInterval >= pv.MinChangeInterval,
+		"price_variation.max_change_interval must not be less than price_variation.min_change_interval")
+	check(pv.MinUpdateInterval > 0, "price_variation.min_update_interval must be positive")
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Keys lists the keys of all settings, e.g. server.listen
+func (c *Config) Keys() []string {
+	var keys []string
+	collectKeys(reflect.ValueOf(c).Elem(), "", &keys)
+	return keys
+}
+
+func collectKeys(v reflect.Value, prefix string, keys *[]string) {
+	switch {
+	case v.Kind() == reflect.Map:
+		var names []string
+		for _, k := range v.MapKeys() {
+			names = append(names, k.String())
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			collectKeys(v.MapIndex(reflect.ValueOf(name)), prefix+name+".", keys)
+		}
+	case v.Kind() == reflect.Struct && v.Type() != reflect.TypeOf(Decimal{}):
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Tag.Get("yaml")
+			collectKeys(v.Field(i), prefix+name+".", keys)
+		}
+	default:
+		*keys = append(*keys, strings.TrimSuffix(prefix, "."))
+	}
+}
+
+// Set changes a setting given by its key. The value is written as in the config file. Keys of map entries which do
+// not exist yet add a new entry, e.g. rate_limits.tiers.premium.auth.
+func (c *Config) Set(key string, value string) error {
+	if err := set(reflect.ValueOf(c).Elem(), strings.Split(key, "."), value); err != nil {
+		return fmt.Errorf("%v: %v", key, err)
+	}
+	return nil
+}
+
+func set(v reflect.Value, path []string, value string) error {
+	if len(path) == 0 {
+		elem := reflect.New(v.Type())
+		if err := yaml.UnmarshalStrict([]byte(value), elem.Interface()); err != nil {
+			return fmt.Errorf("invalid value: %v", err)
+		}
+		v.Set(elem.Elem())
+		return nil
+	}
+
+	switch {
+	case v.Kind() == reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+
+		// map entries are not addressable, the entry is changed on a copy and stored again
+		key := reflect.ValueOf(path[0])
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(key); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := set(elem, path[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+		return nil
+	case v.Kind() == reflect.Struct && v.Type() != reflect.TypeOf(Decimal{}):
+		field, ok := fieldByTag(v, path[0])
+		if !ok {
+			return errors.New("unknown setting")
+		}
+		return set(field, path[1:], value)
+	default:
+		return errors.New("unknown setting")
+	}
+}
+
+func fieldByTag(v reflect.Value, tag string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).Tag.Get("yaml") == tag {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// String returns the settings in the format of the config file. The quote key and the passwords in the storage DSN
+// and of the mail server are masked.
+func (c *Config) String() string {
+	shown := *c
+	shown.Storage.DSN = maskPassword(c.Storage.DSN)
+	if shown.Server.QuoteKey != "" {
+		shown.Server.QuoteKey = "xxxxx"
+	}
+	if shown.Mail.SMTPPassword != "" {
+		shown.Mail.SMTPPassword = "xxxxx"
+	}
+
+	buf, err := yaml.Marshal(&shown)
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
+
+// dsnPassword matches the password of a key=value connection string, the value may be quoted
+var dsnPassword = regexp.MustCompile(`(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// maskPassword masks the password of a connection URL, given as user info or parameter, or of a key=value
+// connection string
+func maskPassword(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" || u.Opaque != "" {
+		return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+	}
+
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+	}
+	if q := u.Query(); q.Get("password") != "" {
+		q.Set("password", "xxxxx")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
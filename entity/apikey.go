@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// ScopeRead allows reading the account, its orders and the other accounts
+	ScopeRead = "read"
+	// ScopeTrade allows buying, selling and placing or cancelling orders
+	ScopeTrade = "trade"
+	// ScopeStream allows connecting to the web sockets
+	ScopeStream = "stream"
+)
+
+// Scopes lists all scopes an API key can be granted
+var Scopes = []string{ScopeRead, ScopeTrade, ScopeStream}
+
+// APIKey lets a program act on behalf of a user within the key's scopes without knowing the user's password.
+// Only a hash of the key is stored, the key itself is shown once when it is created.
+type APIKey struct {
+	ID     int64
+	Login  string
+	Name   string
+	Prefix string // first characters of the key, to tell keys apart
+	Scopes []string
+	// AllowedIPs restricts the key to the given addresses or CIDR ranges, the key can be used from anywhere if empty
+	AllowedIPs []string
+	Created    time.Time
+	// Expires is the time the key becomes invalid, it never expires if zero
+	Expires time.Time
+	Hash    string `json:"-"`
+	// Key is only set when the key has been created
+	Key string `json:",omitempty"`
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.Expires.IsZero() && !now.Before(k.Expires)
+}
+
+// AllowsIP tells whether the key may be used from the given address
+func (k *APIKey) AllowsIP(ip string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, allowed := range k.AllowedIPs {
+		if strings.Contains(allowed, "/") {
+			if _, network, err := net.ParseCIDR(allowed); err == nil && network.Contains(addr) {
+				return true
+			}
+		} else if allowedAddr := net.ParseIP(allowed); allowedAddr != nil && allowedAddr.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package entity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAllowsIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		ip      string
+		want    bool
+	}{
+		{"no allowlist", nil, "203.0.113.7", true},
+		{"listed address", []string{"203.0.113.7"}, "203.0.113.7", true},
+		{"other address", []string{"203.0.113.7"}, "203.0.113.8", false},
+		{"address in range", []string{"10.0.0.0/8"}, "10.1.2.3", true},
+		{"address outside range", []string{"10.0.0.0/8"}, "11.1.2.3", false},
+		{"second entry", []string{"10.0.0.0/8", "203.0.113.7"}, "203.0.113.7", true},
+		{"IPv6 address", []string{"2001:db8::/32"}, "2001:db8::1", true},
+		{"IPv4 mapped IPv6 address", []string{"203.0.113.7"}, "::ffff:203.0.113.7", true},
+		{"invalid client address", []string{"10.0.0.0/8"}, "unknown", false},
+	}
+
+	for _, tt := range tests {
+		k := APIKey{AllowedIPs: tt.allowed}
+		if got := k.AllowsIP(tt.ip); got != tt.want {
+			t.Errorf("%v: AllowsIP(%v) = %v, want %v", tt.name, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyHasScope(t *testing.T) {
+	k := APIKey{Scopes: []string{ScopeRead, ScopeStream}}
+
+	tests := []struct {
+		scope string
+		want  bool
+	}{
+		{ScopeRead, true},
+		{ScopeStream, true},
+		{ScopeTrade, false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := k.HasScope(tt.scope); got != tt.want {
+			t.Errorf("HasScope(%q) = %v, want %v", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyExpired(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		expires time.Time
+		want    bool
+	}{
+		{"never expires", time.Time{}, false},
+		{"expires later", now.Add(time.Second), false},
+		{"expires now", now, true},
+		{"expired", now.Add(-time.Second), true},
+	}
+
+	for _, tt := range tests {
+		k := APIKey{Expires: tt.expires}
+		if got := k.Expired(now); got != tt.want {
+			t.Errorf("%v: Expired() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
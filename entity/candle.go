@@ -0,0 +1,18 @@
+package entity
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// Candle aggregates the prices and traded volume of one asset over one interval
+type Candle struct {
+	Asset    string
+	Interval string
+	Start    time.Time
+	Open     decimal.Decimal
+	High     decimal.Decimal
+	Low      decimal.Decimal
+	Close    decimal.Decimal
+	Volume   decimal.Decimal
+}
@@ -0,0 +1,13 @@
+package entity
+
+import "time"
+
+// EmailConfirmation is a pending change of a user's email address. The new address is only stored in the account once
+// the token mailed to it has been presented. Only a hash of the token is stored.
+type EmailConfirmation struct {
+	TokenHash string `json:"-"`
+	Login     string
+	Email     string
+	Created   time.Time
+	Expires   time.Time
+}
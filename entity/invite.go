@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+// InviteCode lets people register an account while registration is restricted to invited users
+type InviteCode struct {
+	Code string
+	// CreatedBy is the login of the admin who created the code, empty if it was created on the command line
+	CreatedBy string
+	Created   time.Time
+	// Expires is the time the code becomes invalid, it never expires if zero
+	Expires time.Time
+	// MaxUses limits the number of accounts registered with the code, 0 allows any number
+	MaxUses int
+	Uses    int
+}
+
+// Valid tells whether an account can be registered with the code
+func (i *InviteCode) Valid(now time.Time) bool {
+	return (i.Expires.IsZero() || now.Before(i.Expires)) && (i.MaxUses == 0 || i.Uses < i.MaxUses)
+}
@@ -0,0 +1,18 @@
+package entity
+
+import "time"
+
+// AuthFailure counts the failed password attempts for a login or from a client address. Further attempts are
+// rejected while it is locked.
+type AuthFailure struct {
+	// Key is "login:" followed by the login or "ip:" followed by the client address
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	// LockedUntil is the end of the lockout, zero if the key has never been locked
+	LockedUntil time.Time
+}
+
+func (f *AuthFailure) Locked(now time.Time) bool {
+	return now.Before(f.LockedUntil)
+}
@@ -0,0 +1,56 @@
+package entity
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+const (
+	OrderTypeLimit      = "limit"
+	OrderTypeStop       = "stop"
+	OrderTypeTakeProfit = "take_profit"
+
+	OrderSideBuy  = "buy"
+	OrderSideSell = "sell"
+
+	OrderStatusOpen      = "open"
+	OrderStatusFilled    = "filled"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRejected  = "rejected"
+)
+
+type Order struct {
+	ID        int64
+	Login     string
+	Type      string
+	Side      string
+	Asset     string
+	Amount    decimal.Decimal
+	Price     decimal.Decimal
+	Status    string
+	Created   time.Time
+	Updated   time.Time
+	FillPrice decimal.Decimal
+}
+
+// Reserved returns the balance (buy) or the asset amount (sell) held back while the order is open.
+// Only limit orders reserve anything, stop and take profit orders are executed as market orders when triggered.
+func (o *Order) Reserved() decimal.Decimal {
+	if o.Type != OrderTypeLimit {
+		return decimal.Zero
+	}
+	if o.Side == OrderSideBuy {
+		return o.Amount.Mul(o.Price)
+	}
+	return o.Amount
+}
+
+// Triggers reports whether the given market price executes the order.
+// Limit and take profit orders buy at or below and sell at or above their price, stop orders the other way round.
+func (o *Order) Triggers(price decimal.Decimal) bool {
+	triggersBelow := (o.Side == OrderSideBuy) != (o.Type == OrderTypeStop)
+	if triggersBelow {
+		return price.LessThanOrEqual(o.Price)
+	}
+	return price.GreaterThanOrEqual(o.Price)
+}
@@ -0,0 +1,59 @@
+package entity
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+)
+
+func TestOrderTriggers(t *testing.T) {
+	tests := []struct {
+		typ, side string
+		price     string
+		want      bool
+	}{
+		{OrderTypeLimit, OrderSideBuy, "9.99", true},
+		{OrderTypeLimit, OrderSideBuy, "10", true},
+		{OrderTypeLimit, OrderSideBuy, "10.01", false},
+		{OrderTypeLimit, OrderSideSell, "9.99", false},
+		{OrderTypeLimit, OrderSideSell, "10", true},
+		{OrderTypeLimit, OrderSideSell, "10.01", true},
+		{OrderTypeTakeProfit, OrderSideBuy, "9.99", true},
+		{OrderTypeTakeProfit, OrderSideBuy, "10.01", false},
+		{OrderTypeTakeProfit, OrderSideSell, "9.99", false},
+		{OrderTypeTakeProfit, OrderSideSell, "10.01", true},
+		{OrderTypeStop, OrderSideBuy, "9.99", false},
+		{OrderTypeStop, OrderSideBuy, "10", true},
+		{OrderTypeStop, OrderSideBuy, "10.01", true},
+		{OrderTypeStop, OrderSideSell, "9.99", true},
+		{OrderTypeStop, OrderSideSell, "10", true},
+		{OrderTypeStop, OrderSideSell, "10.01", false},
+	}
+
+	for _, tt := range tests {
+		o := Order{Type: tt.typ, Side: tt.side, Amount: decimal.NewFromInt(1), Price: decimal.NewFromInt(10)}
+		if got := o.Triggers(decimal.RequireFromString(tt.price)); got != tt.want {
+			t.Errorf("%v %v order at 10: Triggers(%v) = %v, want %v", tt.typ, tt.side, tt.price, got, tt.want)
+		}
+	}
+}
+
+func TestOrderReserved(t *testing.T) {
+	tests := []struct {
+		typ, side string
+		want      string
+	}{
+		{OrderTypeLimit, OrderSideBuy, "25"},
+		{OrderTypeLimit, OrderSideSell, "2.5"},
+		{OrderTypeStop, OrderSideBuy, "0"},
+		{OrderTypeStop, OrderSideSell, "0"},
+		{OrderTypeTakeProfit, OrderSideBuy, "0"},
+		{OrderTypeTakeProfit, OrderSideSell, "0"},
+	}
+
+	for _, tt := range tests {
+		o := Order{Type: tt.typ, Side: tt.side, Amount: decimal.RequireFromString("2.5"), Price: decimal.NewFromInt(10)}
+		if got := o.Reserved(); !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("%v %v order: Reserved() = %v, want %v", tt.typ, tt.side, got, tt.want)
+		}
+	}
+}
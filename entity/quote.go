@@ -0,0 +1,16 @@
+package entity
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// Quote is a price offer for one trade, valid until it expires or has been used
+type Quote struct {
+	ID      string
+	Side    string
+	Asset   string
+	Amount  decimal.Decimal
+	Price   decimal.Decimal
+	Expires time.Time
+}
@@ -0,0 +1,23 @@
+package entity
+
+import "time"
+
+// Session is created when a user logs in with login and password. Requests are authenticated by the access token
+// until it expires, the refresh token then replaces both tokens. Only hashes of the tokens are stored.
+type Session struct {
+	AccessTokenHash  string
+	RefreshTokenHash string
+	Login            string
+	Created          time.Time
+	AccessExpires    time.Time
+	RefreshExpires   time.Time
+}
+
+// SessionTokens are handed out once when a session is created or refreshed
+type SessionTokens struct {
+	AccessToken    string
+	RefreshToken   string
+	TokenType      string
+	AccessExpires  time.Time
+	RefreshExpires time.Time
+}
@@ -0,0 +1,19 @@
+package entity
+
+// TOTP holds the two-factor authentication settings of a user, see RFC 6238
+type TOTP struct {
+	// Secret is the base32 encoded key shared with the authenticator app, empty if the user has not enrolled
+	Secret string
+	// Enabled is set once the user has confirmed the enrollment with a valid code
+	Enabled bool
+	// RecoveryCodeHashes are the hashes of the unused recovery codes
+	RecoveryCodeHashes []string
+	// LastStep is the time step of the last accepted code, so a code can not be used twice
+	LastStep int64
+}
+
+// TOTPEnrollment is handed out once when a user enrolls, the URL is usually shown as QR code
+type TOTPEnrollment struct {
+	Secret string
+	URL    string
+}
@@ -9,10 +9,14 @@ type MarketAsset struct {
 	Name  string
 	Price decimal.Decimal
 	When  time.Time
+	// Halted is set while trading of the asset has been halted by an admin
+	Halted bool `json:",omitempty"`
 }
 
 type Transaction struct {
 	Asset  string
 	Amount decimal.Decimal
+	// Quote optionally refers to a quote ID, executing the transaction at the quoted price
+	Quote string
 }
 
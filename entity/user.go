@@ -2,12 +2,17 @@ package entity
 
 import (
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/base64"
+	"fmt"
 	"github.com/shopspring/decimal"
-	"log"
+	"golang.org/x/crypto/bcrypt"
 	"strings"
 )
 
+// PasswordHashCost is the bcrypt cost of new password hashes. Hashes of a different cost are replaced on the next login.
+const PasswordHashCost = bcrypt.DefaultCost
+
 type UserAsset struct {
 	Name   string
 	Amount decimal.Decimal
@@ -22,6 +27,13 @@ type PublicAccount struct {
 type Account struct {
 	PublicAccount
 	Email    string
+	// Tier selects the rate limits applying to the user, the default limits apply if empty
+	Tier     string
+	Admin    bool
+	// Disabled accounts can not authenticate anymore
+	Disabled bool
+	// TOTPEnabled accounts need a code of their authenticator app to log in, see TOTP
+	TOTPEnabled bool
 	password string
 }
 
@@ -33,25 +45,59 @@ func (acc *Account) GetPassword() string {
 	return acc.password
 }
 
-func (acc *Account) SetAndHashPassword(pw string) {
-	acc.password = HashEncodePassword(pw)
+func (acc *Account) SetAndHashPassword(pw string) error {
+	h, err := HashEncodePassword(pw)
+	if err != nil {
+		return err
+	}
+
+	acc.password = h
+	return nil
 }
 
+// VerifyPassword compares the password with the account's bcrypt hash. Accounts created before bcrypt was
+// introduced still carry an unsalted SHA-1 hash, which is accepted until it has been replaced, see NeedsRehash.
 func (acc *Account) VerifyPassword(pw string) bool {
-	hashedPw := HashEncodePassword(pw)
-	return acc.password == hashedPw
+	if !isBcryptHash(acc.password) {
+		legacyHash := legacyHashEncodePassword(pw)
+		return subtle.ConstantTimeCompare([]byte(acc.password), []byte(legacyHash)) == 1
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(acc.password), []byte(pw)) == nil
+}
+
+// NeedsRehash tells whether the password hash is outdated and should be replaced after a successful login
+func (acc *Account) NeedsRehash() bool {
+	if !isBcryptHash(acc.password) {
+		return true
+	}
+
+	cost, err := bcrypt.Cost([]byte(acc.password))
+	return err != nil || cost != PasswordHashCost
 }
 
-func HashEncodePassword(pw string) string {
+// HashEncodePassword returns the salted bcrypt hash of the password
+func HashEncodePassword(pw string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(pw), PasswordHashCost)
+	if err != nil {
+		return "", fmt.Errorf("password hashing failed: %v", err)
+	}
+
+	return string(h), nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2")
+}
+
+// legacyHashEncodePassword returns the unsalted SHA-1 hash stored for passwords before bcrypt was introduced
+func legacyHashEncodePassword(pw string) string {
 	h := sha1.Sum([]byte(pw))
 
+	// writing to a strings.Builder never fails. The encoder was never closed, the stored hashes lack the last bytes.
 	var mimeEncodedHash = &strings.Builder{}
 	enc := base64.NewEncoder(base64.StdEncoding, mimeEncodedHash)
-	_, err := enc.Write(h[:])
-	if err != nil {
-		log.Printf("password hashing failed: %v", err)
-		return ""
-	}
+	enc.Write(h[:])
 
 	return mimeEncodedHash.String()
 }
@@ -0,0 +1,193 @@
+// Package logging writes the server's log messages as JSON lines with a level and fields like the request ID
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+	"tradingServer/config"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of the given name: debug, info, warn or error
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if n == name {
+			return Level(l), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level '%v'", name)
+}
+
+var (
+	mu       sync.Mutex
+	out      io.Writer = os.Stderr
+	minLevel           = LevelInfo
+)
+
+// Setup writes the log to the configured file, which is rotated once it reaches its maximum size. Messages of the
+// standard library's log package, e.g. of the HTTP server, are logged as errors.
+func Setup(cfg config.LogConfig) error {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	out = &lumberjack.Logger{
+		Filename:   cfg.File,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxBackups: cfg.MaxBackups,
+		// lumberjack counts in days, a partial day keeps the file for a day
+		MaxAge: int(math.Ceil(cfg.MaxAge.Hours() / 24)),
+	}
+	minLevel = level
+	mu.Unlock()
+
+	log.SetFlags(0)
+	log.SetOutput(Writer(LevelError))
+	return nil
+}
+
+// Logger adds its fields to every message
+type Logger struct {
+	fields []field
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+var std = &Logger{}
+
+// With returns a logger adding the field to the messages of l
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return &Logger{fields: append(fields, field{key: key, value: value})}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.write(LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.write(LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.write(LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.write(LevelError, fmt.Sprintf(format, args...))
+}
+
+// Fatalf logs an error and ends the process
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.write(LevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// write formats the message as one JSON object per line, with the time, level and message first
+func (l *Logger) write(level Level, msg string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if level < minLevel {
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeValue(&buf, time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeValue(&buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeValue(&buf, msg)
+	for _, f := range l.fields {
+		buf.WriteByte(',')
+		writeValue(&buf, f.key)
+		buf.WriteByte(':')
+		writeValue(&buf, f.value)
+	}
+	buf.WriteString("}\n")
+
+	out.Write(buf.Bytes())
+}
+
+// writeValue appends the value as JSON, without escaping HTML characters like the arrows in gin's messages
+func writeValue(buf *bytes.Buffer, value interface{}) {
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		data.Reset()
+		enc.Encode(fmt.Sprint(value))
+	}
+	buf.Write(bytes.TrimSuffix(data.Bytes(), []byte("\n")))
+}
+
+// With returns a logger adding the field to its messages
+func With(key string, value interface{}) *Logger {
+	return std.With(key, value)
+}
+
+func Debugf(format string, args ...interface{}) {
+	std.Debugf(format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	std.Infof(format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	std.Warnf(format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	std.Errorf(format, args...)
+}
+
+func Fatalf(format string, args ...interface{}) {
+	std.Fatalf(format, args...)
+}
+
+// Writer logs every line written to it as a message of the given level, e.g. the output of libraries
+func Writer(level Level) io.Writer {
+	return lineWriter{level: level}
+}
+
+type lineWriter struct {
+	level Level
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			std.write(w.level, string(line))
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// capture logs to a buffer at the given level until the test ends
+func capture(t *testing.T, level Level) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mu.Lock()
+	prevOut, prevLevel := out, minLevel
+	out, minLevel = &buf, level
+	mu.Unlock()
+
+	t.Cleanup(func() {
+		mu.Lock()
+		out, minLevel = prevOut, prevLevel
+		mu.Unlock()
+	})
+	return &buf
+}
+
+// messages parses the JSON lines written to the buffer
+func messages(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var msgs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatalf("log line is no JSON object: %v: %v", line, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestLogger(t *testing.T) {
+	buf := capture(t, LevelInfo)
+
+	l := With("request_id", "abc").With("status", 404)
+	l.Debugf("not logged")
+	l.Warnf("no route %v", "/x")
+	Errorf("failed <- %v", "db")
+
+	msgs := messages(t, buf)
+	if len(msgs) != 2 {
+		t.Fatalf("logged %v messages, want 2:\n%v", len(msgs), buf)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"level", msgs[0]["level"], "warn"},
+		{"message", msgs[0]["msg"], "no route /x"},
+		{"field", msgs[0]["request_id"], "abc"},
+		{"number field", msgs[0]["status"], 404.0},
+		{"level without fields", msgs[1]["level"], "error"},
+		{"field of other logger", msgs[1]["request_id"], nil},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%v = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if _, ok := msgs[0]["time"]; !ok {
+		t.Error("message without time")
+	}
+	// HTML characters are kept readable
+	if !strings.Contains(buf.String(), "failed <- db") {
+		t.Errorf("message has been escaped:\n%v", buf)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	buf := capture(t, LevelDebug)
+
+	w := Writer(LevelWarn)
+	w.Write([]byte("first line\n\nsecond line\n"))
+
+	msgs := messages(t, buf)
+	if len(msgs) != 2 || msgs[0]["msg"] != "first line" || msgs[1]["msg"] != "second line" || msgs[1]["level"] != "warn" {
+		t.Errorf("Writer() logged %v, want two warnings without the empty line", msgs)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{"debug", LevelDebug, false},
+		{"error", LevelError, false},
+		{"fatal", LevelInfo, true},
+		{"", LevelInfo, true},
+	}
+
+	for _, tt := range tests {
+		level, err := ParseLevel(tt.name)
+		if level != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseLevel(%v) = %v, %v, want %v, error %v", tt.name, level, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Package metrics holds the Prometheus metrics of the server, which are served by GET /metrics
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/shopspring/decimal"
+)
+
+const namespace = "tradingserver"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests by route and method, web sockets included.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	Trades = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "trades_total",
+		Help:      "Executed trades by asset and side, filled orders included.",
+	}, []string{"asset", "side"})
+
+	TradedNotional = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "traded_notional_total",
+		Help:      "Money paid for bought respectively received for sold assets by asset and side.",
+	}, []string{"asset", "side"})
+
+	WebsocketClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_clients",
+		Help:      "Connected web socket clients.",
+	})
+
+	DroppedStreamEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_events_dropped_total",
+		Help:      "Price and candle updates not sent to web socket clients which did not keep up.",
+	})
+
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limit by group of endpoints.",
+	}, []string{"group"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time taken by database statements by kind, e.g. select or insert.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+	}, []string{"statement"})
+)
+
+// RecordTrade counts a committed trade and its value
+func RecordTrade(asset, side string, notional decimal.Decimal) {
+	Trades.WithLabelValues(asset, side).Inc()
+	TradedNotional.WithLabelValues(asset, side).Add(notional.InexactFloat64())
+}
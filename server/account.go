@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"tradingServer/serviceUser"
+)
+
+type passwordChangeRequest struct {
+	OldPassword string
+	NewPassword string
+}
+
+type emailChangeRequest struct {
+	Email string
+}
+
+func (s *server) handleChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req passwordChangeRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		if !s.checkLockout(c, login) {
+			return
+		}
+		if _, ok = s.verifyPassword(c, login, req.OldPassword); !ok {
+			abortWithError(c, http.StatusForbidden, codeInvalidCredentials, "old password is incorrect")
+			return
+		}
+		if req.NewPassword == req.OldPassword {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "new password must differ from the old one")
+			return
+		}
+		if err := serviceUser.CheckPasswordStrength(login, req.NewPassword, s.config.Accounts.MinPasswordLength); err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+			return
+		}
+
+		// the session the password is changed with stays logged in, all others are logged out
+		if err := serviceUser.ChangePassword(s.dbFromContext(c), login, req.NewPassword, c.GetString("session")); err != nil {
+			logger(c).Errorf("changing password of login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		logger(c).Infof("user '%v' changed the password", login)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (s *server) handleChangeEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req emailChangeRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		if err := serviceUser.ValidateEmail(req.Email); err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+			return
+		}
+
+		db := s.dbFromContext(c)
+		acc, err := db.GetAccount(login)
+		if err != nil {
+			logger(c).Errorf("get account for login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+		if acc.Email == req.Email {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v is your email address already", req.Email)
+			return
+		}
+
+		confirmation, err := serviceUser.RequestEmailChange(db, s.mailer, login, req.Email,
+			s.config.Server.PublicURL, s.config.Accounts.EmailConfirmationTTL)
+		if err != nil {
+			logger(c).Errorf("requesting email change of login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusAccepted, confirmation)
+	}
+}
+
+func (s *server) handleConfirmEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "token is required")
+			return
+		}
+
+		db := s.dbFromContext(c)
+		confirmation, previous, err := serviceUser.ConfirmEmailChange(db, token)
+		switch {
+		case errors.Is(err, serviceUser.ErrInvalidConfirmationToken):
+			abortWithError(c, http.StatusNotFound, codeNotFound, "%v, change the email address again", err)
+			return
+		case err != nil:
+			logger(c).Errorf("confirming email change failed: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+		c.Set("login", confirmation.Login)
+
+		if previous != "" && previous != confirmation.Email {
+			db.AfterCommit(func() {
+				if err := serviceUser.NotifyEmailChanged(s.mailer, confirmation.Login, previous, confirmation.Email); err != nil {
+					logger(c).Errorf("notifying login '%v' of the email change failed: %v", confirmation.Login, err)
+				}
+			})
+		}
+
+		logger(c).Infof("user '%v' changed the email address", confirmation.Login)
+		respond(c, http.StatusOK, confirmation)
+	}
+}
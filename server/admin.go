@@ -0,0 +1,444 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"strconv"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/serviceMarket"
+	"tradingServer/serviceUser"
+	"tradingServer/storage"
+)
+
+const defaultAdminLogCount = 100
+
+type adminUserRequest struct {
+	Login    string
+	Password string
+	Email    string
+	Balance  *decimal.Decimal
+}
+
+// adminUserChange holds the changes to a user, fields not given are left as they are
+type adminUserChange struct {
+	Admin    *bool
+	Disabled *bool
+	Balance  *decimal.Decimal
+	Tier     *string
+	// TOTPEnabled can only be set to false, for users who lost their authenticator app and recovery codes
+	TOTPEnabled *bool
+}
+
+type adminAssetRequest struct {
+	Name  string
+	Price decimal.Decimal
+}
+
+type adminAssetChange struct {
+	Halted *bool
+}
+
+type adminCreatedUser struct {
+	*entity.Account
+	// Password is only set if it has been generated
+	Password string `json:",omitempty"`
+}
+
+type adminInviteRequest struct {
+	MaxUses int
+	Expires time.Time
+}
+
+type adminLogs struct {
+	Access       []storage.AccessLogEntry
+	Transactions []storage.TransactionLogEntry
+}
+
+func (s *server) handleAdminUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accounts, err := s.dbFromContext(c).GetAccounts()
+		if err != nil {
+			logger(c).Errorf("get accounts failed: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusOK, accounts)
+	}
+}
+
+func (s *server) handleAdminUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		acc, ok := s.adminGetAccount(c, c.Param("login"))
+		if !ok {
+			return
+		}
+
+		respond(c, http.StatusOK, acc)
+	}
+}
+
+func (s *server) handleAdminCreateUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req adminUserRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		if err := serviceUser.ValidateLogin(req.Login); err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+			return
+		}
+
+		balance := s.config.Accounts.StartingBalance.Decimal
+		if req.Balance != nil {
+			if req.Balance.IsNegative() {
+				abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "balance must not be negative")
+				return
+			}
+			balance = *req.Balance
+		}
+
+		created := adminCreatedUser{}
+		if req.Password == "" {
+			req.Password = serviceUser.GenPassword(24)
+			created.Password = req.Password
+		}
+
+		db := s.dbFromContext(c)
+		_, err := db.GetAccount(req.Login)
+		if err == nil {
+			abortWithError(c, http.StatusConflict, codeConflict, "login '%v' is taken already", req.Login)
+			return
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			logger(c).Errorf("query account of '%v' failed: %v", req.Login, err)
+			abortWithInternalError(c)
+			return
+		}
+		if err = db.AddAccount(req.Login, req.Password, req.Email, balance); err != nil {
+			logger(c).Errorf("create user '%v' failed: %v", req.Login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		var ok bool
+		if created.Account, ok = s.adminGetAccount(c, req.Login); !ok {
+			return
+		}
+
+		logger(c).Infof("admin '%v' created user '%v'", c.GetString("login"), req.Login)
+		respond(c, http.StatusCreated, created)
+	}
+}
+
+func (s *server) handleAdminChangeUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req adminUserChange
+		if !readJSON(c, &req) {
+			return
+		}
+
+		db := s.dbFromContext(c)
+		acc, ok := s.adminGetAccount(c, c.Param("login"))
+		if !ok {
+			return
+		}
+
+		if acc.Login == c.GetString("login") && ((req.Admin != nil && !*req.Admin) || (req.Disabled != nil && *req.Disabled)) {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest,
+				"you can not disable yourself or revoke your own admin role")
+			return
+		}
+
+		if req.TOTPEnabled != nil && *req.TOTPEnabled {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest,
+				"two-factor authentication can only be enabled by the user")
+			return
+		}
+
+		var err error
+		if req.TOTPEnabled != nil {
+			err = serviceUser.DisableTOTP(db, acc.Login)
+		}
+		if err == nil && req.Admin != nil {
+			err = db.SetAccountAdmin(acc.Login, *req.Admin)
+		}
+		if err == nil && req.Disabled != nil {
+			err = db.SetAccountDisabled(acc.Login, *req.Disabled)
+		}
+		if err == nil && req.Tier != nil {
+			err = db.SetAccountTier(acc.Login, *req.Tier)
+		}
+		if err == nil && req.Balance != nil {
+			if req.Balance.IsNegative() {
+				abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "balance must not be negative")
+				return
+			}
+			err = s.adminSetBalance(db, acc, *req.Balance)
+		}
+		if err != nil {
+			logger(c).Errorf("changing user '%v' failed: %v", acc.Login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		if acc, ok = s.adminGetAccount(c, acc.Login); !ok {
+			return
+		}
+
+		logger(c).Infof("admin '%v' changed user '%v'", c.GetString("login"), acc.Login)
+		respond(c, http.StatusOK, acc)
+	}
+}
+
+// adminSetBalance replaces the balance of an account and records the change in the transaction log
+func (s *server) adminSetBalance(db storage.Storage, acc *entity.Account, balance decimal.Decimal) error {
+	change := balance.Sub(acc.Balance)
+	acc.Balance = balance
+	if err := db.SaveAccount(*acc); err != nil {
+		return err
+	}
+
+	return db.LogTransaction(storage.TransactionLogEntry{
+		Time:       time.Now().Format(time.RFC3339),
+		Login:      acc.Login,
+		Action:     "set_balance",
+		PricePayed: change.Neg(),
+		Balance:    balance,
+	})
+}
+
+func (s *server) handleAdminDeleteUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		login := c.Param("login")
+		if login == c.GetString("login") {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "you can not delete yourself")
+			return
+		}
+
+		acc, ok := s.adminGetAccount(c, login)
+		if !ok {
+			return
+		}
+
+		db := s.dbFromContext(c)
+		if err := s.orderBook.ForgetUserOrders(db, login); err != nil {
+			logger(c).Errorf("get orders of user '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+		if err := db.RemoveAccount(&acc.PublicAccount); err != nil {
+			logger(c).Errorf("deleting user '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		logger(c).Infof("admin '%v' deleted user '%v'", c.GetString("login"), login)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (s *server) handleAdminAddAsset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req adminAssetRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		if req.Name == "" {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "name is required")
+			return
+		}
+
+		db := s.dbFromContext(c)
+		if err := serviceMarket.AddAsset(db, req.Name, req.Price); err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "could not add asset '%v': %v", req.Name, err)
+			return
+		}
+
+		// the instance running the market starts or stops the price maker, others leave it to that instance
+		db.AfterCommit(func() {
+			if s.leadingMarket() {
+				s.priceMakers.Start(req.Name, req.Price)
+			}
+		})
+
+		logger(c).Infof("admin '%v' added asset '%v'", c.GetString("login"), req.Name)
+		respond(c, http.StatusCreated, entity.MarketAsset{Name: req.Name, Price: req.Price, When: time.Now()})
+	}
+}
+
+func (s *server) handleAdminChangeAsset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req adminAssetChange
+		if !readJSON(c, &req) {
+			return
+		}
+
+		db := s.dbFromContext(c)
+		asset, ok := s.adminGetAsset(c, c.Param("name"))
+		if !ok {
+			return
+		}
+
+		if req.Halted != nil && *req.Halted != asset.Halted {
+			if err := db.SetAssetHalted(asset.Name, *req.Halted); err != nil {
+				logger(c).Errorf("halting asset '%v' failed: %v", asset.Name, err)
+				abortWithInternalError(c)
+				return
+			}
+			asset.Halted = *req.Halted
+
+			name, price, halted := asset.Name, asset.Price, asset.Halted
+			db.AfterCommit(func() {
+				if !s.leadingMarket() {
+					return
+				}
+				if halted {
+					s.priceMakers.Stop(name)
+				} else {
+					s.priceMakers.Start(name, price)
+				}
+			})
+			logger(c).Infof("admin '%v' set halted of asset '%v' to %v", c.GetString("login"), asset.Name, asset.Halted)
+		}
+
+		respond(c, http.StatusOK, asset)
+	}
+}
+
+func (s *server) handleAdminRemoveAsset() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		asset, ok := s.adminGetAsset(c, c.Param("name"))
+		if !ok {
+			return
+		}
+
+		db := s.dbFromContext(c)
+		if err := db.RemoveMarketAsset(asset.Name); err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+			return
+		}
+
+		db.AfterCommit(func() {
+			if s.leadingMarket() {
+				s.priceMakers.Stop(asset.Name)
+			}
+		})
+
+		logger(c).Infof("admin '%v' removed asset '%v'", c.GetString("login"), asset.Name)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (s *server) handleAdminInvites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		codes, err := s.dbFromContext(c).GetInviteCodes()
+		if err != nil {
+			logger(c).Errorf("get invite codes failed: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusOK, codes)
+	}
+}
+
+func (s *server) handleAdminCreateInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req adminInviteRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		code, err := serviceUser.CreateInviteCode(s.dbFromContext(c), c.GetString("login"), req.MaxUses, req.Expires)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+			return
+		}
+
+		logger(c).Infof("admin '%v' created invite code %v", c.GetString("login"), code.Code)
+		respond(c, http.StatusCreated, code)
+	}
+}
+
+func (s *server) handleAdminRevokeInvite() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code := c.Param("code")
+		err := serviceUser.RevokeInviteCode(s.dbFromContext(c), code)
+		switch {
+		case errors.Is(err, serviceUser.ErrInviteCodeNotFound):
+			abortWithError(c, http.StatusNotFound, codeNotFound, "no invite code '%v'", code)
+			return
+		case err != nil:
+			logger(c).Errorf("revoke invite code %v failed: %v", code, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		logger(c).Infof("admin '%v' revoked invite code %v", c.GetString("login"), code)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (s *server) handleAdminLogs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		count := defaultAdminLogCount
+		if countStr := c.Query("count"); countStr != "" {
+			n, err := strconv.Atoi(countStr)
+			if err != nil || n <= 0 {
+				abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "count must be a positive number")
+				return
+			}
+			count = n
+		}
+
+		db := s.dbFromContext(c)
+		var logs adminLogs
+		var err error
+		if logs.Access, err = db.GetAccessLog(count); err == nil {
+			logs.Transactions, err = db.GetTransactionLog(count)
+		}
+		if err != nil {
+			logger(c).Errorf("reading logs failed: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusOK, logs)
+	}
+}
+
+// adminGetAccount returns the account of a login or aborts the request if there is none
+func (s *server) adminGetAccount(c *gin.Context, login string) (*entity.Account, bool) {
+	acc, err := s.dbFromContext(c).GetAccount(login)
+	if errors.Is(err, storage.ErrNotFound) {
+		abortWithError(c, http.StatusNotFound, codeNotFound, "no user '%v'", login)
+		return nil, false
+	}
+	if err != nil {
+		logger(c).Errorf("query account of '%v' failed: %v", login, err)
+		abortWithInternalError(c)
+		return nil, false
+	}
+	return acc, true
+}
+
+// adminGetAsset returns a market asset or aborts the request if there is none
+func (s *server) adminGetAsset(c *gin.Context, name string) (*entity.MarketAsset, bool) {
+	asset, err := s.dbFromContext(c).GetAsset(name)
+	if err != nil {
+		logger(c).Errorf("query asset '%v' failed: %v", name, err)
+		abortWithInternalError(c)
+		return nil, false
+	}
+	if asset == nil {
+		abortWithError(c, http.StatusNotFound, codeUnknownAsset, "no asset '%v'", name)
+		return nil, false
+	}
+	return asset, true
+}
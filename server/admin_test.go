@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tradingServer/config"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+// failingStorage fails every account query
+type failingStorage struct {
+	storage.Storage
+}
+
+func (f failingStorage) GetAccount(login string) (*entity.Account, error) {
+	return nil, errors.New("connection lost")
+}
+
+func TestAdminCreateUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		db         storage.Storage
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"new login", storage.NewMemory(), `{"login": "alice", "password": "Secret123!x"}`, http.StatusCreated, ""},
+		{"login taken", storage.NewMemory(), `{"login": "test"}`, http.StatusConflict, codeConflict},
+		{"invalid login", storage.NewMemory(), `{"login": "-"}`, http.StatusBadRequest, codeInvalidRequest},
+		{"storage failure", failingStorage{storage.NewMemory()}, `{"login": "alice"}`, http.StatusInternalServerError, codeInternalError},
+	}
+
+	for _, tt := range tests {
+		s := &server{config: config.Default(), db: tt.db}
+		r := gin.New()
+		r.Use(s.requestID(), s.recovery())
+		r.POST("/", s.handleAdminCreateUser())
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%v: status = %v, want %v: %v", tt.name, w.Code, tt.wantStatus, w.Body)
+			continue
+		}
+		if tt.wantCode == "" {
+			continue
+		}
+		var res userError
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Code != tt.wantCode {
+			t.Errorf("%v: response = %v, want code %v", tt.name, w.Body, tt.wantCode)
+		}
+		if strings.Contains(res.Message, "connection lost") {
+			t.Errorf("%v: response reveals the storage error: %v", tt.name, res.Message)
+		}
+	}
+}
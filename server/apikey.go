@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+	"tradingServer/serviceUser"
+)
+
+type apiKeyRequest struct {
+	Name       string
+	Scopes     []string
+	AllowedIPs []string
+	Expires    time.Time
+}
+
+func (s *server) handleAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		keys, err := s.dbFromContext(c).GetAPIKeys(login)
+		if err != nil {
+			logger(c).Errorf("get api keys for login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusOK, keys)
+	}
+}
+
+func (s *server) handleCreateAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req apiKeyRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		key, err := serviceUser.CreateAPIKey(s.dbFromContext(c), login, req.Name, req.Scopes, req.AllowedIPs, req.Expires)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+			return
+		}
+
+		respond(c, http.StatusCreated, key)
+	}
+}
+
+func (s *server) handleRevokeAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "invalid api key id '%v'", c.Param("id"))
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		err = serviceUser.RevokeAPIKey(s.dbFromContext(c), login, id)
+		switch {
+		case errors.Is(err, serviceUser.ErrAPIKeyNotFound):
+			abortWithError(c, http.StatusNotFound, codeNotFound, "no api key with id %v", id)
+			return
+		case err != nil:
+			logger(c).Errorf("revoke api key %v of login '%v' failed: %v", id, login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
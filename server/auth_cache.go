@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+	"tradingServer/logging"
+)
+
+// authCache remembers successfully verified credentials for a while, so Basic auth clients sending their password
+// with every request don't pay for the deliberately slow password hash each time. Entries are keyed by an HMAC of
+// login, password and stored hash under a key only known to the process, neither the password nor a fast hash of it
+// is kept. A changed password hash makes the entries of the old one useless.
+type authCache struct {
+	sync.Mutex
+	ttl       time.Duration
+	secret    []byte
+	verified  map[string]time.Time
+	lastSweep time.Time
+}
+
+func newAuthCache(ttl time.Duration) *authCache {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		logging.Warnf("password verification cache disabled: %v", err)
+		ttl = 0
+	}
+
+	return &authCache{
+		ttl:       ttl,
+		secret:    secret,
+		verified:  make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Verified tells whether the credentials have been verified against the password hash within the cache's ttl
+func (ac *authCache) Verified(login, password, hash string) bool {
+	if ac.ttl <= 0 {
+		return false
+	}
+
+	key := ac.key(login, password, hash)
+
+	ac.Lock()
+	defer ac.Unlock()
+
+	expires, ok := ac.verified[key]
+	return ok && time.Now().Before(expires)
+}
+
+// Add remembers credentials which have been verified against the password hash
+func (ac *authCache) Add(login, password, hash string) {
+	if ac.ttl <= 0 {
+		return
+	}
+
+	key := ac.key(login, password, hash)
+
+	ac.Lock()
+	defer ac.Unlock()
+
+	now := time.Now()
+	if now.Sub(ac.lastSweep) >= ac.ttl {
+		for k, expires := range ac.verified {
+			if !now.Before(expires) {
+				delete(ac.verified, k)
+			}
+		}
+		ac.lastSweep = now
+	}
+
+	ac.verified[key] = now.Add(ac.ttl)
+}
+
+func (ac *authCache) key(login, password, hash string) string {
+	mac := hmac.New(sha256.New, ac.secret)
+	for _, s := range []string{login, password, hash} {
+		// the length prefix keeps the fields apart
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(s)))
+		mac.Write(length[:])
+		mac.Write([]byte(s))
+	}
+	return string(mac.Sum(nil))
+}
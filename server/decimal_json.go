@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"reflect"
+	"strings"
+)
+
+var decimalType = reflect.TypeOf(decimal.Decimal{})
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// wantsDecimalStrings reports whether the client opted in to receive decimal numbers as JSON strings
+func wantsDecimalStrings(c *gin.Context) bool {
+	return c.Query("decimals") == "string" || strings.EqualFold(c.GetHeader("X-Decimals"), "string")
+}
+
+// respond renders obj as indented JSON, encoding decimal numbers as strings if the client asked for it
+func respond(c *gin.Context, status int, obj interface{}) {
+	if wantsDecimalStrings(c) {
+		obj = decimalsAsStrings(reflect.ValueOf(obj))
+	}
+	c.IndentedJSON(status, obj)
+}
+
+// jsonObject is a JSON object keeping the order of its fields
+type jsonObject []jsonField
+
+type jsonField struct {
+	name  string
+	value interface{}
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(f.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decimalsAsStrings returns a value encoding to the same JSON as v, except that every decimal.Decimal is encoded as
+// a string. Struct fields are handled like encoding/json does, including json tags and embedded structs.
+func decimalsAsStrings(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.Type() == decimalType {
+		return v.Interface().(decimal.Decimal).String()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return decimalsAsStrings(v.Elem())
+	}
+
+	if v.Type().Implements(jsonMarshalerType) {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		obj := jsonObject{}
+		appendStructFields(&obj, v)
+		return obj
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		fallthrough
+	case reflect.Array:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = decimalsAsStrings(v.Index(i))
+		}
+		return list
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = decimalsAsStrings(iter.Value())
+		}
+		return m
+	default:
+		return v.Interface()
+	}
+}
+
+func appendStructFields(obj *jsonObject, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.SplitN(tag, ",", 2)
+		name, opts := parts[0], ""
+		if len(parts) > 1 {
+			opts = parts[1]
+		}
+		fieldValue := v.Field(i)
+
+		if field.Anonymous && name == "" {
+			if fieldValue.Kind() == reflect.Ptr {
+				if fieldValue.IsNil() {
+					continue
+				}
+				fieldValue = fieldValue.Elem()
+			}
+			if fieldValue.Kind() == reflect.Struct {
+				appendStructFields(obj, fieldValue)
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		if strings.Contains(opts, "omitempty") && isEmptyValue(fieldValue) {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		*obj = append(*obj, jsonField{name: name, value: decimalsAsStrings(fieldValue)})
+	}
+}
+
+// isEmptyValue matches the definition of empty values of the omitempty option in encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Ptr:
+		return v.IsZero()
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+	"tradingServer/storage"
+)
+
+// commit and buildTime describe the build, they are set by
+// go build -ldflags "-X tradingServer/server.commit=$(git rev-parse HEAD) -X tradingServer/server.buildTime=$(date -u +%FT%TZ)"
+var (
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+// streamHubTimeout is the time serveStreamClients has to answer the readiness check
+const streamHubTimeout = time.Second
+
+type health struct {
+	Status string
+}
+
+// readiness tells whether the server can serve requests. Checks holds "ok" or the problem of each dependency.
+type readiness struct {
+	Ready  bool
+	Checks map[string]string
+}
+
+type buildInfo struct {
+	Commit              string
+	BuildTime           string
+	GoVersion           string
+	SchemaVersion       int
+	LatestSchemaVersion int
+}
+
+// handleHealth tells that the process is alive, its dependencies are checked by handleReady
+func (s *server) handleHealth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		respond(c, http.StatusOK, health{Status: "ok"})
+	}
+}
+
+func (s *server) handleReady() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		res := readiness{Ready: true, Checks: make(map[string]string)}
+		checks := map[string]func() string{
+			"database":     s.checkDatabase,
+			"price_makers": s.checkPriceMakers,
+			"stream_hub":   s.checkStreamHub,
+		}
+		for name, check := range checks {
+			res.Checks[name] = "ok"
+			if problem := check(); problem != "" {
+				logger(c).Warnf("readiness check %v failed: %v", name, problem)
+				res.Checks[name] = problem
+				res.Ready = false
+			}
+		}
+
+		status := http.StatusOK
+		if !res.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		respond(c, status, res)
+	}
+}
+
+func (s *server) checkDatabase() string {
+	if err := s.db.Ping(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// checkPriceMakers fails once the price makers have been stopped for good or one of them stopped updating its price.
+// Instances not running the market do not run them, their prices come from the instance which does.
+func (s *server) checkPriceMakers() string {
+	if !s.priceMakers.Running() {
+		return "stopped"
+	}
+	if stale := s.priceMakers.Stale(); len(stale) > 0 {
+		return fmt.Sprintf("no recent price update of %v", strings.Join(stale, ", "))
+	}
+	return ""
+}
+
+// checkStreamHub makes sure serveStreamClients is not stuck, so price updates reach the web socket clients
+func (s *server) checkStreamHub() string {
+	reply := make(chan struct{})
+	select {
+	case s.pingStreams <- reply:
+	case <-time.After(streamHubTimeout):
+		return "not responding"
+	}
+
+	select {
+	case <-reply:
+		return ""
+	case <-time.After(streamHubTimeout):
+		return "stopped"
+	}
+}
+
+func (s *server) handleVersion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version, err := s.db.SchemaVersion()
+		if err != nil {
+			logger(c).Errorf("query schema version failed: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusOK, buildInfo{
+			Commit:              commit,
+			BuildTime:           buildTime,
+			GoVersion:           runtime.Version(),
+			SchemaVersion:       version,
+			LatestSchemaVersion: storage.LatestSchemaVersion(),
+		})
+	}
+}
@@ -1,76 +1,156 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/shopspring/decimal"
 	"io"
-	"log"
 	"net/http"
-	"os"
+	"reflect"
+	"strconv"
 	"sync"
 	"time"
+	"tradingServer/config"
 	"tradingServer/entity"
+	"tradingServer/logging"
+	"tradingServer/metrics"
+	"tradingServer/serviceMail"
+	"tradingServer/serviceMarket"
+	"tradingServer/servicePriceVariation"
 	"tradingServer/serviceTrade"
 	"tradingServer/storage"
 )
 
+const defaultHistoryRange = time.Hour
+const maxHistoryEntries = 10000
+const defaultCandleInterval = "1m"
+const defaultCandleCount = 100
+
+// streamClientBuffer is the number of events queued for a web socket client before further events are dropped
+const streamClientBuffer = 16
+
 type Server interface {
-	Run()
+	// Run serves requests until the context is cancelled and then shuts the server down gracefully
+	Run(ctx context.Context)
 	GetEventInputChannel() chan entity.MarketAsset
 }
 
 type server struct {
-	db               *storage.Database
+	config           *config.Config
+	db               storage.Storage
 	router           *gin.Engine
 	priceUpdates     chan entity.MarketAsset
 	streamClients    []*streamClient
 	registerWsClient chan *streamClient
 	removeWsClient   chan *streamClient
 	rateLimitState   requestRateLimit
+	authCache        *authCache
+	candles          *serviceMarket.CandleAggregator
+	orderBook        *serviceTrade.OrderBook
+	priceMakers      *servicePriceVariation.PriceMakers
+	mailer           serviceMail.Mailer
+	httpServer       *http.Server
+	// leading is set while this instance runs the market, see runMarket
+	marketMu   sync.Mutex
+	leading    bool
+	stopMarket chan struct{}
+	marketDone chan struct{}
+	// stopStreams tells serveStreamClients to close the web sockets, streams tracks the handlers serving them
+	stopStreams chan struct{}
+	streams     sync.WaitGroup
+	// pingStreams asks serveStreamClients to close the given channel, telling it is alive
+	pingStreams chan chan struct{}
 }
 
 type streamClient struct {
 	ws *websocket.Conn
 	sync.RWMutex
-	events   chan entity.MarketAsset
+	events   chan interface{}
 	shutdown bool
+	// candleInterval selects candle updates of the given interval instead of price updates
+	candleInterval string
+	// candleAsset restricts candle updates to one asset if set
+	candleAsset string
+	// decimalStrings sends decimal numbers as JSON strings
+	decimalStrings bool
 }
 
-func NewServer() *server {
+func NewServer(cfg *config.Config, db storage.Storage, mailer serviceMail.Mailer) *server {
+	// the messages of gin go to the server log
+	gin.DefaultWriter = logging.Writer(logging.LevelDebug)
+	gin.DefaultErrorWriter = logging.Writer(logging.LevelError)
+
 	g := gin.New()
 
 	// Disable Console Color, you don't need console color when writing the logs to file.
 	gin.DisableConsoleColor()
 
-	// Logging to a file.
-	f, _ := os.Create("gin.log")
-	gin.DefaultWriter = io.MultiWriter(f)
+	// decimals are sent as JSON numbers unless a client opts in to strings, see respond()
+	decimal.MarshalJSONWithoutQuotes = true
 
 	g.SetTrustedProxies(nil)
 
+	candles := serviceMarket.NewCandleAggregator()
+	n, err := candles.Load(db, time.Now())
+	if err != nil {
+		logging.Fatalf("loading candles from the price history failed: %v", err)
+	}
+	logging.Infof("loaded candles from %v prices of the price history", n)
+
+	orderBook, err := serviceTrade.NewOrderBook(db, candles)
+	if err != nil {
+		logging.Fatalf("loading order book failed: %v", err)
+	}
+
 	s := &server{
-		db:               storage.GetDatabase(),
+		config:           cfg,
+		db:               db,
 		router:           g,
 		priceUpdates:     make(chan entity.MarketAsset),
 		registerWsClient: make(chan *streamClient, 10),
 		removeWsClient:   make(chan *streamClient, 10),
+		stopStreams:      make(chan struct{}),
+		pingStreams:      make(chan chan struct{}),
 		rateLimitState:   requestRateLimit{},
+		authCache:        newAuthCache(cfg.Accounts.AuthCacheTTL),
+		candles:          candles,
+		orderBook:        orderBook,
+		stopMarket:       make(chan struct{}),
+		marketDone:       make(chan struct{}),
+		mailer:           mailer,
 	}
+	s.priceMakers = servicePriceVariation.NewPriceMakers(db, cfg.PriceVariation, s.priceUpdates)
 
+	prometheus.MustRegister(newAssetPriceCollector(db))
 	s.routes()
 
 	return s
 }
 
-func (s *server) Run() {
+func (s *server) Run(ctx context.Context) {
 	go s.serveStreamClients()
-
-	err := s.router.Run(":8002")
-	if err != nil {
-		log.Fatalf("server start failed: %v", err.Error())
+	go s.orderBook.Run()
+	go s.runMarket()
+
+	s.httpServer = &http.Server{Addr: s.config.Server.Listen, Handler: s.router}
+	failed := make(chan error, 1)
+	go func() {
+		failed <- s.httpServer.ListenAndServe()
+	}()
+	logging.Infof("listening on %v", s.config.Server.Listen)
+
+	select {
+	case err := <-failed:
+		logging.Fatalf("server start failed: %v", err.Error())
+	case <-ctx.Done():
 	}
+
+	s.shutdown()
 }
 
 func (s *server) GetEventInputChannel() chan entity.MarketAsset {
@@ -78,18 +158,74 @@ func (s *server) GetEventInputChannel() chan entity.MarketAsset {
 }
 
 func (s *server) routes() {
-	s.router.GET("/", s.accessLog(), s.rateLimit("index", 10), s.handleIndex())
-
-	txProtected := s.router.Group("", s.accessLog(), s.dbTransaction())
-	txProtected.GET("/rates", s.rateLimit("rates", 20), s.dbTransaction(), s.handleRates())
-
-	authenticated := txProtected.Group("", s.authRequired(), s.rateLimit("auth", 100))
-	authenticated.GET("/account", s.handleAccount(false))
-	authenticated.GET("/accounts", s.handleAccount(true))
-	authenticated.POST("/buy", s.handleBuy())
-	authenticated.POST("/sell", s.handleSell())
-
-	authenticated.GET("/rates/stream", s.handlePriceStream())
+	s.router.Use(s.requestID(), s.recovery())
+	s.router.NoRoute(s.handleNoRoute())
+
+	// probes of supervisors and load balancers and metrics scrapes are neither logged nor rate limited
+	s.router.GET("/healthz", s.handleHealth())
+	s.router.GET("/readyz", s.handleReady())
+	s.router.GET("/version", s.handleVersion())
+	s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	s.router.GET("/", s.accessLog(), s.rateLimit("index"), s.handleIndex())
+
+	// read-only requests run without a transaction, so they neither take nor wait for sqlite's write lock
+	readOnly := s.router.Group("", s.accessLog(), s.trackAuthFailures())
+	readOnly.GET("/rates", s.rateLimit("rates"), s.handleRates())
+	readOnly.GET("/rates/history", s.rateLimit("history"), s.handleRatesHistory())
+	readOnly.GET("/rates/candles", s.rateLimit("candles"), s.handleCandles())
+
+	readOnlyAuthenticated := readOnly.Group("", s.authRequired(), s.rateLimit("auth"), s.requireScope(entity.ScopeRead))
+	readOnlyAuthenticated.GET("/account", s.handleAccount(false))
+	readOnlyAuthenticated.GET("/accounts", s.handleAccount(true))
+	readOnlyAuthenticated.GET("/orders", s.handleOrders())
+
+	txProtected := s.router.Group("", s.accessLog(), s.trackAuthFailures(), s.dbTransaction())
+	txProtected.POST("/login", s.rateLimit("login"), s.handleLogin())
+	txProtected.GET("/account/email/confirm", s.rateLimit("login"), s.handleConfirmEmail())
+	txProtected.POST("/register", s.rateLimit("register"), s.handleRegister())
+
+	authenticated := txProtected.Group("", s.authRequired(), s.rateLimit("auth"))
+	authenticated.POST("/buy", s.requireScope(entity.ScopeTrade), s.handleBuy())
+	authenticated.POST("/sell", s.requireScope(entity.ScopeTrade), s.handleSell())
+	authenticated.POST("/quote", s.requireScope(entity.ScopeTrade), s.handleQuote())
+	authenticated.POST("/orders", s.requireScope(entity.ScopeTrade), s.handlePlaceOrder())
+	authenticated.DELETE("/orders/:id", s.requireScope(entity.ScopeTrade), s.handleCancelOrder())
+	authenticated.POST("/logout", s.handleLogout())
+
+	account := authenticated.Group("/account", s.noAPIKey())
+	account.PUT("/password", s.handleChangePassword())
+	account.PUT("/email", s.handleChangeEmail())
+	account.POST("/totp", s.handleEnrollTOTP())
+	account.PUT("/totp", s.handleEnableTOTP())
+	account.DELETE("/totp", s.handleDisableTOTP())
+	account.POST("/totp/recovery", s.handleRegenerateRecoveryCodes())
+
+	apiKeys := authenticated.Group("/apikeys", s.noAPIKey())
+	apiKeys.GET("", s.handleAPIKeys())
+	apiKeys.POST("", s.handleCreateAPIKey())
+	apiKeys.DELETE("/:id", s.handleRevokeAPIKey())
+
+	admin := authenticated.Group("/admin", s.noAPIKey(), s.adminRequired())
+	admin.GET("/users", s.handleAdminUsers())
+	admin.POST("/users", s.handleAdminCreateUser())
+	admin.GET("/users/:login", s.handleAdminUser())
+	admin.PATCH("/users/:login", s.handleAdminChangeUser())
+	admin.DELETE("/users/:login", s.handleAdminDeleteUser())
+	admin.POST("/assets", s.handleAdminAddAsset())
+	admin.PATCH("/assets/:name", s.handleAdminChangeAsset())
+	admin.DELETE("/assets/:name", s.handleAdminRemoveAsset())
+	admin.GET("/invites", s.handleAdminInvites())
+	admin.POST("/invites", s.handleAdminCreateInvite())
+	admin.DELETE("/invites/:code", s.handleAdminRevokeInvite())
+	admin.GET("/lockouts", s.handleAdminLockouts())
+	admin.DELETE("/lockouts/:key", s.handleAdminUnlock())
+	admin.GET("/logs", s.handleAdminLogs())
+
+	// web sockets stay open for long, they must not hold a database transaction
+	streaming := s.router.Group("", s.trackStream(), s.accessLog(), s.trackAuthFailures(), s.authRequired(), s.rateLimit("auth"), s.requireScope(entity.ScopeStream))
+	streaming.GET("/rates/stream", s.handlePriceStream())
+	streaming.GET("/rates/candles/stream", s.handleCandleStream())
 }
 
 func (s *server) handleIndex() gin.HandlerFunc {
@@ -100,10 +236,132 @@ func (s *server) handleIndex() gin.HandlerFunc {
 	<h2>GET requests</h2>
 	<ul>
 	<li><a href="rates">GET assets and their rates</a></li>
+	<li><a href="rates/history">GET rates/history</a> - recorded prices, optionally filtered by
+		<code>?asset=white_wool&amp;from=2022-12-09T12:00:00Z&amp;to=2022-12-09T13:00:00Z</code>.
+		Times are RFC3339 or unix seconds. The range defaults to the last hour.
+		Prices older than a day are kept once per minute, older than a week once per hour.</li>
+	<li><a href="rates/candles">GET rates/candles</a> - open/high/low/close prices and traded volume per interval, optionally filtered by
+		<code>?asset=white_wool&amp;interval=5m&amp;limit=100</code>.
+		Supported intervals are 1s, 1m (default), 5m and 1h. The most recent 500 candles are kept per interval, the prices
+		are restored from the price history on server start, the traded volume counts the trades since.</li>
 	<li><a href="account">GET account</a> - show your account</li>
 	<li><a href="accounts">GET accounts</a> - show all accounts</li>
 	</ul>
 	<p/>
+	<h2>Authentication</h2>
+	Endpoints requiring authentication accept HTTP Basic auth with login and password, or an access token sent as
+	<code>Authorization: Bearer &lt;access token&gt;</code>.
+
+	<p>
+	After 5 failed password attempts for a login, or 20 from one client address, further attempts are rejected with
+	status 429 for a minute. The lockout doubles with every further failure up to an hour. Access tokens and API keys
+	keep working meanwhile.
+
+	<h3>POST /login</h3>
+	Log in with login and password to get an access token and a refresh token. The access token expires after
+	15 minutes, the refresh token after 30 days by default. Sessions survive server restarts.
+	<p>
+	Example JSON input:<br/>
+	<pre>
+{
+	"login": "test",
+	"password": "secret"
+}
+	</pre>
+	Response:
+	<pre>
+{
+	"AccessToken": "Qm9v...",
+	"RefreshToken": "c2Vj...",
+	"TokenType": "Bearer",
+	"AccessExpires": "2022-12-09T12:15:00+01:00",
+	"RefreshExpires": "2023-01-08T12:00:00+01:00"
+}
+	</pre>
+	Send the refresh token instead to replace both tokens before or after the access token has expired.
+	The old tokens are revoked then.
+	<pre>
+{
+	"RefreshToken": "c2Vj..."
+}
+	</pre>
+
+	If you have enabled two-factor authentication, add the current code of your authenticator app, or one of your
+	recovery codes, as <code>"Code"</code>. HTTP Basic auth is not accepted for your account then, use an access token
+	or an API key.
+
+	<h3>POST /register</h3>
+	Create an account if the operator allows registration, with an invite code from an admin if required.
+	The login has 3 to 32 letters, digits, '_', '.' or '-'. The password follows the rules of PUT /account/password.
+	The email address is optional, it is set once confirmed like with PUT /account/email. The confirmation link is
+	mailed once the account has been created.
+	<pre>
+{
+	"Login": "alice",
+	"Password": "correct horse battery",
+	"Email": "alice@example.com",
+	"InviteCode": "MFRGGZDFMZTWQ2LK"
+}
+	</pre>
+	The response shows the new account with its starting balance.
+
+	<h3>POST /logout</h3>
+	Revokes the access token and the refresh token of the session the request is authenticated with.
+
+	<h3>API keys</h3>
+	Programs like trading bots should use an API key instead of your password. Send it as <code>X-API-Key: tsk_...</code>.
+	A key only allows the endpoints of its scopes: <code>read</code> (GET /account, /accounts and /orders),
+	<code>trade</code> (/buy, /sell, /quote and changing orders) and <code>stream</code> (the web sockets).
+	Keys can be restricted to IP addresses or CIDR ranges and expire at the given time, or never if omitted.
+	Keys are managed with your password or session, not with another key:
+	<ul>
+	<li>GET /apikeys - list your keys</li>
+	<li>POST /apikeys - create a key. The response contains the key, it is not shown again.
+	<pre>
+{
+	"Name": "my bot",
+	"Scopes": ["read", "trade"],
+	"AllowedIPs": ["192.0.2.10", "10.0.0.0/8"],
+	"Expires": "2023-12-31T23:59:59Z"
+}
+	</pre></li>
+	<li>DELETE /apikeys/:id - revoke a key</li>
+	</ul>
+
+	<h3>PUT /account/password</h3>
+	Change your password with login and password or a session, not with an API key. The new password needs at least
+	10 characters by default, must not contain your login and must not be a common password. All other sessions are
+	logged out.
+	<pre>
+{
+	"OldPassword": "secret",
+	"NewPassword": "correct horse battery"
+}
+	</pre>
+
+	<h3>PUT /account/email</h3>
+	Change your email address. A confirmation link is mailed to the new address, the address is changed once the link
+	has been opened, GET /account/email/confirm?token=... . The link expires after 24 hours by default.
+	<pre>
+{
+	"Email": "alice@example.com"
+}
+	</pre>
+
+	<h3>Two-factor authentication</h3>
+	Protect your account with time-based codes of an authenticator app. Like the password, the settings can not be
+	changed with an API key.
+	<ul>
+	<li>POST /account/totp - start the setup. The response contains the secret and an <code>otpauth://</code> URL
+	to enter into or scan as QR code with the authenticator app.</li>
+	<li>PUT /account/totp - confirm the setup with the first code, <code>{"Code": "123456"}</code>. The response lists
+	10 recovery codes, each of which can be used once instead of a code. They are not shown again.</li>
+	<li>POST /account/totp/recovery - replace your recovery codes, confirmed with a code</li>
+	<li>DELETE /account/totp - turn two-factor authentication off, confirmed with a code</li>
+	</ul>
+	Wrong codes count as failed password attempts. If the operator has set a threshold, sells worth more than that
+	require the current code in the <code>X-TOTP-Code</code> header.
+
 	<h2>POST requests</h2>
 	<h3>POST /buy</h3>
 	A user can buy any amount of an asset as far as his balance allows from the market.
@@ -127,6 +385,76 @@ func (s *server) handleIndex() gin.HandlerFunc {
 }
 	</pre>
 
+	<h3>POST /quote</h3>
+	Request a price quote for a trade. The returned quote ID is valid for 10 seconds and can be used for one trade, a rejected trade does not use it up.
+	<p>
+	Example JSON input:<br/>
+	<pre>
+{
+	"side": "buy",
+	"asset": "white_wool",
+	"amount": 34.95
+}
+	</pre>
+	Pass the quote ID to POST /buy or /sell (matching the quote's side) to trade at exactly the quoted price.
+	Asset and amount may be omitted then, otherwise they have to match the quote. The trade is rejected if the quote has expired.
+	<pre>
+{
+	"quote": "eyJMb2dpbiI6InRlc3QiLC..."
+}
+	</pre>
+
+	<h3>POST /orders</h3>
+	Place a limit order. It rests on the server until the market price reaches the limit price and is then filled at the
+	market price. A buy order is filled at or below its limit, a sell order at or above.<br/>
+	While the order is open, the money (buy) or the assets (sell) it needs are reserved and taken from your account.
+	Cancelling the order releases the reservation. Open orders survive server restarts.
+	<p>
+	Set <code>"type"</code> to <code>"stop"</code> or <code>"take_profit"</code> for a trigger order instead. It turns
+	into a market buy or sell at the current price as soon as the price crosses its trigger <code>"price"</code>:
+	a stop sells at or below and buys at or above the trigger, a take profit sells at or above and buys at or below.
+	Trigger orders reserve nothing. If your account can not afford the trade when it triggers, the order is rejected.
+	<p>
+	Example JSON input:<br/>
+	<pre>
+{
+	"type": "limit",
+	"side": "buy",
+	"asset": "white_wool",
+	"amount": 10,
+	"price": 42.5
+}
+	</pre>
+
+	<h3>GET /orders</h3>
+	List your orders, newest first. Add <code>?status=open</code> to list open orders only.
+
+	<h3>DELETE /orders/:id</h3>
+	Cancel one of your open orders.
+
+	<h2>Administration</h2>
+	Users with the admin role (see <code>./tradingServer setadmin</code>) can manage the server remotely. API keys can not be used.
+	<ul>
+	<li>GET /admin/users - list all users</li>
+	<li>GET /admin/users/:login - show a user including role and state</li>
+	<li>POST /admin/users - create a user, e.g. <code>{"Login": "alice", "Password": "secret", "Email": "", "Balance": 100}</code>.
+		Password and balance are optional, a generated password is returned once.</li>
+	<li>PATCH /admin/users/:login - change any of <code>{"Disabled": true, "Admin": false, "Balance": 250, "Tier": "premium"}</code>.
+		Disabled users can not authenticate anymore. <code>{"TOTPEnabled": false}</code> turns off two-factor
+		authentication for a user who lost the authenticator app.</li>
+	<li>DELETE /admin/users/:login - delete a user with its assets, orders, sessions and API keys</li>
+	<li>POST /admin/assets - add an asset, e.g. <code>{"Name": "gold", "Price": 1800}</code></li>
+	<li>PATCH /admin/assets/:name - halt or resume trading with <code>{"Halted": true}</code>. The price of a halted asset stands still.</li>
+	<li>DELETE /admin/assets/:name - remove an asset nobody holds and no order refers to</li>
+	<li>GET /admin/invites - list the invite codes with their uses</li>
+	<li>POST /admin/invites - create an invite code for POST /register, e.g. <code>{"MaxUses": 20, "Expires": "2023-01-31T00:00:00Z"}</code>.
+	MaxUses 0 allows any number of registrations, the code never expires without Expires.</li>
+	<li>DELETE /admin/invites/:code - revoke an invite code</li>
+	<li>GET /admin/lockouts - logins and client addresses with failed password attempts and their lockouts</li>
+	<li>DELETE /admin/lockouts/:key - lift a lockout, e.g. /admin/lockouts/login:alice or /admin/lockouts/ip:192.0.2.10</li>
+	<li>GET /admin/logs - the latest access and transaction log entries, <code>?count=100</code> by default</li>
+	</ul>
+
 	<h2>Web sockets</h2>
 	<h3>GET /rates/stream</h3>
 	Offers continuous price updates sent over a websocket, avoiding polling.<br/>
@@ -142,13 +470,88 @@ func (s *server) handleIndex() gin.HandlerFunc {
 	No request content to be sent. Just connect to this endpoint and receive realtime price updates.<br/>
 	<b>Authentication is required</b>, preserving the server's precious resources for people we trust. 
 	<p>
+	<h3>GET /rates/candles/stream</h3>
+	Sends the updated candle of an interval over a websocket whenever its asset's price changes.
+	Select the interval with <code>?interval=1m</code> and optionally one asset with <code>&amp;asset=white_wool</code>.
+	Traded volume shows up with the next price update of its asset.
+	<p>
+	<pre>
+{
+	"Asset": "white_wool",
+	"Interval": "1m",
+	"Start": "2022-12-09T12:13:00+01:00",
+	"Open": 43.703,
+	"High": 43.9,
+	"Low": 43.62,
+	"Close": 43.81,
+	"Volume": 12.5
+}
+	</pre>
+	<b>Authentication is required.</b>
+	<p>
 	<h4>Note on price accuracy</h4> 
 	The stream's message timeliness is limited to best effort and might be delayed. There is no guarantee by the server that any transaction you initiate
 	will use the last price you received. It might just as well be subject to a price update still to be transmitted.
+	Use POST /quote to trade at a guaranteed price.
 	<h4>Note on rounding</h4>
-	The server uses <a href="https://pkg.go.dev/github.com/shopspring/decimal">decimal number representation</a> internally for all amounts of money.
-	These numbers are converted to float64 before they are being sent over the wire or stored in a database. This may
-	lead to rounding errors for odd fractions. 
+	The server uses <a href="https://pkg.go.dev/github.com/shopspring/decimal">decimal number representation</a> for all amounts of money
+	and stores them exactly. They are sent as JSON numbers, which many JSON parsers read as float64. This may lead to rounding errors
+	for odd fractions. Add the query parameter <code>?decimals=string</code> or the header <code>X-Decimals: string</code>
+	to any request, including the web sockets, to receive decimal numbers as exact JSON strings instead:
+	<pre>
+{
+	"Name": "white_wool",
+	"Price": "43.703"
+}
+	</pre>
+
+	<h2>Errors</h2>
+	Every failed request is answered with a status of 400 or above and a body like
+	<pre>
+{
+	"Code": "INSUFFICIENT_FUNDS",
+	"Message": "Not enough funds. You want to spend 43.703 but only have 12.5.",
+	"RequestID": "5f0c3e2a9d8b4c1e8f7a6b5c4d3e2f1a"
+}
+	</pre>
+	Programs should branch on <code>Code</code>, the codes do not change. <code>Message</code> is meant for humans and may change.
+	<code>RequestID</code> is the <code>X-Request-ID</code> of the response.
+	<ul>
+	<li><code>INVALID_REQUEST</code> - malformed JSON, a missing or invalid parameter</li>
+	<li><code>INVALID_AMOUNT</code> - the amount or price is not positive</li>
+	<li><code>UNKNOWN_ASSET</code> - there is no such asset</li>
+	<li><code>ASSET_HALTED</code> - trading of the asset has been halted</li>
+	<li><code>INSUFFICIENT_FUNDS</code> - the balance does not cover the buy or order</li>
+	<li><code>INSUFFICIENT_ASSETS</code> - you hold less of the asset than you want to sell</li>
+	<li><code>QUOTE_EXPIRED</code>, <code>QUOTE_USED</code>, <code>INVALID_QUOTE</code> - the quote can not be used, request a new one</li>
+	<li><code>ORDER_NOT_FOUND</code>, <code>ORDER_NOT_OPEN</code> - the order does not exist or has been filled or cancelled already</li>
+	<li><code>UNAUTHORIZED</code> - authentication is required</li>
+	<li><code>INVALID_CREDENTIALS</code> - wrong login or password</li>
+	<li><code>INVALID_TOKEN</code> - the access or refresh token is unknown or has expired, log in again</li>
+	<li><code>INVALID_API_KEY</code> - the API key is unknown or has expired</li>
+	<li><code>TOTP_REQUIRED</code>, <code>INVALID_TOTP_CODE</code> - a two-factor code is missing or wrong</li>
+	<li><code>ACCOUNT_DISABLED</code> - the account has been disabled by an admin</li>
+	<li><code>FORBIDDEN</code> - not allowed for this user, API key or client address</li>
+	<li><code>NOT_FOUND</code> - no such endpoint, user, API key, invite code or lockout</li>
+	<li><code>CONFLICT</code> - the login is taken or two-factor authentication is enabled already</li>
+	<li><code>LOCKED_OUT</code> - too many failed password attempts, retry after <code>Retry-After</code> seconds</li>
+	<li><code>RATE_LIMITED</code> - the rate limit is exceeded, retry after <code>Retry-After</code> seconds</li>
+	<li><code>INTERNAL_ERROR</code> - the server failed, please report the request ID</li>
+	</ul>
+
+	<h2>Monitoring</h2>
+	Every response carries an <code>X-Request-ID</code> header, the one sent with the request or a generated one.
+	Please include it when reporting a problem, it identifies the request in the server logs.
+	The following endpoints need no authentication and are neither rate limited nor recorded in the access log.
+	<ul>
+	<li><a href="healthz">GET healthz</a> - status 200 as long as the process is alive</li>
+	<li><a href="readyz">GET readyz</a> - status 200 if the database can be reached, the prices are varied and the web
+		sockets are served, 503 otherwise. <code>"Checks"</code> names the problem.</li>
+	<li><a href="version">GET version</a> - the git commit and time of the build, and the database schema version</li>
+	<li><a href="metrics">GET metrics</a> - Prometheus metrics: requests and their latency per route, trades and traded
+		notional per asset and side, the current prices, web socket clients and the updates dropped for slow ones,
+		rate limit rejections and database query latency</li>
+	</ul>
 </body>
 </html>`))
 	}
@@ -156,40 +559,96 @@ func (s *server) handleIndex() gin.HandlerFunc {
 
 func (s *server) handleRates() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		assets, err := s.db.GetAssets()
+		assets, err := s.dbFromContext(c).GetAssets()
 		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
+			abortWithInternalError(c)
 			return
 		}
 
-		c.IndentedJSON(http.StatusOK, assets)
+		respond(c, http.StatusOK, assets)
 	}
 }
 
-func (s *server) handleBuy() gin.HandlerFunc {
+func (s *server) handleRatesHistory() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		buf, err := io.ReadAll(c.Request.Body)
+		to := time.Now()
+		if toStr := c.Query("to"); toStr != "" {
+			t, err := parseTimeParam(toStr)
+			if err != nil {
+				abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "invalid parameter to: %v", err)
+				return
+			}
+			to = t
+		}
+
+		from := to.Add(-defaultHistoryRange)
+		if fromStr := c.Query("from"); fromStr != "" {
+			t, err := parseTimeParam(fromStr)
+			if err != nil {
+				abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "invalid parameter from: %v", err)
+				return
+			}
+			from = t
+		}
+
+		if from.After(to) {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "from must not be after to")
+			return
+		}
+
+		asset := c.Query("asset")
+		if asset != "" {
+			if _, err := s.dbFromContext(c).GetAssetPrice(asset); err != nil {
+				abortWithError(c, http.StatusBadRequest, codeUnknownAsset, "unknown asset '%v'", asset)
+				return
+			}
+		}
+
+		history, err := s.dbFromContext(c).GetPriceHistory(asset, from, to, maxHistoryEntries)
 		if err != nil {
-			log.Printf("could not read post body: %v", err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			logger(c).Errorf("price history query failed: %v", err)
+			abortWithInternalError(c)
 			return
 		}
 
-		var trans entity.Transaction
-		if err = json.Unmarshal(buf, &trans); err != nil {
-			log.Printf("read json transaction failed: %v", err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+		respond(c, http.StatusOK, history)
+	}
+}
+
+// parseTimeParam accepts RFC3339 timestamps as well as unix seconds
+func parseTimeParam(value string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func (s *server) handleCandles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		interval := c.DefaultQuery("interval", defaultCandleInterval)
+		if _, ok := serviceMarket.CandleIntervals[interval]; !ok {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "unsupported interval '%v'", interval)
 			return
 		}
 
-		if !trans.Amount.IsPositive() {
-			c.AbortWithStatusJSON(http.StatusBadRequest, newUserError("amount must be positive"))
+		limit := defaultCandleCount
+		if limitStr := c.Query("limit"); limitStr != "" {
+			l, err := strconv.Atoi(limitStr)
+			if err != nil || l <= 0 {
+				abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "limit must be a positive number")
+				return
+			}
+			limit = l
 		}
 
-		price, err := s.db.GetAssetPrice(trans.Asset)
-		if err != nil {
-			log.Printf("could not get current asset price for '%v': %v", trans.Asset, err)
-			c.AbortWithStatus(http.StatusBadRequest)
+		respond(c, http.StatusOK, s.candles.GetCandles(c.Query("asset"), interval, limit))
+	}
+}
+
+func (s *server) handleBuy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var trans entity.Transaction
+		if !readJSON(c, &trans) {
 			return
 		}
 
@@ -198,53 +657,193 @@ func (s *server) handleBuy() gin.HandlerFunc {
 			return
 		}
 
+		var quote *entity.Quote
+		if trans.Quote != "" {
+			if quote, ok = verifyQuote(c, login, trans, entity.OrderSideBuy); !ok {
+				return
+			}
+			trans.Asset, trans.Amount = quote.Asset, quote.Amount
+		}
+
+		if !trans.Amount.IsPositive() {
+			abortWithError(c, http.StatusBadRequest, codeInvalidAmount, "amount must be positive")
+			return
+		}
+
+		if !s.checkTradable(c, trans.Asset) {
+			return
+		}
+
+		var price decimal.Decimal
+		var err error
+		if quote != nil {
+			price = quote.Price
+		} else {
+			price, err = s.dbFromContext(c).GetAssetPrice(trans.Asset)
+			if err != nil {
+				logger(c).Errorf("could not get current asset price for '%v': %v", trans.Asset, err)
+				abortWithError(c, http.StatusBadRequest, codeUnknownAsset, "unknown asset '%v'", trans.Asset)
+				return
+			}
+		}
+
 		balance, ok := getBalanceFromContext(c)
 		if !ok {
 			return
 		}
 
 		if price.Mul(trans.Amount).GreaterThan(balance) {
-			c.AbortWithStatusJSON(http.StatusBadRequest,
-				newUserError("Not enough funds. You want to spend %v but only have %v.",
-					price.Mul(trans.Amount), balance))
+			abortWithError(c, http.StatusBadRequest, codeInsufficientFunds,
+				"Not enough funds. You want to spend %v but only have %v.",
+				price.Mul(trans.Amount), balance)
+			return
 		}
 
-		acc, err := s.db.GetAccount(login)
+		acc, err := s.dbFromContext(c).GetAccount(login)
 		if err != nil {
-			log.Printf("could not get account for login %v: %v", login, err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			logger(c).Errorf("could not get account for login %v: %v", login, err)
+			abortWithInternalError(c)
 			return
 		}
 
-		err = serviceTrade.BuyAsset(acc, trans.Asset, trans.Amount)
-		if err != nil {
-			log.Printf("buy transaction failed (%v): %v", trans, err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+		if quote != nil {
+			err = serviceTrade.BuyAssetAtQuote(s.dbFromContext(c), acc, quote)
+		} else {
+			err = serviceTrade.BuyAsset(s.dbFromContext(c), acc, trans.Asset, trans.Amount)
+		}
+		switch {
+		case errors.Is(err, serviceTrade.ErrInsufficientFunds):
+			abortWithError(c, http.StatusBadRequest, codeInsufficientFunds, "%v", err)
+			return
+		case errors.Is(err, serviceTrade.ErrQuoteUsed):
+			abortWithError(c, http.StatusBadRequest, codeQuoteUsed, "the quote has been used already")
+			return
+		case err != nil:
+			logger(c).Errorf("buy transaction failed (%v): %v", trans, err)
+			abortWithInternalError(c)
 			return
 		}
+		s.recordVolume(c, trans.Asset, trans.Amount)
 
-		c.IndentedJSON(http.StatusOK, acc)
+		respond(c, http.StatusOK, acc)
 	}
 }
 
 func (s *server) handleSell() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var trans entity.Transaction
+		if !readJSON(c, &trans) {
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		var quote *entity.Quote
+		if trans.Quote != "" {
+			if quote, ok = verifyQuote(c, login, trans, entity.OrderSideSell); !ok {
+				return
+			}
+			trans.Asset, trans.Amount = quote.Asset, quote.Amount
+		}
+
+		if !trans.Amount.IsPositive() {
+			abortWithError(c, http.StatusBadRequest, codeInvalidAmount, "amount must be positive")
+			return
+		}
+
+		if !s.checkTradable(c, trans.Asset) {
+			return
+		}
+
+		acc, err := s.dbFromContext(c).GetAccount(login)
+		if err != nil {
+			logger(c).Errorf("get account for login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		asset := acc.GetOrCreateUserAsset(trans.Asset)
+
+		if asset.Amount.LessThan(trans.Amount) {
+			abortWithError(c, http.StatusBadRequest, codeInsufficientAssets,
+				"you can not sell more of %v than you currently have (%v)",
+				trans.Asset, asset.Amount)
+			return
+		}
+
+		price := decimal.Zero
+		if quote != nil {
+			price = quote.Price
+		} else if price, err = s.dbFromContext(c).GetAssetPrice(trans.Asset); err != nil {
+			logger(c).Errorf("get price of asset %v failed: %v", trans.Asset, err)
+			abortWithInternalError(c)
+			return
+		}
+		if !s.checkSellCode(c, acc, price.Mul(trans.Amount)) {
+			return
+		}
+
+		if quote != nil {
+			err = serviceTrade.SellAssetAtQuote(s.dbFromContext(c), acc, quote)
+		} else {
+			err = serviceTrade.SellAsset(s.dbFromContext(c), acc, trans.Asset, trans.Amount)
+		}
+		switch {
+		case errors.Is(err, serviceTrade.ErrInsufficientAssets):
+			abortWithError(c, http.StatusBadRequest, codeInsufficientAssets, "%v", err)
+			return
+		case errors.Is(err, serviceTrade.ErrQuoteUsed):
+			abortWithError(c, http.StatusBadRequest, codeQuoteUsed, "the quote has been used already")
+			return
+		case err != nil:
+			logger(c).Errorf("sell asset %v for login '%v' failed: %v", trans.Asset, login, err)
+			abortWithInternalError(c)
+			return
+		}
+		s.recordVolume(c, trans.Asset, trans.Amount)
+
+		respond(c, http.StatusOK, acc)
+	}
+}
+
+// recordVolume adds the amount of a trade to the candles once the transaction of the request has been committed
+func (s *server) recordVolume(c *gin.Context, assetName string, amount decimal.Decimal) {
+	s.dbFromContext(c).AfterCommit(func() {
+		s.candles.AddTrade(assetName, amount, time.Now())
+	})
+}
+
+func (s *server) handleQuote() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		buf, err := io.ReadAll(c.Request.Body)
 		if err != nil {
-			log.Printf("could not read post body: %v", err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			logger(c).Warnf("could not read post body: %v", err)
+			abortWithInternalError(c)
 			return
 		}
 
-		var trans entity.Transaction
-		if err = json.Unmarshal(buf, &trans); err != nil {
-			log.Printf("read json transaction failed: %v", err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+		var req entity.Quote
+		if err = json.Unmarshal(buf, &req); err != nil {
+			logger(c).Warnf("read json quote request failed: %v", err)
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "invalid quote request: %v", err)
 			return
 		}
 
-		if !trans.Amount.IsPositive() {
-			c.AbortWithStatusJSON(http.StatusBadRequest, newUserError("amount must be positive"))
+		if req.Side != entity.OrderSideBuy && req.Side != entity.OrderSideSell {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest,
+				"side must be '%v' or '%v'", entity.OrderSideBuy, entity.OrderSideSell)
+			return
+		}
+
+		if !req.Amount.IsPositive() {
+			abortWithError(c, http.StatusBadRequest, codeInvalidAmount, "amount must be positive")
+			return
+		}
+
+		if !s.checkTradable(c, req.Asset) {
 			return
 		}
 
@@ -253,63 +852,227 @@ func (s *server) handleSell() gin.HandlerFunc {
 			return
 		}
 
-		acc, err := s.db.GetAccount(login)
+		quote, err := serviceTrade.NewQuote(s.dbFromContext(c), login, req.Side, req.Asset, req.Amount)
 		if err != nil {
-			log.Printf("get account for login '%v' failed: %v", login, err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			logger(c).Errorf("creating quote (%v) for login '%v' failed: %v", req, login, err)
+			abortWithInternalError(c)
 			return
 		}
 
-		asset := acc.GetOrCreateUserAsset(trans.Asset)
+		respond(c, http.StatusOK, quote)
+	}
+}
 
-		if asset.Amount.LessThan(trans.Amount) {
-			c.AbortWithStatusJSON(http.StatusBadRequest,
-				newUserError("you can not sell more of %v than you currently have (%v)",
-					trans.Asset, asset.Amount))
+// checkTradable aborts the request unless the asset exists and trading has not been halted
+func (s *server) checkTradable(c *gin.Context, asset string) bool {
+	err := serviceMarket.CheckTradable(s.dbFromContext(c), asset)
+	switch {
+	case errors.Is(err, serviceMarket.ErrUnknownAsset):
+		abortWithError(c, http.StatusBadRequest, codeUnknownAsset, "unknown asset '%v'", asset)
+		return false
+	case errors.Is(err, serviceMarket.ErrAssetHalted):
+		abortWithError(c, http.StatusBadRequest, codeAssetHalted, "trading of %v is halted", asset)
+		return false
+	case err != nil:
+		logger(c).Errorf("query asset '%v' failed: %v", asset, err)
+		abortWithInternalError(c)
+		return false
+	}
+	return true
+}
+
+// verifyQuote validates the transaction's quote and aborts the request if it can not be used
+func verifyQuote(c *gin.Context, login string, trans entity.Transaction, side string) (*entity.Quote, bool) {
+	quote, err := serviceTrade.VerifyQuote(login, trans.Quote, side, trans.Asset, trans.Amount)
+	switch {
+	case errors.Is(err, serviceTrade.ErrQuoteExpired):
+		abortWithError(c, http.StatusBadRequest, codeQuoteExpired, "the quote has expired, request a new one")
+		return nil, false
+	case errors.Is(err, serviceTrade.ErrQuoteMismatch):
+		abortWithError(c, http.StatusBadRequest, codeInvalidQuote, "the quote does not match this %v transaction", side)
+		return nil, false
+	case err != nil:
+		abortWithError(c, http.StatusBadRequest, codeInvalidQuote, "invalid quote")
+		return nil, false
+	}
+	return quote, true
+}
+
+func (s *server) handlePlaceOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		buf, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			logger(c).Warnf("could not read post body: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+
+		var order entity.Order
+		if err = json.Unmarshal(buf, &order); err != nil {
+			logger(c).Warnf("read json order failed: %v", err)
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "invalid order: %v", err)
+			return
+		}
+
+		if order.Type == "" {
+			order.Type = entity.OrderTypeLimit
+		}
+
+		if order.Type != entity.OrderTypeLimit && order.Type != entity.OrderTypeStop && order.Type != entity.OrderTypeTakeProfit {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "type must be '%v', '%v' or '%v'",
+				entity.OrderTypeLimit, entity.OrderTypeStop, entity.OrderTypeTakeProfit)
+			return
+		}
+
+		if order.Side != entity.OrderSideBuy && order.Side != entity.OrderSideSell {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest,
+				"side must be '%v' or '%v'", entity.OrderSideBuy, entity.OrderSideSell)
 			return
 		}
 
-		err = serviceTrade.SellAsset(acc, trans.Asset, trans.Amount)
+		if !order.Amount.IsPositive() || !order.Price.IsPositive() {
+			abortWithError(c, http.StatusBadRequest, codeInvalidAmount, "amount and price must be positive")
+			return
+		}
+
+		if !s.checkTradable(c, order.Asset) {
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		acc, err := s.dbFromContext(c).GetAccount(login)
 		if err != nil {
-			log.Printf("sell asset %v for login '%v' failed: %v", trans.Asset, login, err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			logger(c).Errorf("get account for login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
 			return
 		}
 
-		c.IndentedJSON(http.StatusOK, acc)
+		if order.Side == entity.OrderSideBuy && acc.Balance.LessThan(order.Reserved()) {
+			abortWithError(c, http.StatusBadRequest, codeInsufficientFunds,
+				"Not enough funds. The order reserves %v but you only have %v.", order.Reserved(), acc.Balance)
+			return
+		}
+
+		if order.Side == entity.OrderSideSell && acc.GetOrCreateUserAsset(order.Asset).Amount.LessThan(order.Reserved()) {
+			abortWithError(c, http.StatusBadRequest, codeInsufficientAssets,
+				"you can not sell more of %v than you currently have (%v)",
+				order.Asset, acc.GetOrCreateUserAsset(order.Asset).Amount)
+			return
+		}
+
+		if order.Side == entity.OrderSideSell && !s.checkSellCode(c, acc, order.Price.Mul(order.Amount)) {
+			return
+		}
+
+		err = s.orderBook.PlaceOrder(s.dbFromContext(c), acc, &order)
+		switch {
+		case errors.Is(err, serviceTrade.ErrInsufficientFunds):
+			abortWithError(c, http.StatusBadRequest, codeInsufficientFunds, "%v", err)
+			return
+		case errors.Is(err, serviceTrade.ErrInsufficientAssets):
+			abortWithError(c, http.StatusBadRequest, codeInsufficientAssets, "%v", err)
+			return
+		case err != nil:
+			logger(c).Errorf("place order (%v) for login '%v' failed: %v", order, login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusCreated, order)
+	}
+}
+
+func (s *server) handleOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		status := c.Query("status")
+		if status != "" && status != entity.OrderStatusOpen {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest,
+				"status filter must be '%v'", entity.OrderStatusOpen)
+			return
+		}
+
+		orders, err := s.dbFromContext(c).GetOrders(login, status == entity.OrderStatusOpen)
+		if err != nil {
+			logger(c).Errorf("get orders for login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusOK, orders)
+	}
+}
+
+func (s *server) handleCancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "invalid order id '%v'", c.Param("id"))
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		order, err := s.orderBook.CancelOrder(s.dbFromContext(c), login, id)
+		switch {
+		case errors.Is(err, serviceTrade.ErrOrderNotFound):
+			abortWithError(c, http.StatusNotFound, codeOrderNotFound, "no order with id %v", id)
+			return
+		case errors.Is(err, serviceTrade.ErrOrderNotOpen):
+			abortWithError(c, http.StatusBadRequest, codeOrderNotOpen, "order %v is %v already", id, order.Status)
+			return
+		case err != nil:
+			logger(c).Errorf("cancel order %v for login '%v' failed: %v", id, login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusOK, order)
 	}
 }
 
 func (s *server) handleAccount(showAll bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if showAll {
-			accList, err := s.db.GetAccounts()
+			accList, err := s.dbFromContext(c).GetAccounts()
 			if err != nil {
-				log.Printf("GetAccount failed: %v", err)
-				c.AbortWithStatus(http.StatusInternalServerError)
+				logger(c).Errorf("GetAccount failed: %v", err)
+				abortWithInternalError(c)
 				return
 			}
 
-			c.IndentedJSON(http.StatusOK, accList)
+			respond(c, http.StatusOK, accList)
 		} else {
 			login, ok := getLoginFromContext(c)
 			if !ok {
 				return
 			}
 
-			acc, err := s.db.GetAccount(login)
-			if err != nil {
-				log.Printf("GetAccount failed: %v", err)
-				c.AbortWithStatus(http.StatusInternalServerError)
+			acc, err := s.dbFromContext(c).GetAccount(login)
+			if errors.Is(err, storage.ErrNotFound) {
+				// the account has been deleted since the request was authenticated
+				logger(c).Warnf("handleAccount: no account found for login %v", login)
+				abortWithError(c, http.StatusNotFound, codeNotFound, "account '%v' not found", login)
 				return
 			}
-			if acc == nil {
-				log.Printf("handleAccount: no account found for login %v", login)
-				c.AbortWithStatus(http.StatusNoContent)
+			if err != nil {
+				logger(c).Errorf("GetAccount failed: %v", err)
+				abortWithInternalError(c)
 				return
 			}
 
-			c.IndentedJSON(http.StatusOK, acc)
+			respond(c, http.StatusOK, acc)
 		}
 	}
 }
@@ -318,55 +1081,95 @@ func (s *server) handlePriceStream() gin.HandlerFunc {
 	upgrader := websocket.Upgrader{
 		HandshakeTimeout: 2 * time.Second,
 		WriteBufferSize:  1024,
+		Error:            websocketError,
 	}
 
 	return func(c *gin.Context) {
-		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-		if err != nil {
-			log.Printf("websocket handshake failed: %v", err)
-			c.AbortWithStatus(http.StatusSwitchingProtocols)
+		s.serveWebsocket(c, upgrader, &streamClient{})
+	}
+}
+
+func (s *server) handleCandleStream() gin.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		HandshakeTimeout: 2 * time.Second,
+		WriteBufferSize:  1024,
+		Error:            websocketError,
+	}
+
+	return func(c *gin.Context) {
+		interval := c.DefaultQuery("interval", defaultCandleInterval)
+		if _, ok := serviceMarket.CandleIntervals[interval]; !ok {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "unsupported interval '%v'", interval)
 			return
 		}
-		defer ws.Close()
 
-		wsClient := &streamClient{
-			ws:     ws,
-			events: make(chan entity.MarketAsset, 1),
-		}
+		s.serveWebsocket(c, upgrader, &streamClient{
+			candleInterval: interval,
+			candleAsset:    c.Query("asset"),
+		})
+	}
+}
 
-		go func() {
-			// read from client to detect disconnects early. we don't expect any data from client.
-			_, _, err := wsClient.ws.NextReader()
-			if err != nil {
-				log.Printf("websocket %v read failure detected. closing connection.", ws.RemoteAddr())
-			} else {
-				log.Printf("websocket %v received data unexpectedly. closing connection.", ws.RemoteAddr())
-			}
-			s.removeWsClient <- wsClient
-		}()
+// serveWebsocket upgrades the connection and sends the client's events until it disconnects
+func (s *server) serveWebsocket(c *gin.Context, upgrader websocket.Upgrader, wsClient *streamClient) {
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has answered with an error response already, see websocketError
+		logger(c).Warnf("websocket handshake failed: %v", err)
+		c.Abort()
+		return
+	}
+	defer ws.Close()
 
-		// register websocket client to receive price changes
-		s.registerWsClient <- wsClient
+	wsClient.ws = ws
+	wsClient.events = make(chan interface{}, streamClientBuffer)
+	wsClient.decimalStrings = wantsDecimalStrings(c)
 
-		// send price changes
-		for ev := range wsClient.events {
-			err := wsClient.sendEvent(ev)
+	go func() {
+		// read from client to detect disconnects early. we don't expect any data from client.
+		_, _, err := wsClient.ws.NextReader()
+		if err != nil {
+			logger(c).Infof("websocket %v read failure detected. closing connection.", ws.RemoteAddr())
+		} else {
+			logger(c).Infof("websocket %v received data unexpectedly. closing connection.", ws.RemoteAddr())
+		}
+		s.removeWsClient <- wsClient
+	}()
 
-			if err != nil {
-				log.Printf("client %v send over websocket failed: %v", wsClient.ws.RemoteAddr(), err)
-				s.removeWsClient <- wsClient
-				// stay in the loop to consume remaining events. serveStreamClients will close the channel and trigger shutdown.
-			}
+	// register websocket client to receive its events
+	s.registerWsClient <- wsClient
+
+	// send events
+	for ev := range wsClient.events {
+		err := wsClient.sendEvent(ev)
+
+		if err != nil {
+			logger(c).Errorf("client %v send over websocket failed: %v", wsClient.ws.RemoteAddr(), err)
+			s.removeWsClient <- wsClient
+			// stay in the loop to consume remaining events. serveStreamClients will close the channel and trigger shutdown.
 		}
 	}
 }
 
-func (wsClient *streamClient) sendEvent(ev entity.MarketAsset) error {
+// queue hands an event to the client's handler without blocking serveStreamClients. The event is dropped if the client
+// does not keep up, it receives the next update of the price then.
+func (wsClient *streamClient) queue(ev interface{}) {
+	select {
+	case wsClient.events <- ev:
+	default:
+		metrics.DroppedStreamEvents.Inc()
+	}
+}
+
+func (wsClient *streamClient) sendEvent(ev interface{}) error {
 	wsClient.Lock()
 	defer wsClient.Unlock()
 
 	// enforce fast client readout
 	wsClient.ws.SetWriteDeadline(time.Now().Add(1 * time.Second))
+	if wsClient.decimalStrings {
+		ev = decimalsAsStrings(reflect.ValueOf(ev))
+	}
 	err := wsClient.ws.WriteJSON(ev)
 	// reset write timeout
 	wsClient.ws.SetWriteDeadline(time.Time{})
@@ -375,10 +1178,30 @@ func (wsClient *streamClient) sendEvent(ev entity.MarketAsset) error {
 }
 
 func (s *server) serveStreamClients() {
+	stop := s.stopStreams
 	for {
 		select {
+		case <-stop:
+			// keep serving the channels for the handlers still registering or removing their clients
+			stop = nil
+			for _, c := range s.streamClients {
+				closeStreamClient(c)
+			}
+			s.streamClients = nil
+			metrics.WebsocketClients.Set(0)
+
+		case reply := <-s.pingStreams:
+			if stop != nil {
+				close(reply)
+			}
+
 		case c := <-s.registerWsClient:
+			if stop == nil {
+				closeStreamClient(c)
+				break
+			}
 			s.streamClients = append(s.streamClients, c)
+			metrics.WebsocketClients.Set(float64(len(s.streamClients)))
 
 		case c := <-s.removeWsClient:
 			if c.shutdown {
@@ -398,15 +1221,31 @@ func (s *server) serveStreamClients() {
 			}
 
 			s.streamClients = append(s.streamClients[:i], s.streamClients[i+1:]...)
+			metrics.WebsocketClients.Set(float64(len(s.streamClients)))
 
 		case ev := <-s.priceUpdates:
+			if s.leadingMarket() {
+				s.orderBook.OnPrice(ev)
+			}
+			candles := s.candles.AddPrice(ev)
+
 			for _, client := range s.streamClients {
-				if client == nil {
+				if client == nil || client.shutdown {
 					continue
 				}
 
-				if !client.shutdown {
-					client.events <- ev
+				if client.candleInterval == "" {
+					client.queue(ev)
+					continue
+				}
+
+				if client.candleAsset != "" && client.candleAsset != ev.Name {
+					continue
+				}
+				for _, candle := range candles {
+					if candle.Interval == client.candleInterval {
+						client.queue(candle)
+					}
 				}
 			}
 		}
@@ -416,8 +1255,8 @@ func (s *server) serveStreamClients() {
 func getBalanceFromContext(c *gin.Context) (decimal.Decimal, bool) {
 	balanceI, ok := c.Get("balance")
 	if !ok {
-		log.Println("no balance found")
-		c.AbortWithStatus(http.StatusInternalServerError)
+		logger(c).Warnf("no balance found")
+		abortWithInternalError(c)
 		return decimal.Zero, false
 	}
 
@@ -425,11 +1264,27 @@ func getBalanceFromContext(c *gin.Context) (decimal.Decimal, bool) {
 	return balance, true
 }
 
+// readJSON decodes the request body into obj or aborts the request
+func readJSON(c *gin.Context, obj interface{}) bool {
+	buf, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		logger(c).Warnf("could not read post body: %v", err)
+		abortWithInternalError(c)
+		return false
+	}
+
+	if err = json.Unmarshal(buf, obj); err != nil {
+		abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "invalid request: %v", err)
+		return false
+	}
+	return true
+}
+
 func getLoginFromContext(c *gin.Context) (string, bool) {
 	loginI, ok := c.Get("login")
 	if !ok {
-		log.Println("no login found")
-		c.AbortWithStatus(http.StatusInternalServerError)
+		logger(c).Warnf("no login found")
+		abortWithInternalError(c)
 		return "", false
 	}
 	login := loginI.(string)
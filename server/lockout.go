@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+	"tradingServer/serviceUser"
+)
+
+// Events recorded in the access log
+const (
+	// eventLockout marks the failed password attempt which locked a login or client address
+	eventLockout = "lockout"
+	// eventLockedOut marks a request rejected because its login or client address is locked
+	eventLockedOut = "locked_out"
+	// eventUnlock marks an admin lifting a lockout
+	eventUnlock = "unlock"
+)
+
+// authAttempt tracks a password check of the request, see checkLockout and verifyPassword
+type authAttempt struct {
+	login    string
+	verified bool
+	failed   bool
+	// clearFailures tells whether failed attempts for the login are on record
+	clearFailures bool
+	// secondFactor is the two-factor code accepted, it stays used even if the request fails
+	secondFactor *serviceUser.SecondFactorUse
+}
+
+// checkLockout rejects the request if the login or the client address are locked after too many failed password
+// attempts. It must be called before the password of the login is verified.
+func (s *server) checkLockout(c *gin.Context, login string) bool {
+	now := time.Now()
+	lockedUntil, loginFailures, err := serviceUser.CheckLockout(s.dbFromContext(c), login, c.ClientIP(), now)
+	if err != nil {
+		logger(c).Errorf("lockout query failed: %v", err)
+		abortWithInternalError(c)
+		return false
+	}
+
+	c.Set("authAttempt", &authAttempt{login: login, clearFailures: loginFailures})
+
+	if lockedUntil.After(now) {
+		logger(c).Warnf("authorization rejected: login '%v' or address %v locked until %v", login, c.ClientIP(), lockedUntil)
+		c.Set("accessEvent", eventLockedOut)
+		retryAfter := ceilSeconds(lockedUntil.Sub(now))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		abortWithError(c, http.StatusTooManyRequests, codeLockedOut,
+			"too many failed login attempts, try again in %v seconds", retryAfter)
+		return false
+	}
+	return true
+}
+
+// trackAuthFailures counts the failed password attempts of the request, or clears those of the login once its password
+// has been verified. It has to run outside of the request's transaction, which is rolled back on failure. For the same
+// reason it marks the two-factor code of a failed request as used again.
+func (s *server) trackAuthFailures() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		a, ok := c.Get("authAttempt")
+		if !ok {
+			return
+		}
+		attempt := a.(*authAttempt)
+
+		if attempt.secondFactor != nil && (c.IsAborted() || c.Writer.Status() >= http.StatusBadRequest) {
+			if err := serviceUser.ConsumeSecondFactor(s.db, attempt.login, *attempt.secondFactor); err != nil {
+				logger(c).Errorf("marking the two-factor code of login '%v' as used failed: %v", attempt.login, err)
+			}
+		}
+
+		switch {
+		case attempt.failed:
+			lockedUntil, err := serviceUser.RecordAuthFailure(s.db, s.config.Lockout, attempt.login, c.ClientIP(), time.Now())
+			if err != nil {
+				logger(c).Errorf("recording failed login attempt failed: %v", err)
+			} else if !lockedUntil.IsZero() {
+				logger(c).Warnf("login '%v' or address %v locked until %v after failed login attempts", attempt.login, c.ClientIP(), lockedUntil)
+				c.Set("accessEvent", eventLockout)
+			}
+		case attempt.verified && attempt.clearFailures:
+			if err := serviceUser.ClearLoginFailures(s.db, attempt.login); err != nil {
+				logger(c).Errorf("clearing failed login attempts of '%v' failed: %v", attempt.login, err)
+			}
+		}
+	}
+}
+
+func (s *server) handleAdminLockouts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		failures, err := s.dbFromContext(c).GetAuthFailures()
+		if err != nil {
+			logger(c).Errorf("get auth failures failed: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusOK, failures)
+	}
+}
+
+func (s *server) handleAdminUnlock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Param("key")
+		err := serviceUser.Unlock(s.dbFromContext(c), key)
+		switch {
+		case errors.Is(err, serviceUser.ErrNotLocked):
+			abortWithError(c, http.StatusNotFound, codeNotFound, "%v for '%v'", err, key)
+			return
+		case err != nil:
+			logger(c).Errorf("unlocking %v failed: %v", key, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		logger(c).Infof("admin '%v' unlocked %v", c.GetString("login"), key)
+		c.Set("accessEvent", eventUnlock)
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/logging"
+	"tradingServer/servicePriceVariation"
+)
+
+// marketCheckInterval is the time between the checks which server instance runs the market. The other instances read
+// the prices from the database at the same interval.
+const marketCheckInterval = time.Second
+
+// runMarket varies the prices, executes the orders and compacts the price history while this server instance holds
+// the market lock, so instances sharing a database neither vary the prices nor execute an order twice. The other
+// instances pass the prices stored in the database on to their web socket clients. It returns once stopMarket is
+// closed, stopping the price makers for good.
+func (s *server) runMarket() {
+	defer close(s.marketDone)
+
+	ticker := time.NewTicker(marketCheckInterval)
+	defer ticker.Stop()
+
+	var stopCompaction chan struct{}
+	prices := make(map[string]decimal.Decimal)
+
+	for {
+		leading, err := s.db.HoldMarketLock()
+		if err != nil {
+			logging.Errorf("checking the market lock failed: %v", err)
+		}
+		s.setLeadingMarket(leading)
+
+		if leading && stopCompaction == nil {
+			stopCompaction = make(chan struct{})
+			go servicePriceVariation.RunHistoryCompaction(s.db, stopCompaction)
+		} else if !leading && stopCompaction != nil {
+			close(stopCompaction)
+			stopCompaction = nil
+		}
+
+		// the assets may have been changed by admins of other instances
+		assets, err := s.db.GetAssets()
+		switch {
+		case err != nil:
+			logging.Errorf("reading the assets failed: %v", err)
+		case leading:
+			s.priceMakers.Sync(assets)
+			if err = s.orderBook.Reload(); err != nil {
+				logging.Errorf("reloading the order book failed: %v", err)
+			}
+		default:
+			s.priceMakers.Sync(nil)
+			s.followPrices(assets, prices)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.stopMarket:
+			if stopCompaction != nil {
+				close(stopCompaction)
+			}
+			s.priceMakers.StopAll()
+			return
+		}
+	}
+}
+
+// followPrices passes the prices changed by the instance running the market on like the updates of own price makers
+func (s *server) followPrices(assets []entity.MarketAsset, prices map[string]decimal.Decimal) {
+	for _, a := range assets {
+		if price, ok := prices[a.Name]; ok && price.Equal(a.Price) {
+			continue
+		}
+		prices[a.Name] = a.Price
+		s.priceUpdates <- entity.MarketAsset{Name: a.Name, Price: a.Price, When: time.Now()}
+	}
+}
+
+// leadingMarket tells whether this instance runs the market, only then its price makers are started and the orders
+// triggered by price updates executed
+func (s *server) leadingMarket() bool {
+	s.marketMu.Lock()
+	defer s.marketMu.Unlock()
+	return s.leading
+}
+
+func (s *server) setLeadingMarket(leading bool) {
+	s.marketMu.Lock()
+	changed := s.leading != leading
+	s.leading = leading
+	s.marketMu.Unlock()
+
+	if changed && leading {
+		logging.Infof("running the market")
+	} else if changed {
+		logging.Warnf("no longer running the market")
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"tradingServer/logging"
+	"tradingServer/storage"
+)
+
+// assetPriceCollector exports the current price of every asset. The prices are read from the database on every
+// scrape, so removed assets disappear and the prices of halted assets are included.
+type assetPriceCollector struct {
+	db    storage.Storage
+	price *prometheus.Desc
+}
+
+func newAssetPriceCollector(db storage.Storage) *assetPriceCollector {
+	return &assetPriceCollector{
+		db:    db,
+		price: prometheus.NewDesc("tradingserver_asset_price", "Current price of an asset.", []string{"asset"}, nil),
+	}
+}
+
+func (c *assetPriceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.price
+}
+
+func (c *assetPriceCollector) Collect(ch chan<- prometheus.Metric) {
+	assets, err := c.db.GetAssets()
+	if err != nil {
+		logging.Errorf("query asset prices for metrics failed: %v", err)
+		return
+	}
+
+	for _, a := range assets {
+		ch <- prometheus.MustNewConstMetric(c.price, prometheus.GaugeValue, a.Price.InexactFloat64(), a.Name)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/shopspring/decimal"
+	"testing"
+	"tradingServer/metrics"
+	"tradingServer/storage"
+)
+
+func TestAssetPriceCollector(t *testing.T) {
+	db := storage.NewMemory()
+	if err := db.SetAssetPrice("toothpaste", decimal.RequireFromString("8.5")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetAssetHalted("toothpaste", true); err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan prometheus.Metric, 100)
+	newAssetPriceCollector(db).Collect(ch)
+	close(ch)
+
+	prices := make(map[string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		prices[pb.GetLabel()[0].GetValue()] = pb.GetGauge().GetValue()
+	}
+
+	assets, err := db.GetAssets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != len(assets) {
+		t.Errorf("collected prices %v, want one per asset (%v)", prices, len(assets))
+	}
+	// halted assets are included
+	if prices["toothpaste"] != 8.5 {
+		t.Errorf("collected price of toothpaste = %v, want 8.5", prices["toothpaste"])
+	}
+}
+
+func TestRecordTrade(t *testing.T) {
+	trades := metrics.Trades.WithLabelValues("gold", "buy")
+	notional := metrics.TradedNotional.WithLabelValues("gold", "buy")
+	before, beforeNotional := testutil.ToFloat64(trades), testutil.ToFloat64(notional)
+
+	metrics.RecordTrade("gold", "buy", decimal.RequireFromString("12.5"))
+	metrics.RecordTrade("gold", "buy", decimal.RequireFromString("7.5"))
+
+	if got := testutil.ToFloat64(trades) - before; got != 2 {
+		t.Errorf("trades counted = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(notional) - beforeNotional; got != 20 {
+		t.Errorf("notional counted = %v, want 20", got)
+	}
+	if got := testutil.ToFloat64(metrics.Trades.WithLabelValues("gold", "sell")); got != 0 {
+		t.Errorf("sells counted = %v, want 0", got)
+	}
+}
@@ -6,76 +6,346 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+	"tradingServer/entity"
+	"tradingServer/logging"
+	"tradingServer/metrics"
+	"tradingServer/serviceUser"
 	"tradingServer/storage"
 )
 
+// dbTransaction runs the request in a single database transaction. The response is held back until the
+// transaction has been committed, so a client never sees a success for changes that were rolled back.
 func (s *server) dbTransaction() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tx, err := s.db.Begin()
 		if err != nil {
-			log.Printf("begin transaction failed: %v", err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			logger(c).Errorf("begin transaction failed: %v", err)
+			abortWithInternalError(c)
 			return
 		}
 
+		writer := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		c.Set("db", tx)
+		tx.SetRequestID(c.GetString("requestID"))
+
+		finished := false
+		defer func() {
+			if !finished {
+				// the handler panicked
+				c.Writer = writer.ResponseWriter
+				tx.Rollback()
+			}
+		}()
+
 		c.Next()
 
-		if c.IsAborted() {
+		finished = true
+		c.Writer = writer.ResponseWriter
+
+		if c.IsAborted() || writer.status >= http.StatusBadRequest {
 			tx.Rollback()
+			writer.flush()
 			return
 		}
 
 		err = tx.Commit()
 		if err != nil {
-			log.Printf("commit transaction failed: %v", err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			logger(c).Errorf("commit transaction failed: %v", err)
+			abortWithInternalError(c)
 			return
 		}
+
+		writer.flush()
+	}
+}
+
+// dbFromContext returns the database transaction of the request or the plain database outside of a transaction
+func (s *server) dbFromContext(c *gin.Context) storage.Storage {
+	if db, ok := c.Get("db"); ok {
+		return db.(storage.Storage)
+	}
+	return s.db
+}
+
+// bufferedWriter keeps the response in memory until it is flushed
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.status = code
 	}
 }
 
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(data string) (int, error) {
+	w.written = true
+	return w.body.WriteString(data)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.written
+}
+
+func (w *bufferedWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+	} else {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// authRequired authenticates the request by an API key, by the bearer access token of a session, see /login, or by
+// HTTP Basic auth
 func (s *server) authRequired() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		auth := c.GetHeader("Authorization")
 
-		login, pw, err := decodeAuthHeader(auth)
-		if err != nil {
-			log.Printf("authorization failed: %v", err)
-			c.Header("WWW-Authenticate", "Basic realm=\"Hail to the king!\"")
-			c.AbortWithStatus(http.StatusUnauthorized)
+		var acc *entity.Account
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			acc = s.authenticateAPIKey(c, key)
+		} else if isBearerAuth(auth) {
+			acc = s.authenticateBearer(c, auth)
+		} else {
+			acc = s.authenticateBasic(c, auth)
+		}
+		if acc == nil {
+			// aborted already
+			return
+		}
+		if acc.Disabled {
+			logger(c).Warnf("authorization failed: login '%v' is disabled", acc.Login)
+			abortWithError(c, http.StatusForbidden, codeAccountDisabled, "account is disabled")
 			return
 		}
 
-		acc, err := s.db.GetAccount(login)
-		if err != nil {
-			log.Printf("login query failed: %v", err)
-			c.Header("WWW-Authenticate","Basic realm=\"Hail to the king!\"")
-			c.AbortWithStatus(http.StatusUnauthorized)
+		c.Set("login", acc.Login)
+		c.Set("balance", acc.Balance)
+		c.Set("tier", acc.Tier)
+		c.Set("admin", acc.Admin)
+
+		c.Next()
+	}
+}
+
+func (s *server) authenticateBasic(c *gin.Context, auth string) *entity.Account {
+	login, pw, err := decodeAuthHeader(auth)
+	if err != nil {
+		logger(c).Warnf("authorization failed: %v", err)
+		c.Header("WWW-Authenticate", "Basic realm=\"Hail to the king!\"")
+		abortWithError(c, http.StatusUnauthorized, codeUnauthorized, "authentication required")
+		return nil
+	}
+
+	if !s.checkLockout(c, login) {
+		return nil
+	}
+
+	acc, ok := s.verifyPassword(c, login, pw)
+	if !ok {
+		c.Header("WWW-Authenticate","Basic realm=\"Hail to the king!\"")
+		abortWithError(c, http.StatusUnauthorized, codeInvalidCredentials, "invalid login or password")
+		return nil
+	}
+	if acc.TOTPEnabled {
+		// the password alone is not enough, the failed attempts are kept
+		authAttemptFromContext(c, login).verified = false
+		logger(c).Warnf("authorization failed: login '%v' has two-factor authentication enabled", login)
+		abortWithError(c, http.StatusUnauthorized, codeTOTPRequired,
+			"two-factor authentication is enabled, log in with POST /login or use an API key")
+		return nil
+	}
+	return acc
+}
+
+func (s *server) authenticateBearer(c *gin.Context, auth string) *entity.Account {
+	token := strings.TrimSpace(auth[len(serviceUser.TokenType):])
+
+	session, err := serviceUser.GetSession(s.dbFromContext(c), token)
+	if err != nil {
+		logger(c).Errorf("session query failed: %v", err)
+		abortWithInternalError(c)
+		return nil
+	}
+	if session == nil {
+		logger(c).Warnf("authorization failed: access token unknown or expired")
+		c.Header("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+		abortWithError(c, http.StatusUnauthorized, codeInvalidToken, "access token unknown or expired")
+		return nil
+	}
+
+	acc, err := s.dbFromContext(c).GetAccount(session.Login)
+	if errors.Is(err, storage.ErrNotFound) {
+		logger(c).Warnf("authorization failed: login '%v' of the session unknown", session.Login)
+		c.Header("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+		abortWithError(c, http.StatusUnauthorized, codeInvalidToken, "access token unknown or expired")
+		return nil
+	}
+	if err != nil {
+		logger(c).Errorf("login query for session of '%v' failed: %v", session.Login, err)
+		abortWithInternalError(c)
+		return nil
+	}
+
+	c.Set("session", session.AccessTokenHash)
+	return acc
+}
+
+func (s *server) authenticateAPIKey(c *gin.Context, key string) *entity.Account {
+	k, err := serviceUser.GetAPIKey(s.dbFromContext(c), key, c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, serviceUser.ErrAPIKeyAddressNotAllowed):
+			logger(c).Warnf("authorization by api key failed: %v", err)
+			abortWithError(c, http.StatusForbidden, codeForbidden, "%v", err)
+		case errors.Is(err, serviceUser.ErrAPIKeyInvalid), errors.Is(err, serviceUser.ErrAPIKeyExpired):
+			logger(c).Warnf("authorization by api key failed: %v", err)
+			abortWithError(c, http.StatusUnauthorized, codeInvalidAPIKey, "%v", err)
+		default:
+			logger(c).Errorf("authorization by api key failed: %v", err)
+			abortWithInternalError(c)
+		}
+		return nil
+	}
+
+	acc, err := s.dbFromContext(c).GetAccount(k.Login)
+	if errors.Is(err, storage.ErrNotFound) {
+		logger(c).Warnf("authorization by api key failed: login '%v' of api key %v unknown", k.Login, k.ID)
+		abortWithError(c, http.StatusUnauthorized, codeInvalidAPIKey, "api key of an unknown login")
+		return nil
+	}
+	if err != nil {
+		logger(c).Errorf("login query for api key %v of '%v' failed: %v", k.ID, k.Login, err)
+		abortWithInternalError(c)
+		return nil
+	}
+
+	c.Set("apiKey", k)
+	return acc
+}
+
+// requireScope rejects requests authenticated by an API key lacking the scope. Requests authenticated by password
+// or session may do anything.
+func (s *server) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if k, ok := c.Get("apiKey"); ok && !k.(*entity.APIKey).HasScope(scope) {
+			abortWithError(c, http.StatusForbidden, codeForbidden, "the api key lacks the scope '%v'", scope)
 			return
 		}
-		if acc == nil {
-			log.Printf("authorization failed: login '%v' unknown", login)
-			c.Header("WWW-Authenticate","Basic realm=\"Hail to the king!\"")
-			c.AbortWithStatus(http.StatusUnauthorized)
+		c.Next()
+	}
+}
+
+// adminRequired rejects requests of users without the admin role
+func (s *server) adminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("admin") {
+			logger(c).Warnf("admin request of login '%v' rejected", c.GetString("login"))
+			abortWithError(c, http.StatusForbidden, codeForbidden, "admin role required")
 			return
 		}
+		c.Next()
+	}
+}
 
-		if !acc.VerifyPassword(pw) {
-			log.Printf("authorization failed: password mismatch for login '%v': got '%v'", login, pw)
-			c.Header("WWW-Authenticate","Basic realm=\"Hail to the king!\"")
-			c.AbortWithStatus(http.StatusUnauthorized)
+// noAPIKey rejects requests authenticated by an API key, e.g. to keep keys from creating other keys
+func (s *server) noAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("apiKey"); ok {
+			abortWithError(c, http.StatusForbidden, codeForbidden,
+				"not allowed with an api key, log in with your password")
 			return
 		}
+		c.Next()
+	}
+}
 
-		c.Set("login", acc.Login)
-		c.Set("balance", acc.Balance)
+// verifyPassword checks the password of a login and returns the account if it matches. Outdated password hashes are
+// replaced on the way. Verified passwords are cached, so the slow password hash is only computed now and then.
+func (s *server) verifyPassword(c *gin.Context, login string, pw string) (*entity.Account, bool) {
+	attempt := authAttemptFromContext(c, login)
 
-		c.Next()
+	acc, err := s.dbFromContext(c).GetAccount(login)
+	if errors.Is(err, storage.ErrNotFound) {
+		logger(c).Warnf("authorization failed: login '%v' unknown", login)
+		attempt.failed = true
+		return nil, false
+	}
+	if err != nil {
+		logger(c).Errorf("login query failed: %v", err)
+		attempt.failed = true
+		return nil, false
 	}
+
+	if s.authCache.Verified(login, pw, acc.GetPassword()) {
+		attempt.verified = true
+		return acc, true
+	}
+
+	if !acc.VerifyPassword(pw) {
+		logger(c).Warnf("authorization failed: password mismatch for login '%v'", login)
+		attempt.failed = true
+		return nil, false
+	}
+
+	if acc.NeedsRehash() {
+		// replace the outdated hash now that the password is known, within the request's transaction if it has one
+		if err = s.dbFromContext(c).UpdateAccount(login, pw, ""); err != nil {
+			logger(c).Errorf("updating password hash of login '%v' failed: %v", login, err)
+		} else if acc, err = s.dbFromContext(c).GetAccount(login); err != nil {
+			logger(c).Errorf("login query failed: %v", err)
+			return nil, false
+		}
+	}
+
+	s.authCache.Add(login, pw, acc.GetPassword())
+	attempt.verified = true
+	return acc, true
+}
+
+// authAttemptFromContext returns the password check of the request started by checkLockout
+func authAttemptFromContext(c *gin.Context, login string) *authAttempt {
+	if a, ok := c.Get("authAttempt"); ok {
+		return a.(*authAttempt)
+	}
+
+	attempt := &authAttempt{login: login}
+	c.Set("authAttempt", attempt)
+	return attempt
+}
+
+func isBearerAuth(authHeader string) bool {
+	return len(authHeader) > len(serviceUser.TokenType) &&
+		strings.EqualFold(authHeader[:len(serviceUser.TokenType)+1], serviceUser.TokenType+" ")
 }
 
 func decodeAuthHeader(authHeader string) (string, string, error) {
@@ -124,6 +394,24 @@ func (s *server) accessLog() gin.HandlerFunc {
 			login = ""
 		}
 
+		var apiKeyID int64
+		if k, ok := c.Get("apiKey"); ok {
+			apiKeyID = k.(*entity.APIKey).ID
+		}
+
+		// lockout events name the login which was tried
+		event := c.GetString("accessEvent")
+		if a, ok := c.Get("authAttempt"); ok && event != "" && login == "" {
+			login = a.(*authAttempt).login
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, c.Request.Method).Observe(duration.Seconds())
+
 		err := s.db.LogAccess(storage.AccessLogEntry{
 			Duration:      float64(duration.Microseconds())/1000000,
 			Login:         login.(string),
@@ -131,28 +419,53 @@ func (s *server) accessLog() gin.HandlerFunc {
 			RemoteAddress: c.Request.RemoteAddr,
 			StatusCode:    c.Writer.Status(),
 			Time:          start,
+			APIKeyID:      apiKeyID,
+			Event:         event,
+			RequestID:     c.GetString("requestID"),
 		})
 
 		if err != nil {
-			log.Printf("%v", err)
+			logger(c).Errorf("%v", err)
 		}
 	}
 }
 
-func (s *server) rateLimit(group string, reqPerSec float64) gin.HandlerFunc {
+// rateLimit limits the requests of a group of endpoints as configured for the group and the user's tier. Every response
+// tells the client its limit, the requests remaining and the seconds until the limit is reset.
+func (s *server) rateLimit(group string) gin.HandlerFunc {
+	if _, ok := s.config.RateLimits.Groups[group]; !ok {
+		logging.Fatalf("no rate limit configured for group '%v'", group)
+	}
+
 	return func(c *gin.Context) {
-		id := group
-		// apply rate limit per user if request is authenticated
-		loginRaw, ok := c.Get("login")
-		if ok {
-			login := loginRaw.(string)
-			id = group + "-" + login
+		// apply rate limit per user if request is authenticated, per client address otherwise
+		id := group + "-" + c.ClientIP()
+		tier := ""
+		if login, ok := c.Get("login"); ok {
+			id = group + "-user-" + login.(string)
+			tier = c.GetString("tier")
 		}
 
-		if s.rateLimitState.CheckAndUpdate(id, reqPerSec) {
-			c.Next()
-		} else {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, newUserError("rate limit of %v requests per second exceeded", reqPerSec))
+		limit, _ := s.config.RateLimits.RateLimit(group, tier)
+		res := s.rateLimitState.CheckAndUpdate(id, limit)
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.reset)))
+
+		if !res.allowed {
+			metrics.RateLimitRejections.WithLabelValues(group).Inc()
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.retryAfter)))
+			abortWithError(c, http.StatusTooManyRequests, codeRateLimited,
+				"rate limit of %v requests per second exceeded", limit.Rate)
+			return
 		}
+
+		c.Next()
 	}
 }
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/serviceMarket"
+	"tradingServer/serviceTrade"
+	"tradingServer/storage"
+)
+
+// forEachStorage runs a test against a new sqlite database file and a new memory storage
+func forEachStorage(t *testing.T, test func(t *testing.T, db storage.Storage)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := storage.NewDatabase(storage.BackendSQLite, filepath.Join(t.TempDir(), "database.sqlite3"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		test(t, db)
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, storage.NewMemory())
+	})
+}
+
+func TestDBTransaction(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// every handler reserves money for an order of the user "test" and then ends the request
+	tests := []struct {
+		name       string
+		end        func(c *gin.Context)
+		wantStatus int
+		committed  bool
+	}{
+		{"ok", func(c *gin.Context) { respond(c, http.StatusCreated, "ok") }, http.StatusCreated, true},
+		{"no content", func(c *gin.Context) { c.Status(http.StatusNoContent) }, http.StatusNoContent, true},
+		{"client error", func(c *gin.Context) {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "rejected")
+		}, http.StatusBadRequest, false},
+		{"internal error", func(c *gin.Context) { abortWithInternalError(c) }, http.StatusInternalServerError, false},
+		{"error without abort", func(c *gin.Context) {
+			respond(c, http.StatusConflict, "conflict")
+		}, http.StatusConflict, false},
+		{"panic", func(c *gin.Context) { panic("handler failed") }, http.StatusInternalServerError, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachStorage(t, func(t *testing.T, db storage.Storage) {
+				ob, err := serviceTrade.NewOrderBook(db, serviceMarket.NewCandleAggregator())
+				if err != nil {
+					t.Fatal(err)
+				}
+				s := &server{db: db}
+
+				hooks := 0
+				r := gin.New()
+				r.Use(s.requestID(), s.recovery())
+				r.POST("/", s.dbTransaction(), func(c *gin.Context) {
+					tx := s.dbFromContext(c)
+					acc, err := tx.GetAccount("test")
+					if err != nil {
+						t.Fatal(err)
+					}
+					o := entity.Order{Side: entity.OrderSideBuy, Asset: "toothpaste", Amount: decimal.NewFromInt(2), Price: decimal.NewFromInt(10)}
+					if err = ob.PlaceOrder(tx, acc, &o); err != nil {
+						t.Fatal(err)
+					}
+					tx.AfterCommit(func() { hooks++ })
+					tt.end(c)
+				})
+
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+
+				if w.Code != tt.wantStatus {
+					t.Errorf("status = %v, want %v", w.Code, tt.wantStatus)
+				}
+
+				acc, err := db.GetAccount("test")
+				if err != nil {
+					t.Fatal(err)
+				}
+				orders, err := db.GetOpenOrders()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				wantBalance, wantOrders, wantHooks := decimal.NewFromInt(100), 0, 0
+				if tt.committed {
+					wantBalance, wantOrders, wantHooks = decimal.NewFromInt(80), 1, 1
+				}
+				if !acc.Balance.Equal(wantBalance) {
+					t.Errorf("balance = %v, want %v", acc.Balance, wantBalance)
+				}
+				if len(orders) != wantOrders {
+					t.Errorf("open orders = %v, want %v", len(orders), wantOrders)
+				}
+				if hooks != wantHooks {
+					t.Errorf("after commit hooks run = %v, want %v", hooks, wantHooks)
+				}
+			})
+		})
+	}
+}
+
+func TestDBTransactionRollsBackFailedTrade(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	forEachStorage(t, func(t *testing.T, db storage.Storage) {
+		s := &server{db: db}
+
+		r := gin.New()
+		r.Use(s.requestID(), s.recovery())
+		r.POST("/", s.dbTransaction(), func(c *gin.Context) {
+			tx := s.dbFromContext(c)
+			acc, err := tx.GetAccount("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the first buy succeeds, the second one fails and takes the first one with it
+			if err = serviceTrade.BuyAsset(tx, acc, "toothpaste", decimal.NewFromInt(2)); err != nil {
+				t.Fatal(err)
+			}
+			if err = serviceTrade.BuyAsset(tx, acc, "olive_oil", decimal.NewFromInt(1)); err == nil {
+				t.Fatal("buying olive oil for more than the balance succeeded")
+			}
+			abortWithError(c, http.StatusBadRequest, codeInsufficientFunds, "%v", err)
+		})
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %v, want %v", w.Code, http.StatusBadRequest)
+		}
+
+		acc, err := db.GetAccount("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !acc.Balance.Equal(decimal.NewFromInt(100)) || len(acc.Assets) != 0 {
+			t.Errorf("account = %v %v, want the starting balance and no assets", acc.Balance, acc.Assets)
+		}
+	})
+}
+
+// slowStorage takes its time saving accounts, so concurrent requests read the account before the other ones save it
+// unless their transactions keep them from doing so
+type slowStorage struct {
+	storage.Storage
+}
+
+func (s slowStorage) Begin() (storage.Storage, error) {
+	tx, err := s.Storage.Begin()
+	return slowStorage{tx}, err
+}
+
+func (s slowStorage) SaveAccount(acc entity.Account) error {
+	time.Sleep(20 * time.Millisecond)
+	return s.Storage.SaveAccount(acc)
+}
+
+func TestConcurrentBuys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	forEachStorage(t, func(t *testing.T, db storage.Storage) {
+		s := &server{db: slowStorage{db}, candles: serviceMarket.NewCandleAggregator()}
+
+		// every request buys toothpaste for 59.5 of the 100 the user starts with, the balance seen when
+		// authenticating covers each of them
+		r := gin.New()
+		r.Use(s.requestID(), s.recovery())
+		r.POST("/buy", s.dbTransaction(), func(c *gin.Context) {
+			c.Set("login", "test")
+			c.Set("balance", decimal.NewFromInt(100))
+		}, s.handleBuy())
+
+		const buyers = 5
+		codes := make([]string, buyers)
+		var wg sync.WaitGroup
+		for i := 0; i < buyers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/buy", strings.NewReader(`{"asset": "toothpaste", "amount": 7}`)))
+				if w.Code == http.StatusOK {
+					codes[i] = "OK"
+					return
+				}
+				var res userError
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+					t.Errorf("response %v is no error envelope: %v", w.Body, err)
+				}
+				codes[i] = res.Code
+			}(i)
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for _, code := range codes {
+			switch code {
+			case "OK":
+				succeeded++
+			case codeInsufficientFunds:
+			default:
+				t.Errorf("buy failed with %v, want %v", code, codeInsufficientFunds)
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("%v buys succeeded, want 1", succeeded)
+		}
+
+		acc, err := db.GetAccount("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !acc.Balance.Equal(decimal.RequireFromString("40.5")) || len(acc.Assets) != 1 || !acc.Assets[0].Amount.Equal(decimal.NewFromInt(7)) {
+			t.Errorf("account = %v %v, want 40.5 and 7 toothpaste", acc.Balance, acc.Assets)
+		}
+	})
+}
@@ -1,31 +1,89 @@
 package server
 
 import (
+	"math"
 	"sync"
 	"time"
+	"tradingServer/config"
 )
 
+// rateLimitSweepInterval is the minimum time between two sweeps removing the buckets of idle clients
+const rateLimitSweepInterval = time.Minute
+
+// requestRateLimit keeps a token bucket per client and route group. A bucket holds up to burst tokens and is refilled
+// at the configured rate, every request takes one token.
 type requestRateLimit struct {
 	sync.Mutex
-	lastRequest map[string]time.Time
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limit   config.RateLimit
+}
+
+// rateLimitResult tells whether a request is allowed and the state of its bucket afterwards
+type rateLimitResult struct {
+	allowed bool
+	// remaining is the number of requests allowed right away
+	remaining int
+	// reset is the time until the bucket is full again
+	reset time.Duration
+	// retryAfter is the time until the next request is allowed if this one was not
+	retryAfter time.Duration
 }
 
-func (rlimit *requestRateLimit)CheckAndUpdate(id string, reqPerSecond float64) bool {
+func (rlimit *requestRateLimit) CheckAndUpdate(id string, limit config.RateLimit) rateLimitResult {
 	rlimit.Lock()
 	defer rlimit.Unlock()
 
-	if rlimit.lastRequest == nil {
-		rlimit.lastRequest = make(map[string]time.Time)
+	now := time.Now()
+
+	if rlimit.buckets == nil {
+		rlimit.buckets = make(map[string]*tokenBucket)
+		rlimit.lastSweep = now
+	}
+	if now.Sub(rlimit.lastSweep) >= rateLimitSweepInterval {
+		rlimit.sweep(now)
 	}
 
-	minInterval := time.Duration(float64(time.Second) * 1/reqPerSecond)
+	b, ok := rlimit.buckets[id]
+	if !ok || b.limit != limit {
+		b = &tokenBucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		rlimit.buckets[id] = b
+	}
+	b.refill(now)
 
-	if t, ok := rlimit.lastRequest[id]; ok {
-		if time.Now().Sub(t) < minInterval {
-			return false
+	res := rateLimitResult{allowed: b.tokens >= 1}
+	if res.allowed {
+		b.tokens--
+	} else {
+		res.retryAfter = secondsToDuration((1 - b.tokens) / limit.Rate)
+	}
+
+	res.remaining = int(math.Floor(b.tokens))
+	res.reset = secondsToDuration((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return res
+}
+
+// sweep removes the buckets which have been refilled completely, they are no different from new ones
+func (rlimit *requestRateLimit) sweep(now time.Time) {
+	for id, b := range rlimit.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(rlimit.buckets, id)
 		}
 	}
+	rlimit.lastSweep = now
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate)
+	b.updated = now
+}
 
-	rlimit.lastRequest[id] = time.Now()
-	return true
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
 }
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"testing"
+	"time"
+	"tradingServer/config"
+)
+
+func TestRateLimitCheckAndUpdate(t *testing.T) {
+	limit := config.RateLimit{Rate: 2, Burst: 3}
+
+	// every step waits for the given time before the request, the bucket starts full
+	tests := []struct {
+		name          string
+		wait          time.Duration
+		wantAllowed   bool
+		wantRemaining int
+		wantRetry     time.Duration
+	}{
+		{"first request", 0, true, 2, 0},
+		{"second request", 0, true, 1, 0},
+		{"last token", 0, true, 0, 0},
+		{"empty bucket", 0, false, 0, 500 * time.Millisecond},
+		{"half a token refilled", 250 * time.Millisecond, false, 0, 250 * time.Millisecond},
+		{"one token refilled", 250 * time.Millisecond, true, 0, 0},
+		{"refilled beyond burst", 10 * time.Second, true, 2, 0},
+	}
+
+	var rlimit requestRateLimit
+	for _, tt := range tests {
+		if b, ok := rlimit.buckets["client"]; ok {
+			b.updated = b.updated.Add(-tt.wait)
+		}
+
+		res := rlimit.CheckAndUpdate("client", limit)
+		if res.allowed != tt.wantAllowed || res.remaining != tt.wantRemaining {
+			t.Errorf("%v: allowed, remaining = %v, %v, want %v, %v", tt.name, res.allowed, res.remaining, tt.wantAllowed, tt.wantRemaining)
+		}
+		if !approximately(res.retryAfter, tt.wantRetry) {
+			t.Errorf("%v: retryAfter = %v, want %v", tt.name, res.retryAfter, tt.wantRetry)
+		}
+	}
+}
+
+func TestRateLimitReset(t *testing.T) {
+	var rlimit requestRateLimit
+	limit := config.RateLimit{Rate: 4, Burst: 10}
+
+	for i := 0; i < 4; i++ {
+		rlimit.CheckAndUpdate("client", limit)
+	}
+	if res := rlimit.CheckAndUpdate("client", limit); !approximately(res.reset, 1250*time.Millisecond) {
+		t.Errorf("reset after 5 of 10 tokens at 4/s = %v, want 1.25s", res.reset)
+	}
+}
+
+func TestRateLimitSeparatesClientsAndLimits(t *testing.T) {
+	var rlimit requestRateLimit
+	limit := config.RateLimit{Rate: 1, Burst: 1}
+
+	if !rlimit.CheckAndUpdate("alice", limit).allowed {
+		t.Fatal("first request of alice was not allowed")
+	}
+	if rlimit.CheckAndUpdate("alice", limit).allowed {
+		t.Error("second request of alice was allowed")
+	}
+	if !rlimit.CheckAndUpdate("bob", limit).allowed {
+		t.Error("bob was limited by the requests of alice")
+	}
+	// a changed limit, e.g. after the tier of alice has been changed, starts a new bucket
+	if !rlimit.CheckAndUpdate("alice", config.RateLimit{Rate: 1, Burst: 5}).allowed {
+		t.Error("request of alice with a new limit was not allowed")
+	}
+}
+
+func TestRateLimitSweep(t *testing.T) {
+	var rlimit requestRateLimit
+	limit := config.RateLimit{Rate: 1, Burst: 2}
+
+	rlimit.CheckAndUpdate("idle", limit)
+	rlimit.CheckAndUpdate("busy", limit)
+	rlimit.CheckAndUpdate("busy", limit)
+
+	// the idle bucket has been refilled completely by the time of the sweep, the busy one has not
+	rlimit.buckets["idle"].updated = rlimit.buckets["idle"].updated.Add(-time.Second)
+	rlimit.lastSweep = rlimit.lastSweep.Add(-rateLimitSweepInterval)
+	rlimit.CheckAndUpdate("other", limit)
+
+	if _, ok := rlimit.buckets["idle"]; ok {
+		t.Error("the full bucket has not been removed")
+	}
+	if _, ok := rlimit.buckets["busy"]; !ok {
+		t.Error("the bucket which is not full has been removed")
+	}
+}
+
+// approximately compares durations computed from time.Now, which moves on a little during the test
+func approximately(got, want time.Duration) bool {
+	d := got - want
+	return d > -10*time.Millisecond && d < 10*time.Millisecond
+}
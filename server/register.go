@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"tradingServer/serviceUser"
+)
+
+type registerRequest struct {
+	Login      string
+	Password   string
+	Email      string
+	InviteCode string
+}
+
+func (s *server) handleRegister() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mode := s.config.Accounts.Registration
+		if mode == serviceUser.RegistrationClosed {
+			abortWithError(c, http.StatusForbidden, codeForbidden,
+				"registration is closed, ask an admin for an account")
+			return
+		}
+
+		var req registerRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		if err := serviceUser.ValidateLogin(req.Login); err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+			return
+		}
+		if err := serviceUser.CheckPasswordStrength(req.Login, req.Password, s.config.Accounts.MinPasswordLength); err != nil {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+			return
+		}
+		if req.Email != "" {
+			if err := serviceUser.ValidateEmail(req.Email); err != nil {
+				abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", err)
+				return
+			}
+		}
+
+		db := s.dbFromContext(c)
+		err := serviceUser.Register(db, mode, req.Login, req.Password, req.InviteCode, s.config.Accounts.StartingBalance.Decimal)
+		switch {
+		case errors.Is(err, serviceUser.ErrLoginTaken):
+			abortWithError(c, http.StatusConflict, codeConflict, "login '%v' is taken already", req.Login)
+			return
+		case errors.Is(err, serviceUser.ErrInviteCodeRequired), errors.Is(err, serviceUser.ErrInviteCodeInvalid):
+			abortWithError(c, http.StatusForbidden, codeForbidden, "%v", err)
+			return
+		case err != nil:
+			logger(c).Errorf("registering login '%v' failed: %v", req.Login, err)
+			abortWithInternalError(c)
+			return
+		}
+		c.Set("login", req.Login)
+
+		// the address is only stored once the user has confirmed it. The link is mailed after the account has been
+		// committed, so a registration failing from here on sends none.
+		if req.Email != "" {
+			_, err = serviceUser.RequestEmailChange(db, s.mailer, req.Login, req.Email,
+				s.config.Server.PublicURL, s.config.Accounts.EmailConfirmationTTL)
+			if err != nil {
+				logger(c).Errorf("requesting email confirmation of login '%v' failed: %v", req.Login, err)
+				abortWithInternalError(c)
+				return
+			}
+		}
+
+		acc, err := db.GetAccount(req.Login)
+		if err != nil {
+			logger(c).Errorf("GetAccount failed: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+
+		if req.InviteCode != "" {
+			logger(c).Infof("user '%v' registered with invite code %v", req.Login, req.InviteCode)
+		} else {
+			logger(c).Infof("user '%v' registered", req.Login)
+		}
+		respond(c, http.StatusCreated, acc)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"regexp"
+	"tradingServer/logging"
+)
+
+// requestIDHeader identifies a request in the server log, the access log and the transaction log
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID accepts the IDs of clients and proxies which cannot break the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID adopts the X-Request-ID of the client or a proxy, or generates one, and echoes it in the response
+func (s *server) requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		c.Header(requestIDHeader, id)
+		c.Set("requestID", id)
+		c.Set("logger", logging.With("request_id", id))
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		logging.Fatalf("reading random bytes failed: %v", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// logger returns the logger of the request, which adds the request ID to the messages
+func logger(c *gin.Context) *logging.Logger {
+	if l, ok := c.Get("logger"); ok {
+		return l.(*logging.Logger)
+	}
+	return logging.With("request_id", c.GetString("requestID"))
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{}
+	router := gin.New()
+	router.GET("/", s.requestID(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("requestID"))
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		adopt  bool
+	}{
+		{"no header", "", false},
+		{"id of a proxy", "5f1c0e2a-7b3d.req_1", true},
+		{"id breaking the logs", "abc\",\"level\":\"error", false},
+		{"too long", strings.Repeat("a", 65), false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			req.Header.Set(requestIDHeader, tt.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		id := w.Header().Get(requestIDHeader)
+		if id != w.Body.String() {
+			t.Errorf("%v: response header %q differs from the request's ID %q", tt.name, id, w.Body.String())
+		}
+		if tt.adopt && id != tt.header {
+			t.Errorf("%v: request ID = %q, want %q", tt.name, id, tt.header)
+		}
+		if !tt.adopt && (id == tt.header || !validRequestID.MatchString(id)) {
+			t.Errorf("%v: request ID = %q, want a generated one", tt.name, id)
+		}
+	}
+}
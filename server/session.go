@@ -0,0 +1,90 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"tradingServer/entity"
+	"tradingServer/serviceUser"
+)
+
+// loginRequest either holds the credentials of a user or the refresh token of a session. Code is the two-factor code
+// or a recovery code, it is required if the user has enabled two-factor authentication.
+type loginRequest struct {
+	Login        string
+	Password     string
+	Code         string
+	RefreshToken string
+}
+
+func (s *server) handleLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req loginRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		sessions := s.config.Sessions
+		var tokens *entity.SessionTokens
+		var err error
+
+		switch {
+		case req.RefreshToken != "":
+			tokens, err = serviceUser.RefreshSession(s.dbFromContext(c), req.RefreshToken, sessions.AccessTokenTTL, sessions.RefreshTokenTTL)
+			if errors.Is(err, serviceUser.ErrInvalidRefreshToken) {
+				abortWithError(c, http.StatusUnauthorized, codeInvalidToken, "%v, log in again", err)
+				return
+			}
+		case req.Login != "":
+			if !s.checkLockout(c, req.Login) {
+				return
+			}
+			acc, ok := s.verifyPassword(c, req.Login, req.Password)
+			if !ok {
+				abortWithError(c, http.StatusUnauthorized, codeInvalidCredentials, "invalid login or password")
+				return
+			}
+			if acc.Disabled {
+				abortWithError(c, http.StatusForbidden, codeAccountDisabled, "account is disabled")
+				return
+			}
+			if acc.TOTPEnabled && !s.verifySecondFactor(c, acc.Login, req.Code) {
+				return
+			}
+			c.Set("login", acc.Login)
+
+			tokens, err = serviceUser.CreateSession(s.dbFromContext(c), acc.Login, sessions.AccessTokenTTL, sessions.RefreshTokenTTL)
+		default:
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest,
+				"either login and password or a refresh token are required")
+			return
+		}
+
+		if err != nil {
+			logger(c).Errorf("creating session failed: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusOK, tokens)
+	}
+}
+
+func (s *server) handleLogout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session, ok := c.Get("session")
+		if !ok {
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest,
+				"not logged in with an access token, nothing to log out")
+			return
+		}
+
+		if err := s.dbFromContext(c).DeleteSession(session.(string)); err != nil {
+			logger(c).Errorf("deleting session failed: %v", err)
+			abortWithInternalError(c)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"time"
+	"tradingServer/logging"
+)
+
+// shutdownCloseReason is sent to web socket clients in the close frame when the server shuts down
+const shutdownCloseReason = "server shutting down, reconnect later"
+
+// shutdown stops the server gracefully. The prices stand still, new connections are refused, requests in flight are
+// finished and web socket clients receive a close frame. Requests still running after server.shutdown_timeout are
+// left behind. The database can be closed afterwards.
+func (s *server) shutdown() {
+	logging.Infof("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Server.ShutdownTimeout)
+	defer cancel()
+
+	// the price makers hand their last update to serveStreamClients, which therefore keeps running until they stopped
+	close(s.stopMarket)
+	<-s.marketDone
+
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		logging.Warnf("requests in flight did not finish in time: %v", err)
+	}
+
+	close(s.stopStreams)
+	done := make(chan struct{})
+	go func() {
+		s.streams.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logging.Warnf("web socket handlers did not finish in time")
+	}
+
+	// execute the orders triggered by the last prices
+	s.orderBook.Stop()
+	logging.Infof("shutdown complete")
+}
+
+// trackStream lets shutdown wait for the web socket handlers, which the HTTP server does not track once the
+// connection has been upgraded
+func (s *server) trackStream() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.streams.Add(1)
+		defer s.streams.Done()
+		c.Next()
+	}
+}
+
+// closeStreamClient sends a close frame to a web socket client and ends its handler
+func closeStreamClient(c *streamClient) {
+	if c.shutdown {
+		return
+	}
+	c.shutdown = true
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, shutdownCloseReason)
+	if err := c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		logging.Errorf("sending close frame to %v failed: %v", c.ws.RemoteAddr(), err)
+	}
+	close(c.events)
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"tradingServer/entity"
+	"tradingServer/serviceUser"
+)
+
+// totpCodeHeader carries the two-factor code of sells above the configured threshold
+const totpCodeHeader = "X-TOTP-Code"
+
+// totpCodeRequest confirms a change of the two-factor settings with a code or recovery code
+type totpCodeRequest struct {
+	Code string
+}
+
+// recoveryCodes are shown once when two-factor authentication is enabled or the codes are replaced
+type recoveryCodes struct {
+	RecoveryCodes []string
+}
+
+// verifySecondFactor checks the two-factor code of a login whose password has been verified. A wrong code counts as
+// a failed login attempt, so checkLockout must have been called before.
+func (s *server) verifySecondFactor(c *gin.Context, login, code string) bool {
+	attempt := authAttemptFromContext(c, login)
+	// the password alone does not clear the failed attempts
+	attempt.verified = false
+
+	if code == "" {
+		abortWithError(c, http.StatusUnauthorized, codeTOTPRequired, "two-factor code required")
+		return false
+	}
+
+	use, err := serviceUser.VerifySecondFactor(s.dbFromContext(c), login, code)
+	switch {
+	case errors.Is(err, serviceUser.ErrTOTPInvalid):
+		logger(c).Warnf("authorization failed: invalid two-factor code for login '%v'", login)
+		attempt.failed = true
+		abortWithError(c, http.StatusUnauthorized, codeInvalidTOTPCode, "%v", err)
+		return false
+	case err != nil:
+		logger(c).Errorf("verifying two-factor code of login '%v' failed: %v", login, err)
+		abortWithInternalError(c)
+		return false
+	}
+
+	attempt.verified = true
+	attempt.secondFactor = use
+	return true
+}
+
+// checkSellCode requires the two-factor code in the X-TOTP-Code header if the account has two-factor authentication
+// enabled and the value of the sell exceeds the configured threshold
+func (s *server) checkSellCode(c *gin.Context, acc *entity.Account, value decimal.Decimal) bool {
+	threshold := s.config.TOTP.SellThreshold.Decimal
+	if !acc.TOTPEnabled || !threshold.IsPositive() || !value.GreaterThan(threshold) {
+		return true
+	}
+
+	code := c.GetHeader(totpCodeHeader)
+	if code == "" {
+		abortWithError(c, http.StatusUnauthorized, codeTOTPRequired,
+			"sells above %v require the two-factor code in the %v header", threshold, totpCodeHeader)
+		return false
+	}
+
+	if !s.checkLockout(c, acc.Login) {
+		return false
+	}
+	return s.verifySecondFactor(c, acc.Login, code)
+}
+
+func (s *server) handleEnrollTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		enrollment, err := serviceUser.BeginTOTPEnrollment(s.dbFromContext(c), login, s.config.TOTP.Issuer)
+		switch {
+		case errors.Is(err, serviceUser.ErrTOTPEnabledAlready):
+			abortWithError(c, http.StatusConflict, codeConflict, "%v", err)
+			return
+		case err != nil:
+			logger(c).Errorf("enrolling login '%v' for two-factor authentication failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		respond(c, http.StatusCreated, enrollment)
+	}
+}
+
+func (s *server) handleEnableTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req totpCodeRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		codes, err := serviceUser.EnableTOTP(s.dbFromContext(c), login, req.Code)
+		switch {
+		case errors.Is(err, serviceUser.ErrTOTPEnabledAlready):
+			abortWithError(c, http.StatusConflict, codeConflict, "%v", err)
+			return
+		case errors.Is(err, serviceUser.ErrTOTPNotEnrolled):
+			abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v, start with POST /account/totp", err)
+			return
+		case errors.Is(err, serviceUser.ErrTOTPInvalid):
+			abortWithError(c, http.StatusBadRequest, codeInvalidTOTPCode, "%v", err)
+			return
+		case err != nil:
+			logger(c).Errorf("enabling two-factor authentication of login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		logger(c).Infof("user '%v' enabled two-factor authentication", login)
+		respond(c, http.StatusOK, recoveryCodes{RecoveryCodes: codes})
+	}
+}
+
+func (s *server) handleDisableTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req totpCodeRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		if !s.checkTOTPChange(c, login, req.Code) {
+			return
+		}
+
+		if err := serviceUser.DisableTOTP(s.dbFromContext(c), login); err != nil {
+			logger(c).Errorf("disabling two-factor authentication of login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		logger(c).Infof("user '%v' disabled two-factor authentication", login)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (s *server) handleRegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req totpCodeRequest
+		if !readJSON(c, &req) {
+			return
+		}
+
+		login, ok := getLoginFromContext(c)
+		if !ok {
+			return
+		}
+
+		if !s.checkTOTPChange(c, login, req.Code) {
+			return
+		}
+
+		codes, err := serviceUser.RegenerateRecoveryCodes(s.dbFromContext(c), login)
+		if err != nil {
+			logger(c).Errorf("replacing recovery codes of login '%v' failed: %v", login, err)
+			abortWithInternalError(c)
+			return
+		}
+
+		logger(c).Infof("user '%v' replaced the recovery codes", login)
+		respond(c, http.StatusOK, recoveryCodes{RecoveryCodes: codes})
+	}
+}
+
+// checkTOTPChange verifies the code confirming a change of the two-factor settings
+func (s *server) checkTOTPChange(c *gin.Context, login, code string) bool {
+	acc, err := s.dbFromContext(c).GetAccount(login)
+	if err != nil {
+		logger(c).Errorf("get account for login '%v' failed: %v", login, err)
+		abortWithInternalError(c)
+		return false
+	}
+	if !acc.TOTPEnabled {
+		abortWithError(c, http.StatusBadRequest, codeInvalidRequest, "%v", serviceUser.ErrTOTPNotEnabled)
+		return false
+	}
+
+	if !s.checkLockout(c, login) {
+		return false
+	}
+	return s.verifySecondFactor(c, login, code)
+}
@@ -1,11 +1,96 @@
 package server
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"runtime/debug"
+)
 
+// Error codes tell clients why a request failed. They are part of the API and must not change, clients branch on them
+// while the messages are meant for humans. They are listed on the index page.
+const (
+	codeInvalidRequest     = "INVALID_REQUEST"
+	codeInvalidAmount      = "INVALID_AMOUNT"
+	codeUnknownAsset       = "UNKNOWN_ASSET"
+	codeAssetHalted        = "ASSET_HALTED"
+	codeInsufficientFunds  = "INSUFFICIENT_FUNDS"
+	codeInsufficientAssets = "INSUFFICIENT_ASSETS"
+	codeQuoteExpired       = "QUOTE_EXPIRED"
+	codeQuoteUsed          = "QUOTE_USED"
+	codeInvalidQuote       = "INVALID_QUOTE"
+	codeOrderNotFound      = "ORDER_NOT_FOUND"
+	codeOrderNotOpen       = "ORDER_NOT_OPEN"
+	codeUnauthorized       = "UNAUTHORIZED"
+	codeInvalidCredentials = "INVALID_CREDENTIALS"
+	codeInvalidToken       = "INVALID_TOKEN"
+	codeInvalidAPIKey      = "INVALID_API_KEY"
+	codeTOTPRequired       = "TOTP_REQUIRED"
+	codeInvalidTOTPCode    = "INVALID_TOTP_CODE"
+	codeAccountDisabled    = "ACCOUNT_DISABLED"
+	codeForbidden          = "FORBIDDEN"
+	codeNotFound           = "NOT_FOUND"
+	codeConflict           = "CONFLICT"
+	codeLockedOut          = "LOCKED_OUT"
+	codeRateLimited        = "RATE_LIMITED"
+	codeInternalError      = "INTERNAL_ERROR"
+)
+
+// userError is the body of every error response
 type userError struct {
+	Code    string
 	Message string
+	// RequestID is the X-Request-ID of the request, to find its messages in the logs
+	RequestID string
+}
+
+// abortWithError ends the request with an error response
+func abortWithError(c *gin.Context, status int, code string, msg string, args ...interface{}) {
+	c.AbortWithStatusJSON(status, userError{
+		Code:      code,
+		Message:   fmt.Sprintf(msg, args...),
+		RequestID: c.GetString("requestID"),
+	})
+}
+
+// abortWithInternalError ends the request with status 500. The cause is not told to the client, it has to be logged.
+func abortWithInternalError(c *gin.Context) {
+	abortWithError(c, http.StatusInternalServerError, codeInternalError, "internal server error")
 }
 
-func newUserError(msg string, args ...interface{}) userError {
-	return userError{ Message: fmt.Sprintf(msg, args...)}
+// recovery answers requests whose handler panicked with status 500 instead of dropping the connection
+func (s *server) recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				logger(c).Errorf("panic: %v\n%s", err, debug.Stack())
+				abortWithInternalError(c)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// handleNoRoute answers requests to unknown paths
+func (s *server) handleNoRoute() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		abortWithError(c, http.StatusNotFound, codeNotFound, "no endpoint %v %v", c.Request.Method, c.Request.URL.Path)
+	}
+}
+
+// websocketError answers a failed web socket handshake with an error response like any other
+func websocketError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	code := codeInvalidRequest
+	if status == http.StatusForbidden {
+		code = codeForbidden
+	}
+
+	body, _ := json.Marshal(userError{Code: code, Message: reason.Error(), RequestID: w.Header().Get(requestIDHeader)})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
 }
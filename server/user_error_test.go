@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := &server{}
+	router := gin.New()
+	router.Use(s.requestID(), s.recovery())
+	router.NoRoute(s.handleNoRoute())
+	router.GET("/panic", func(c *gin.Context) {
+		panic("broken")
+	})
+	router.GET("/invalid", func(c *gin.Context) {
+		abortWithError(c, http.StatusBadRequest, codeInvalidAmount, "amount %v is not positive", -1)
+	})
+
+	tests := []struct {
+		path        string
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+	}{
+		{"/panic", http.StatusInternalServerError, codeInternalError, "internal server error"},
+		{"/invalid", http.StatusBadRequest, codeInvalidAmount, "amount -1 is not positive"},
+		{"/unknown", http.StatusNotFound, codeNotFound, "no endpoint GET /unknown"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set(requestIDHeader, "test-request")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var res userError
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Errorf("%v: response is no error envelope: %v: %v", tt.path, w.Body, err)
+			continue
+		}
+		want := userError{Code: tt.wantCode, Message: tt.wantMessage, RequestID: "test-request"}
+		if w.Code != tt.wantStatus || res != want {
+			t.Errorf("%v: response = %v %+v, want %v %+v", tt.path, w.Code, res, tt.wantStatus, want)
+		}
+	}
+}
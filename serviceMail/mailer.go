@@ -0,0 +1,102 @@
+package serviceMail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"sync"
+	"time"
+	"tradingServer/config"
+	"tradingServer/logging"
+)
+
+// Mailer sends mails to users, e.g. to confirm a new email address
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// New returns the mailer of the configured backend
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Backend {
+	case "log":
+		return &logMailer{from: cfg.From}, nil
+	case "file":
+		return &fileMailer{from: cfg.From, file: cfg.File}, nil
+	case "smtp":
+		m := &smtpMailer{from: cfg.From, addr: cfg.SMTPAddr}
+		if cfg.SMTPUser != "" {
+			host, _, err := net.SplitHostPort(cfg.SMTPAddr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid smtp address '%v': %v", cfg.SMTPAddr, err)
+			}
+			m.auth = smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPassword, host)
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unknown mail backend '%v'", cfg.Backend)
+	}
+}
+
+// logMailer writes mails to the server log instead of sending them, for local testing
+type logMailer struct {
+	from string
+}
+
+func (m *logMailer) Send(to, subject, body string) error {
+	logging.Infof("mail from %v to %v: %v\n%v", m.from, to, subject, body)
+	return nil
+}
+
+// fileMailer appends mails to a file instead of sending them, for local testing
+type fileMailer struct {
+	sync.Mutex
+	from string
+	file string
+}
+
+func (m *fileMailer) Send(to, subject, body string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	f, err := os.OpenFile(m.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open mail file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(message(m.from, to, subject, body), '\n')); err != nil {
+		return fmt.Errorf("could not write mail file: %v", err)
+	}
+	return nil
+}
+
+type smtpMailer struct {
+	from string
+	addr string
+	auth smtp.Auth
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, message(m.from, to, subject, body)); err != nil {
+		return fmt.Errorf("sending mail to %v failed: %v", to, err)
+	}
+	return nil
+}
+
+// message formats a plain text mail. The addresses must have been validated, they are not escaped.
+func message(from, to, subject, body string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %v\r\n", from)
+	fmt.Fprintf(&buf, "To: %v\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(body)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
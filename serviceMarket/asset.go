@@ -1,32 +1,46 @@
 package serviceMarket
 
 import (
+	"errors"
 	"github.com/shopspring/decimal"
 	"log"
 	"tradingServer/entity"
 	"tradingServer/storage"
 )
 
-func AddAsset(name string, price float64) error {
-	db := storage.GetDatabase()
-	defer db.Close()
+var ErrUnknownAsset = errors.New("unknown asset")
+var ErrAssetHalted = errors.New("trading of the asset is halted")
 
+// CheckTradable returns ErrUnknownAsset or ErrAssetHalted unless the asset can be traded
+func CheckTradable(db storage.Storage, name string) error {
+	asset, err := db.GetAsset(name)
+	if err != nil {
+		return err
+	}
+	if asset == nil {
+		return ErrUnknownAsset
+	}
+	if asset.Halted {
+		return ErrAssetHalted
+	}
+	return nil
+}
+
+func AddAsset(db storage.Storage, name string, price decimal.Decimal) error {
 	ma := entity.MarketAsset{
 		Name:  name,
-		Price: decimal.NewFromFloat(price),
+		Price: price,
 	}
 	return db.CreateMarketAsset(ma)
 }
 
-func ResetPrices() {
-	initialPrices := make(map[string]float64)
-	initialPrices["white_wool"] = 35.0
-	initialPrices["black_wool"] = 32.0
-	initialPrices["toothpaste"] = 8.5
-	initialPrices["old_tires"] = 19.2
-	initialPrices["olive_oil"] = 127.0
-
-	db := storage.GetDatabase()
+func ResetPrices(db storage.Storage) {
+	initialPrices := make(map[string]decimal.Decimal)
+	initialPrices["white_wool"] = decimal.RequireFromString("35.0")
+	initialPrices["black_wool"] = decimal.RequireFromString("32.0")
+	initialPrices["toothpaste"] = decimal.RequireFromString("8.5")
+	initialPrices["old_tires"] = decimal.RequireFromString("19.2")
+	initialPrices["olive_oil"] = decimal.RequireFromString("127.0")
 
 	for name, price := range initialPrices {
 		err := db.UpdateMarketAsset(name, price)
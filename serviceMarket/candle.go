@@ -0,0 +1,199 @@
+package serviceMarket
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sort"
+	"sync"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+// CandleIntervals lists the supported candle intervals by name
+var CandleIntervals = map[string]time.Duration{
+	"1s": time.Second,
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+}
+
+// number of candles kept in memory per asset and interval
+const candleHistoryLength = 500
+
+// candleLoadPage is the number of prices Load reads from the price history at a time
+const candleLoadPage = 10000
+
+// CandleAggregator builds the candles of every asset from its price updates. The server loads the candles from the
+// price history on start, only the volume of the trades before is lost.
+type CandleAggregator struct {
+	sync.Mutex
+	// asset name -> interval name -> candles, oldest first
+	series map[string]map[string][]entity.Candle
+}
+
+func NewCandleAggregator() *CandleAggregator {
+	return &CandleAggregator{
+		series: make(map[string]map[string][]entity.Candle),
+	}
+}
+
+// Load replays the price history of the time the kept candles cover and returns the number of prices read
+func (ca *CandleAggregator) Load(db storage.Storage, now time.Time) (int, error) {
+	var longest time.Duration
+	for _, interval := range CandleIntervals {
+		if interval > longest {
+			longest = interval
+		}
+	}
+
+	from := now.Add(-candleHistoryLength * longest).Truncate(longest)
+	n := 0
+	for {
+		prices, err := db.GetPriceHistory("", from, now, candleLoadPage)
+		if err != nil {
+			return n, err
+		}
+
+		full := len(prices) == candleLoadPage
+		if full {
+			// the next page starts at the time of the last price, there may be more prices at that time
+			from = prices[len(prices)-1].When
+			for len(prices) > 0 && prices[len(prices)-1].When.Equal(from) {
+				prices = prices[:len(prices)-1]
+			}
+			if len(prices) == 0 {
+				return n, fmt.Errorf("more than %v prices at %v", candleLoadPage, from)
+			}
+		}
+
+		for _, p := range prices {
+			ca.AddPrice(p)
+		}
+		n += len(prices)
+
+		if !full {
+			return n, nil
+		}
+	}
+}
+
+// AddPrice records a price update and returns the updated candle of every interval
+func (ca *CandleAggregator) AddPrice(ev entity.MarketAsset) []entity.Candle {
+	ca.Lock()
+	defer ca.Unlock()
+
+	var updated []entity.Candle
+	for name, interval := range CandleIntervals {
+		c := ca.current(ev.Name, name, interval, ev.When, ev.Price)
+		if c == nil {
+			// late update for a closed candle, ignore it
+			continue
+		}
+
+		if ev.Price.GreaterThan(c.High) {
+			c.High = ev.Price
+		}
+		if ev.Price.LessThan(c.Low) {
+			c.Low = ev.Price
+		}
+		c.Close = ev.Price
+
+		updated = append(updated, *c)
+	}
+
+	return updated
+}
+
+// AddTrade adds the amount of a trade to the volume of the candles and returns the updated candle of every interval.
+// Trades do not move the price, the price updates do. A candle starting with a trade opens at the last close, trades
+// of assets without candles yet are not counted.
+func (ca *CandleAggregator) AddTrade(assetName string, amount decimal.Decimal, when time.Time) []entity.Candle {
+	ca.Lock()
+	defer ca.Unlock()
+
+	var updated []entity.Candle
+	for name, interval := range CandleIntervals {
+		candles := ca.series[assetName][name]
+		if len(candles) == 0 {
+			continue
+		}
+
+		c := ca.current(assetName, name, interval, when, candles[len(candles)-1].Close)
+		if c == nil {
+			continue
+		}
+		c.Volume = c.Volume.Add(amount)
+
+		updated = append(updated, *c)
+	}
+
+	return updated
+}
+
+// current returns the candle of an asset and interval the given time falls into. A new candle is opened at the given
+// price if the time is past the last one, nil is returned for the time of a closed candle.
+func (ca *CandleAggregator) current(assetName string, name string, interval time.Duration, when time.Time, price decimal.Decimal) *entity.Candle {
+	assetSeries, ok := ca.series[assetName]
+	if !ok {
+		assetSeries = make(map[string][]entity.Candle)
+		ca.series[assetName] = assetSeries
+	}
+
+	start := when.Truncate(interval)
+	candles := assetSeries[name]
+
+	last := len(candles) - 1
+	if last < 0 || candles[last].Start.Before(start) {
+		candles = append(candles, entity.Candle{
+			Asset:    assetName,
+			Interval: name,
+			Start:    start,
+			Open:     price,
+			High:     price,
+			Low:      price,
+			Close:    price,
+			Volume:   decimal.Zero,
+		})
+		last++
+
+		if len(candles) > candleHistoryLength {
+			candles = candles[len(candles)-candleHistoryLength:]
+			last = len(candles) - 1
+		}
+		assetSeries[name] = candles
+	} else if candles[last].Start.After(start) {
+		return nil
+	}
+
+	return &candles[last]
+}
+
+// GetCandles returns up to limit of the most recent candles of an interval, oldest first.
+// Candles of all assets are returned if assetName is empty.
+func (ca *CandleAggregator) GetCandles(assetName string, interval string, limit int) []entity.Candle {
+	ca.Lock()
+	defer ca.Unlock()
+
+	result := []entity.Candle{}
+	for name, assetSeries := range ca.series {
+		if assetName != "" && name != assetName {
+			continue
+		}
+
+		candles := assetSeries[interval]
+		if len(candles) > limit {
+			candles = candles[len(candles)-limit:]
+		}
+		result = append(result, candles...)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start.Equal(result[j].Start) {
+			return result[i].Asset < result[j].Asset
+		}
+		return result[i].Start.Before(result[j].Start)
+	})
+
+	return result
+}
@@ -0,0 +1,171 @@
+package serviceMarket
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+var candleStart = time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func price(offset time.Duration, p string) entity.MarketAsset {
+	return entity.MarketAsset{Name: "toothpaste", Price: decimal.RequireFromString(p), When: candleStart.Add(offset)}
+}
+
+func TestCandleAggregation(t *testing.T) {
+	ca := NewCandleAggregator()
+	ca.AddPrice(price(0, "8.5"))
+	ca.AddPrice(price(10*time.Second, "9"))
+	ca.AddPrice(price(20*time.Second, "8"))
+	// trades add to the volume without moving the price
+	ca.AddTrade("toothpaste", decimal.NewFromInt(3), candleStart.Add(30*time.Second))
+	ca.AddPrice(price(70*time.Second, "8.4"))
+	// a late price for the closed first minute
+	ca.AddPrice(price(40*time.Second, "100"))
+	// a trade opening a candle, and one of an asset without a price yet
+	ca.AddTrade("toothpaste", decimal.NewFromInt(2), candleStart.Add(130*time.Second))
+	ca.AddTrade("olive_oil", decimal.NewFromInt(1), candleStart)
+
+	tests := []struct {
+		interval string
+		start    time.Duration
+		open     string
+		high     string
+		low      string
+		close    string
+		volume   int64
+	}{
+		{"1m", 0, "8.5", "9", "8", "8", 3},
+		{"1m", time.Minute, "8.4", "8.4", "8.4", "8.4", 0},
+		{"1m", 2 * time.Minute, "8.4", "8.4", "8.4", "8.4", 2},
+		{"5m", 0, "8.5", "100", "8", "100", 5},
+		{"1h", 0, "8.5", "100", "8", "100", 5},
+	}
+
+	for _, tt := range tests {
+		var c *entity.Candle
+		for _, candle := range ca.GetCandles("toothpaste", tt.interval, 10) {
+			if candle.Start.Equal(candleStart.Add(tt.start)) {
+				candle := candle
+				c = &candle
+			}
+		}
+		if c == nil {
+			t.Errorf("%v candle at %v missing", tt.interval, tt.start)
+			continue
+		}
+		got := []decimal.Decimal{c.Open, c.High, c.Low, c.Close, c.Volume}
+		want := []string{tt.open, tt.high, tt.low, tt.close}
+		for i, w := range want {
+			if !got[i].Equal(decimal.RequireFromString(w)) {
+				t.Errorf("%v candle at %v = %v/%v/%v/%v, want %v/%v/%v/%v", tt.interval, tt.start,
+					c.Open, c.High, c.Low, c.Close, tt.open, tt.high, tt.low, tt.close)
+				break
+			}
+		}
+		if !c.Volume.Equal(decimal.NewFromInt(tt.volume)) {
+			t.Errorf("%v candle at %v volume = %v, want %v", tt.interval, tt.start, c.Volume, tt.volume)
+		}
+	}
+
+	if n := len(ca.GetCandles("toothpaste", "1s", 10)); n != 6 {
+		t.Errorf("got %v 1s candles, want 6", n)
+	}
+	if got := ca.GetCandles("olive_oil", "1m", 10); len(got) != 0 {
+		t.Errorf("trade without price opened candles %v", got)
+	}
+}
+
+// historyStorage serves a fixed price history ordered by time and asset
+type historyStorage struct {
+	storage.Storage
+	history []entity.MarketAsset
+}
+
+func (h historyStorage) GetPriceHistory(assetName string, from, to time.Time, limit int) ([]entity.MarketAsset, error) {
+	var prices []entity.MarketAsset
+	for _, p := range h.history {
+		if len(prices) < limit && !p.When.Before(from) && !p.When.After(to) {
+			prices = append(prices, p)
+		}
+	}
+	return prices, nil
+}
+
+func TestLoadCandles(t *testing.T) {
+	// a price per second for two assets, more than fit in one page, the price is the second
+	const seconds = 6000
+	db := historyStorage{}
+	for i := 0; i < seconds; i++ {
+		for _, asset := range []string{"olive_oil", "toothpaste"} {
+			db.history = append(db.history, entity.MarketAsset{Name: asset, Price: decimal.NewFromInt(int64(i)), When: candleStart.Add(time.Duration(i) * time.Second)})
+		}
+	}
+
+	ca := NewCandleAggregator()
+	n, err := ca.Load(db, candleStart.Add(seconds*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(db.history) {
+		t.Errorf("Load() read %v prices, want %v", n, len(db.history))
+	}
+
+	for _, asset := range []string{"olive_oil", "toothpaste"} {
+		candles := ca.GetCandles(asset, "1m", 1000)
+		if len(candles) != seconds/60 {
+			t.Errorf("%v: got %v 1m candles, want %v", asset, len(candles), seconds/60)
+			continue
+		}
+		for i, c := range candles {
+			open, close := decimal.NewFromInt(int64(i*60)), decimal.NewFromInt(int64(i*60+59))
+			if !c.Open.Equal(open) || !c.Low.Equal(open) || !c.Close.Equal(close) || !c.High.Equal(close) {
+				t.Errorf("%v: 1m candle %v = %v/%v/%v/%v, want %v/%v/%v/%v", asset, i, c.Open, c.High, c.Low, c.Close,
+					open, close, open, close)
+				break
+			}
+		}
+	}
+}
+
+func TestGetCandles(t *testing.T) {
+	ca := NewCandleAggregator()
+	for i := 0; i < candleHistoryLength+10; i++ {
+		ca.AddPrice(price(time.Duration(i)*time.Minute, "1"))
+	}
+	ca.AddPrice(entity.MarketAsset{Name: "olive_oil", Price: decimal.NewFromInt(127), When: candleStart})
+
+	tests := []struct {
+		name     string
+		asset    string
+		interval string
+		limit    int
+		want     int
+	}{
+		{"limit", "toothpaste", "1m", 10, 10},
+		{"history length", "toothpaste", "1m", 1000, candleHistoryLength},
+		{"all assets", "", "1h", 100, 10},
+		{"other asset", "olive_oil", "1m", 100, 1},
+		{"unknown interval", "toothpaste", "2m", 100, 0},
+	}
+
+	for _, tt := range tests {
+		got := ca.GetCandles(tt.asset, tt.interval, tt.limit)
+		if len(got) != tt.want {
+			t.Errorf("%v: GetCandles() returned %v candles, want %v", tt.name, len(got), tt.want)
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i].Start.Before(got[i-1].Start) {
+				t.Errorf("%v: candles not ordered by start", tt.name)
+			}
+		}
+	}
+
+	// the most recent candles are returned
+	got := ca.GetCandles("toothpaste", "1m", 1)
+	if want := candleStart.Add((candleHistoryLength + 9) * time.Minute); len(got) != 1 || !got[0].Start.Equal(want) {
+		t.Errorf("GetCandles() with limit 1 = %v, want the candle at %v", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package servicePriceVariation
+
+import (
+	"time"
+	"tradingServer/logging"
+	"tradingServer/storage"
+)
+
+const historyCompactionInterval = 10 * time.Minute
+
+// retention rules for the price history, applied in order. Every tick is kept for a day, then one price per minute
+// for a week, then one price per hour for a year. Older prices are deleted.
+var historyRetention = []struct {
+	age    time.Duration
+	bucket time.Duration
+}{
+	{24 * time.Hour, time.Minute},
+	{7 * 24 * time.Hour, time.Hour},
+}
+
+const historyMaxAge = 365 * 24 * time.Hour
+
+// RunHistoryCompaction periodically downsamples and purges the stored price history, keeping the database bounded,
+// until stop is closed.
+func RunHistoryCompaction(db storage.Storage, stop <-chan struct{}) {
+	ticker := time.NewTicker(historyCompactionInterval)
+	defer ticker.Stop()
+
+	for {
+		compactHistory(db, time.Now())
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func compactHistory(db storage.Storage, now time.Time) {
+	for _, r := range historyRetention {
+		n, err := db.DownsamplePriceHistory(now.Add(-r.age), r.bucket)
+		if err != nil {
+			logging.Errorf("price history compaction failed: %v", err)
+			return
+		}
+		if n > 0 {
+			logging.Infof("price history: downsampled %v entries older than %v to one per %v", n, r.age, r.bucket)
+		}
+	}
+
+	n, err := db.PurgePriceHistory(now.Add(-historyMaxAge))
+	if err != nil {
+		logging.Errorf("price history purge failed: %v", err)
+		return
+	}
+	if n > 0 {
+		logging.Infof("price history: purged %v entries older than %v", n, historyMaxAge)
+	}
+}
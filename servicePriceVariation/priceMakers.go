@@ -0,0 +1,126 @@
+package servicePriceVariation
+
+import (
+	"github.com/shopspring/decimal"
+	"sort"
+	"sync"
+	"tradingServer/config"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+// PriceMakers runs a PriceMaker for every asset being traded, so assets can be added, halted and resumed at runtime
+type PriceMakers struct {
+	sync.Mutex
+	makers map[string]*PriceMaker
+	// stopped is set by StopAll, no price maker is started afterwards
+	stopped bool
+
+	db       storage.Storage
+	settings config.PriceVariationConfig
+	ev       chan entity.MarketAsset
+}
+
+func NewPriceMakers(db storage.Storage, settings config.PriceVariationConfig, ev chan entity.MarketAsset) *PriceMakers {
+	return &PriceMakers{
+		makers:   make(map[string]*PriceMaker),
+		db:       db,
+		settings: settings,
+		ev:       ev,
+	}
+}
+
+// Start starts varying the price of an asset beginning at the given price unless it is running already
+func (p *PriceMakers) Start(assetName string, price decimal.Decimal) {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.makers[assetName]; ok || p.stopped {
+		return
+	}
+
+	pm := NewPriceMaker(p.db, p.settings, assetName, price, p.ev)
+	p.makers[assetName] = pm
+	go pm.Run()
+}
+
+// Stop stops varying the price of an asset
+func (p *PriceMakers) Stop(assetName string) {
+	p.Lock()
+	defer p.Unlock()
+
+	if pm, ok := p.makers[assetName]; ok {
+		pm.Stop()
+		delete(p.makers, assetName)
+	}
+}
+
+// Sync starts the price makers of the given assets which are not halted and stops all others, e.g. after admins of
+// another server instance changed the assets
+func (p *PriceMakers) Sync(assets []entity.MarketAsset) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.stopped {
+		return
+	}
+
+	trading := make(map[string]bool, len(assets))
+	for _, a := range assets {
+		if a.Halted {
+			continue
+		}
+		trading[a.Name] = true
+		if _, ok := p.makers[a.Name]; !ok {
+			pm := NewPriceMaker(p.db, p.settings, a.Name, a.Price, p.ev)
+			p.makers[a.Name] = pm
+			go pm.Run()
+		}
+	}
+
+	for assetName, pm := range p.makers {
+		if !trading[assetName] {
+			pm.Stop()
+			delete(p.makers, assetName)
+		}
+	}
+}
+
+// StopAll stops all price makers for good when the server shuts down. It returns once they have stored their last
+// price, the channel of price updates has to be read until then.
+func (p *PriceMakers) StopAll() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.stopped = true
+	for _, pm := range p.makers {
+		pm.Stop()
+	}
+	for assetName, pm := range p.makers {
+		pm.Wait()
+		delete(p.makers, assetName)
+	}
+}
+
+// Running tells whether the price makers run, which they do until StopAll is called
+func (p *PriceMakers) Running() bool {
+	p.Lock()
+	defer p.Unlock()
+
+	return !p.stopped
+}
+
+// Stale returns the sorted names of the assets whose price makers have missed several updates
+func (p *PriceMakers) Stale() []string {
+	p.Lock()
+	defer p.Unlock()
+
+	var stale []string
+	for assetName, pm := range p.makers {
+		if pm.Stale() {
+			stale = append(stale, assetName)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
@@ -0,0 +1,96 @@
+package servicePriceVariation
+
+import (
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+	"tradingServer/config"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+func TestPriceMakersStopAll(t *testing.T) {
+	db := storage.NewMemory()
+	settings := config.Default().PriceVariation
+	settings.MinUpdateInterval = time.Millisecond
+
+	ev := make(chan entity.MarketAsset)
+	pms := NewPriceMakers(db, settings, ev)
+
+	updates := make(map[string]decimal.Decimal)
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for u := range ev {
+			updates[u.Name] = u.Price
+		}
+	}()
+
+	assets, err := db.GetAssets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assets[0].Halted = true
+	pms.Sync(assets)
+	time.Sleep(50 * time.Millisecond)
+
+	// StopAll returns once the last prices are stored
+	pms.StopAll()
+	pms.Sync(assets)
+	pms.Start(assets[0].Name, assets[0].Price)
+	close(ev)
+	<-read
+
+	if len(pms.makers) != 0 {
+		t.Errorf("%v price makers left after StopAll()", len(pms.makers))
+	}
+	if _, ok := updates[assets[0].Name]; ok {
+		t.Errorf("price of halted asset %v has been varied", assets[0].Name)
+	}
+	for _, a := range assets[1:] {
+		price, err := db.GetAssetPrice(a.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last, ok := updates[a.Name]; ok && !last.Equal(price) {
+			t.Errorf("stored price of %v = %v, want the last update %v", a.Name, price, last)
+		}
+	}
+}
+
+func TestPriceMakersStale(t *testing.T) {
+	db := storage.NewMemory()
+	settings := config.Default().PriceVariation
+	settings.MinChangeInterval = 100 * time.Millisecond
+	settings.MaxChangeInterval = 100 * time.Millisecond
+	settings.MinUpdateInterval = 5 * time.Millisecond
+
+	ev := make(chan entity.MarketAsset)
+	pms := NewPriceMakers(db, settings, ev)
+	pms.Start("toothpaste", decimal.NewFromInt(10))
+
+	if stale := pms.Stale(); len(stale) != 0 {
+		t.Errorf("Stale() of a new price maker = %v", stale)
+	}
+
+	// nobody reads the price updates, so the price maker is stuck publishing its first one
+	time.Sleep(200 * time.Millisecond)
+	if stale := pms.Stale(); len(stale) != 1 || stale[0] != "toothpaste" {
+		t.Errorf("Stale() of a stuck price maker = %v, want [toothpaste]", stale)
+	}
+
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for range ev {
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if stale := pms.Stale(); len(stale) != 0 {
+		t.Errorf("Stale() of a price maker updating again = %v", stale)
+	}
+
+	pms.StopAll()
+	close(ev)
+	<-read
+}
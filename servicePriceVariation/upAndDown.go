@@ -2,15 +2,15 @@ package servicePriceVariation
 
 import (
 	"github.com/shopspring/decimal"
-	"log"
 	"math/rand"
+	"sync"
 	"time"
+	"tradingServer/config"
 	"tradingServer/entity"
+	"tradingServer/logging"
 	"tradingServer/storage"
 )
 
-const minimumUpdateIntervalSeconds = 0.2
-
 type PriceMaker struct {
 	assetName    string
 	currentPrice decimal.Decimal
@@ -21,9 +21,23 @@ type PriceMaker struct {
 	lastChange     time.Time
 
 	priceUpdates chan entity.MarketAsset
+	db           storage.Storage
+	settings     config.PriceVariationConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	// tickMu guards the time Run last waited for the next update and how long it waited, see Stale
+	tickMu       sync.Mutex
+	lastTick     time.Time
+	tickInterval time.Duration
 }
 
-func NewPriceMaker(assetName string, startPrice decimal.Decimal, ev chan entity.MarketAsset) *PriceMaker {
+// staleTicks is the number of update intervals a price maker may miss before it is considered stale
+const staleTicks = 10
+
+func NewPriceMaker(db storage.Storage, settings config.PriceVariationConfig, assetName string, startPrice decimal.Decimal, ev chan entity.MarketAsset) *PriceMaker {
 	pm := &PriceMaker{
 		assetName:    assetName,
 		currentPrice: startPrice,
@@ -33,9 +47,15 @@ func NewPriceMaker(assetName string, startPrice decimal.Decimal, ev chan entity.
 		lastChange:  time.Now(),
 
 		priceUpdates: ev,
+		db:           db,
+		settings:     settings,
+
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
 	}
 
 	pm.generateTarget()
+	pm.tick(pm.updateInterval())
 
 	return pm
 }
@@ -48,7 +68,7 @@ func (pm *PriceMaker) update() {
 	stepAmount := time.Now().Sub(pm.lastChange).Seconds() / pm.changeInterval
 
 	if pm.currentPrice.Sub(pm.targetPrice).Abs().LessThanOrEqual(decimal.NewFromFloat(stepAmount)) {
-		log.Printf("PriceMaker %v update: %v\n", pm.assetName, pm.currentPrice.StringFixed(3))
+		logging.Debugf("PriceMaker %v update: %v", pm.assetName, pm.currentPrice.StringFixed(3))
 		pm.generateTarget()
 	}
 
@@ -72,12 +92,7 @@ func (pm *PriceMaker) step() {
 	pm.currentPrice = pm.currentPrice.Add(deltaDec)
 	//log.Printf("PriceMaker %v price step: %v\n", pm.assetName, pm.currentPrice.StringFixed(3))
 
-	// store new price
-	db := storage.GetDatabase()
-	err := db.SetAssetPrice(pm.assetName, pm.currentPrice)
-	if err != nil {
-		log.Printf("store new asset price failed for asset %v: %v\n", pm.assetName, err)
-	}
+	pm.storePrice()
 
 	pm.lastChange = time.Now()
 
@@ -88,13 +103,23 @@ func (pm *PriceMaker) step() {
 	}
 }
 
+// storePrice saves the current price and adds it to the price history
+func (pm *PriceMaker) storePrice() {
+	err := pm.db.SetAssetPrice(pm.assetName, pm.currentPrice)
+	if err != nil {
+		logging.Errorf("store new asset price failed for asset %v: %v", pm.assetName, err)
+	}
+}
+
 func (pm *PriceMaker) generateTarget() {
-	// pick new random price variation +-[0,startPrice/2 * 0.1]
-	delta := decimal.NewFromFloat((rand.Float64() - 0.5) * 0.1)
+	// pick new random price variation +-[0,startPrice * max deviation]
+	delta := decimal.NewFromFloat((rand.Float64()*2 - 1) * pm.settings.MaxDeviation)
 	pm.targetPrice = pm.startPrice.Add(pm.startPrice.Mul(delta))
 
-	// pick a random change interval (in seconds) from range [1,10]
-	pm.changeInterval = 9*rand.Float64() + 1
+	// pick a random change interval (in seconds) from range [min,max]
+	minInterval := pm.settings.MinChangeInterval.Seconds()
+	maxInterval := pm.settings.MaxChangeInterval.Seconds()
+	pm.changeInterval = (maxInterval-minInterval)*rand.Float64() + minInterval
 
 	//deltaAbs := pm.startPrice.Mul(delta).Div(decimal.NewFromFloat(pm.changeInterval))
 	//log.Printf("PriceMaker %v new target price %v (%.3fs; delta=%v/s)\n",
@@ -104,15 +129,61 @@ func (pm *PriceMaker) generateTarget() {
 	//	deltaAbs.StringFixed(3))
 }
 
+// Run updates the price until Stop is called
 func (pm *PriceMaker) Run() {
-	for {
-		subInterval := pm.changeInterval / 100
+	defer close(pm.done)
 
-		if subInterval < minimumUpdateIntervalSeconds {
-			subInterval = minimumUpdateIntervalSeconds
+	for {
+		interval := pm.updateInterval()
+		pm.tick(interval)
+
+		select {
+		case <-pm.stop:
+			// the asset keeps the price it stopped at, even if storing a step failed
+			pm.storePrice()
+			return
+		case <-time.After(interval):
 		}
-
-		time.Sleep(time.Duration(subInterval * float64(time.Second)))
 		pm.update()
 	}
 }
+
+// updateInterval returns the time between two price updates of the current change
+func (pm *PriceMaker) updateInterval() time.Duration {
+	subInterval := pm.changeInterval / 100
+
+	if subInterval < pm.settings.MinUpdateInterval.Seconds() {
+		subInterval = pm.settings.MinUpdateInterval.Seconds()
+	}
+	return time.Duration(subInterval * float64(time.Second))
+}
+
+// tick records that Run waits for the next update, which is due after the given interval
+func (pm *PriceMaker) tick(interval time.Duration) {
+	pm.tickMu.Lock()
+	defer pm.tickMu.Unlock()
+
+	pm.lastTick = time.Now()
+	pm.tickInterval = interval
+}
+
+// Stale tells whether the price maker has missed several updates, e.g. because it is stuck storing a price or
+// publishing it
+func (pm *PriceMaker) Stale() bool {
+	pm.tickMu.Lock()
+	defer pm.tickMu.Unlock()
+
+	return time.Since(pm.lastTick) > staleTicks*pm.tickInterval
+}
+
+// Stop ends Run after the current price update, the asset keeps its last price
+func (pm *PriceMaker) Stop() {
+	pm.stopOnce.Do(func() {
+		close(pm.stop)
+	})
+}
+
+// Wait returns once Run has ended
+func (pm *PriceMaker) Wait() {
+	<-pm.done
+}
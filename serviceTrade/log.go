@@ -1,11 +1,11 @@
 package serviceTrade
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"sort"
 	"strings"
+	"time"
 	"tradingServer/storage"
 )
 
@@ -16,37 +16,30 @@ type logEntry struct {
 	Duration   float64
 	Status     int    // optional: HTTP status
 	AssetInfo  string // optional: name, unit_amount, unit_price, payed_price, new_balance
+	RequestID  string // optional: X-Request-ID of the request
 }
 
 // ShowLastLog prints the last <count> transaction log messages and the last <count> access log messages to the console
-func ShowLastLog(count int) {
-	db := storage.GetDatabase()
-
-	q1 := `SELECT time,login,action,unit_price,payed_price,amount,asset,balance FROM transaction_log ORDER BY time DESC LIMIT ?`
-	res1, err := db.Query(q1, count)
+func ShowLastLog(db storage.Storage, count int) {
+	transactions, err := db.GetTransactionLog(count)
 	if err != nil {
-		log.Fatalf("transaction log query failed: %v", err)
+		log.Fatalf("%v", err)
 	}
 
 	var logs []logEntry
-	for res1.Next() {
-		logs = append(logs, packLogTransaction(res1))
+	for _, e := range transactions {
+		logs = append(logs, packLogTransaction(e))
 	}
 
-	res1.Close()
-
-	q2 := `SELECT time,duration,login,path,status,address FROM access_log ORDER BY time DESC LIMIT ?`
-	res2, err := db.Query(q2, count)
+	accesses, err := db.GetAccessLog(count)
 	if err != nil {
-		log.Fatalf("access log query failed: %v", err)
+		log.Fatalf("%v", err)
 	}
 
-	for res2.Next() {
-		logs = append(logs, packLogAccess(res2))
+	for _, e := range accesses {
+		logs = append(logs, packLogAccess(e))
 	}
 
-	res2.Close()
-
 	sort.Slice(logs, func(i, j int) bool {
 		// sort by time descending
 		return logs[i].Time > logs[j].Time
@@ -57,51 +50,47 @@ func ShowLastLog(count int) {
 	}
 }
 
-func packLogAccess(row *sql.Rows) logEntry {
-	var l logEntry
-	var ip string
-
-	err := row.Scan(&l.Time, &l.Duration, &l.Login, &l.ActionPath, &l.Status, &ip)
-	if err != nil {
-		log.Fatalf("scan access log: %v", err)
+func packLogAccess(e storage.AccessLogEntry) logEntry {
+	l := logEntry{
+		Time:       e.Time.Format(time.RFC3339Nano),
+		Login:      e.Login,
+		ActionPath: e.Path,
+		Duration:   e.Duration,
+		Status:     e.StatusCode,
+		RequestID:  e.RequestID,
 	}
 
+	if e.Event != "" {
+		l.ActionPath += fmt.Sprintf(" [%v]", e.Event)
+	}
+	if e.APIKeyID != 0 {
+		l.Login += fmt.Sprintf("[api key %v]", e.APIKeyID)
+	}
 	if l.Login != "" {
 		l.Login += "/"
 	}
-	if ip != "" {
-		l.Login += ip
+	if e.RemoteAddress != "" {
+		l.Login += e.RemoteAddress
 	}
 
-	l.Time = convertTimestampAccessLog(l.Time)
-
 	return l
 }
 
-// convertTimestampAccessLog inserts 'T' between date and time expected in: 2022-12-09 12:13:59.480980086+01:00 out: 2022-12-09T12:13:59.480980086+01:00
-func convertTimestampAccessLog(timeStr string) string {
-	return strings.Replace(timeStr, " ", "T", 1)
-}
-
-func packLogTransaction(row *sql.Rows) logEntry {
-	var l logEntry
-	var unit_price float64
-	var payed_price float64
-	var unit_amount float64
-	var asset_name string
-	var new_balance float64
-
-	err := row.Scan(&l.Time, &l.Login, &l.ActionPath, &unit_price, &payed_price, &unit_amount, &asset_name, &new_balance)
-	if err != nil {
-		log.Fatalf("scan transaction log: %v", err)
+func packLogTransaction(e storage.TransactionLogEntry) logEntry {
+	l := logEntry{
+		Time:       e.Time,
+		Login:      e.Login,
+		ActionPath: e.Action,
+		RequestID:  e.RequestID,
 	}
 
 	sign := "+"
-	if l.ActionPath == "buy" {
+	if strings.HasSuffix(l.ActionPath, "buy") || l.ActionPath == "reserve" {
 		sign = "-"
 	}
 
-	l.AssetInfo = fmt.Sprintf("%v %v*cr%.3f %v%.3f -> %.3f", asset_name, unit_amount, unit_price, sign, payed_price, new_balance)
+	l.AssetInfo = fmt.Sprintf("%v %v*cr%v %v%v -> %v", e.Asset, e.Amount, e.PricePerUnit.StringFixed(3), sign,
+		e.PricePayed.StringFixed(3), e.Balance.StringFixed(3))
 
 	return l
 }
@@ -116,32 +105,18 @@ func (l logEntry) String() string {
 	} else {
 		sb.WriteString(fmt.Sprintf("?|TR.ACT|%v", l.AssetInfo))
 	}
+	if l.RequestID != "" {
+		sb.WriteString("|" + l.RequestID)
+	}
 
 	return sb.String()
 }
 
-func ResetLog() {
-	db := storage.GetDatabase()
-
-	q1 := `DELETE FROM access_log`
-	res, err := db.Exec(q1)
-	if err != nil {
-		log.Fatalf("failed clearing access log: %v", err)
-	}
-	n, err := res.RowsAffected()
-	if err != nil {
-		log.Fatalf("clearing access log row count failed: %v", err)
-	}
-	log.Printf("Cleared %v rows from access log.\n", n)
-
-	q2 := `DELETE FROM transaction_log`
-	res, err = db.Exec(q2)
-	if err != nil {
-		log.Fatalf("failed clearing transaction log: %v", err)
-	}
-	n, err = res.RowsAffected()
+func ResetLog(db storage.Storage) {
+	accessRows, transactionRows, err := db.ClearLogs()
 	if err != nil {
-		log.Fatalf("clearing transaction log row count failed: %v", err)
+		log.Fatalf("%v", err)
 	}
-	log.Printf("Cleared %v rows from transaction log.\n", n)
+	log.Printf("Cleared %v rows from access log.\n", accessRows)
+	log.Printf("Cleared %v rows from transaction log.\n", transactionRows)
 }
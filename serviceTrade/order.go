@@ -0,0 +1,417 @@
+package serviceTrade
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sync"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/logging"
+	"tradingServer/serviceMarket"
+	"tradingServer/storage"
+)
+
+var ErrOrderNotFound = errors.New("order not found")
+var ErrOrderNotOpen = errors.New("order is not open")
+
+// OrderBook keeps the open orders of all users and executes them as soon as the market price crosses their price.
+// The database is authoritative, the in-memory book only selects the orders to check on a price update.
+type OrderBook struct {
+	sync.Mutex
+	open map[string][]*entity.Order // open orders by asset name, oldest first
+
+	pendingMu sync.Mutex
+	pending   map[string]entity.MarketAsset // latest price update per asset not yet matched
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+
+	db      storage.Storage
+	candles *serviceMarket.CandleAggregator
+}
+
+// NewOrderBook creates an order book and restores the open orders from the database. The volume of executed orders is
+// added to the candles.
+func NewOrderBook(db storage.Storage, candles *serviceMarket.CandleAggregator) (*OrderBook, error) {
+	ob := &OrderBook{
+		db:      db,
+		candles: candles,
+		open:    make(map[string][]*entity.Order),
+		pending: make(map[string]entity.MarketAsset),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if err := ob.Reload(); err != nil {
+		return nil, err
+	}
+	return ob, nil
+}
+
+// Reload replaces the book by the open orders in the database, which includes the orders placed through other server
+// instances sharing it
+func (ob *OrderBook) Reload() error {
+	orders, err := ob.db.GetOpenOrders()
+	if err != nil {
+		return err
+	}
+
+	open := make(map[string][]*entity.Order)
+	for _, o := range orders {
+		open[o.Asset] = append(open[o.Asset], o)
+	}
+
+	ob.Lock()
+	ob.open = open
+	ob.Unlock()
+	return nil
+}
+
+// PlaceOrder reserves the order's funds or assets from the account and adds the order to the book
+// once the database transaction has been committed
+func (ob *OrderBook) PlaceOrder(db storage.Storage, acc *entity.Account, o *entity.Order) error {
+	switch o.Type {
+	case "":
+		o.Type = entity.OrderTypeLimit
+	case entity.OrderTypeLimit, entity.OrderTypeStop, entity.OrderTypeTakeProfit:
+	default:
+		return fmt.Errorf("invalid order type '%v'", o.Type)
+	}
+
+	if o.Side != entity.OrderSideBuy && o.Side != entity.OrderSideSell {
+		return fmt.Errorf("invalid order side '%v'", o.Side)
+	}
+	if !o.Amount.IsPositive() || !o.Price.IsPositive() {
+		return errors.New("order amount and price must be positive")
+	}
+
+	if o.Type != entity.OrderTypeLimit {
+		// nothing to reserve
+	} else if o.Side == entity.OrderSideBuy {
+		if acc.Balance.LessThan(o.Reserved()) {
+			return fmt.Errorf("account has %w for the requested order", ErrInsufficientFunds)
+		}
+		acc.Balance = acc.Balance.Sub(o.Reserved())
+	} else {
+		asset := acc.GetOrCreateUserAsset(o.Asset)
+		if asset.Amount.LessThan(o.Reserved()) {
+			return fmt.Errorf("user %v has %w %v for the requested order", acc.Login, ErrInsufficientAssets, o.Asset)
+		}
+		asset.Amount = asset.Amount.Sub(o.Reserved())
+	}
+
+	now := time.Now()
+	o.Login = acc.Login
+	o.Status = entity.OrderStatusOpen
+	o.Created = now
+	o.Updated = now
+
+	if o.Type == entity.OrderTypeLimit {
+		if err := db.SaveAccount(*acc); err != nil {
+			return err
+		}
+	}
+
+	if err := db.CreateOrder(o); err != nil {
+		return err
+	}
+
+	if o.Type == entity.OrderTypeLimit {
+		if err := logOrder(db, acc, o, "reserve", o.Price, reservedMoney(o)); err != nil {
+			return err
+		}
+	}
+
+	db.AfterCommit(func() {
+		ob.add(o)
+	})
+	return nil
+}
+
+// CancelOrder cancels an open order of the given user and releases its reservation.
+// The order is removed from the book once the database transaction has been committed.
+func (ob *OrderBook) CancelOrder(db storage.Storage, login string, id int64) (*entity.Order, error) {
+	o, err := db.GetOrder(id)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil || o.Login != login {
+		return nil, ErrOrderNotFound
+	}
+	if o.Status != entity.OrderStatusOpen {
+		return o, ErrOrderNotOpen
+	}
+
+	o.Status = entity.OrderStatusCancelled
+	o.Updated = time.Now()
+	if err = db.UpdateOrder(*o); err != nil {
+		return nil, err
+	}
+
+	db.AfterCommit(func() {
+		ob.remove(o)
+	})
+
+	if o.Type != entity.OrderTypeLimit {
+		return o, nil
+	}
+
+	acc, err := db.GetAccount(login)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Side == entity.OrderSideBuy {
+		acc.Balance = acc.Balance.Add(o.Reserved())
+	} else {
+		asset := acc.GetOrCreateUserAsset(o.Asset)
+		asset.Amount = asset.Amount.Add(o.Reserved())
+	}
+
+	if err = db.SaveAccount(*acc); err != nil {
+		return nil, err
+	}
+
+	return o, logOrder(db, acc, o, "release", o.Price, reservedMoney(o))
+}
+
+// ForgetUserOrders removes the open orders of a user about to be deleted from the book once the database
+// transaction has been committed
+func (ob *OrderBook) ForgetUserOrders(db storage.Storage, login string) error {
+	orders, err := db.GetOrders(login, true)
+	if err != nil {
+		return err
+	}
+
+	db.AfterCommit(func() {
+		for _, o := range orders {
+			ob.remove(o)
+		}
+	})
+	return nil
+}
+
+// OnPrice queues a price update for matching without blocking the caller. Only the latest update per asset is kept.
+func (ob *OrderBook) OnPrice(ev entity.MarketAsset) {
+	ob.pendingMu.Lock()
+	ob.pending[ev.Name] = ev
+	ob.pendingMu.Unlock()
+
+	select {
+	case ob.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run matches the queued price updates against the open orders until Stop is called
+func (ob *OrderBook) Run() {
+	defer close(ob.done)
+
+	for {
+		select {
+		case <-ob.wake:
+			ob.matchPending()
+		case <-ob.stop:
+			ob.matchPending()
+			return
+		}
+	}
+}
+
+// Stop ends Run once the queued price updates have been matched and the triggered orders have been executed.
+// Price updates queued afterwards are ignored.
+func (ob *OrderBook) Stop() {
+	close(ob.stop)
+	<-ob.done
+}
+
+func (ob *OrderBook) matchPending() {
+	ob.pendingMu.Lock()
+	updates := ob.pending
+	ob.pending = make(map[string]entity.MarketAsset)
+	ob.pendingMu.Unlock()
+
+	for _, ev := range updates {
+		ob.match(ev)
+	}
+}
+
+func (ob *OrderBook) match(ev entity.MarketAsset) {
+	ob.Lock()
+	var triggered []*entity.Order
+	for _, o := range ob.open[ev.Name] {
+		if o.Triggers(ev.Price) {
+			triggered = append(triggered, o)
+		}
+	}
+	ob.Unlock()
+
+	for _, o := range triggered {
+		if err := ob.executeInTransaction(o, ev.Price); err != nil {
+			logging.Errorf("%v order %v of user %v failed: %v", o.Type, o.ID, o.Login, err)
+		}
+	}
+}
+
+// executeInTransaction fills a triggered order in its own database transaction and removes it from the book
+func (ob *OrderBook) executeInTransaction(o *entity.Order, price decimal.Decimal) error {
+	tx, err := ob.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// lock the account before the order, in the same order as requests of the user do, to avoid deadlocks
+	if _, err = tx.GetAccount(o.Login); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// the order might have been cancelled or changed meanwhile
+	current, err := tx.GetOrder(o.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if current == nil || current.Status != entity.OrderStatusOpen {
+		tx.Rollback()
+		ob.remove(o)
+		return nil
+	}
+
+	if current.Type == entity.OrderTypeLimit {
+		err = fill(tx, current, price)
+	} else {
+		err = execute(tx, current, price)
+	}
+
+	if err != nil {
+		tx.Rollback()
+
+		if current.Type == entity.OrderTypeLimit {
+			return err
+		}
+
+		// the market order could not be executed, the trigger order is rejected
+		logging.Warnf("%v order %v of user %v rejected: %v", current.Type, current.ID, current.Login, err)
+		current.Status = entity.OrderStatusRejected
+		current.Updated = time.Now()
+		if err = ob.db.UpdateOrder(*current); err != nil {
+			return err
+		}
+		ob.remove(o)
+		return nil
+	}
+
+	tx.AfterCommit(func() {
+		ob.candles.AddTrade(current.Asset, current.Amount, current.Updated)
+	})
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	ob.remove(o)
+	return nil
+}
+
+// fill executes a limit order at the given price. The order's reservation has been taken from the account already.
+func fill(db storage.Storage, o *entity.Order, price decimal.Decimal) error {
+	acc, err := db.GetAccount(o.Login)
+	if err != nil {
+		return err
+	}
+
+	if o.Side == entity.OrderSideBuy {
+		asset := acc.GetOrCreateUserAsset(o.Asset)
+		asset.Amount = asset.Amount.Add(o.Amount)
+		// return the difference between the reserved limit and the actual price
+		acc.Balance = acc.Balance.Add(o.Price.Sub(price).Mul(o.Amount))
+	} else {
+		acc.Balance = acc.Balance.Add(price.Mul(o.Amount))
+	}
+
+	if err = db.SaveAccount(*acc); err != nil {
+		return err
+	}
+
+	o.Status = entity.OrderStatusFilled
+	o.FillPrice = price
+	o.Updated = time.Now()
+	if err = db.UpdateOrder(*o); err != nil {
+		return err
+	}
+
+	recordTrade(db, o.Side, o.Asset, price, o.Amount)
+
+	return logOrder(db, acc, o, "limit_"+o.Side, price, price.Mul(o.Amount))
+}
+
+// execute turns a triggered stop or take profit order into a market trade at the given price
+func execute(db storage.Storage, o *entity.Order, price decimal.Decimal) error {
+	acc, err := db.GetAccount(o.Login)
+	if err != nil {
+		return err
+	}
+
+	if o.Side == entity.OrderSideBuy {
+		err = buyAssetAt(db, acc, o.Asset, o.Amount, price, o.Type)
+	} else {
+		err = sellAssetAt(db, acc, o.Asset, o.Amount, price, o.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	o.Status = entity.OrderStatusFilled
+	o.FillPrice = price
+	o.Updated = time.Now()
+	return db.UpdateOrder(*o)
+}
+
+func (ob *OrderBook) add(o *entity.Order) {
+	ob.Lock()
+	defer ob.Unlock()
+
+	// a reload may have picked up the order already
+	for _, o2 := range ob.open[o.Asset] {
+		if o2.ID == o.ID {
+			return
+		}
+	}
+	ob.open[o.Asset] = append(ob.open[o.Asset], o)
+}
+
+func (ob *OrderBook) remove(o *entity.Order) {
+	ob.Lock()
+	defer ob.Unlock()
+
+	orders := ob.open[o.Asset]
+	for i, o2 := range orders {
+		if o2.ID == o.ID {
+			ob.open[o.Asset] = append(orders[:i], orders[i+1:]...)
+			return
+		}
+	}
+}
+
+// reservedMoney returns the part of the account's balance held back by the order
+func reservedMoney(o *entity.Order) decimal.Decimal {
+	if o.Side == entity.OrderSideBuy {
+		return o.Reserved()
+	}
+	return decimal.Zero
+}
+
+func logOrder(db storage.Storage, acc *entity.Account, o *entity.Order, action string, unitPrice decimal.Decimal, payedPrice decimal.Decimal) error {
+	return db.LogTransaction(storage.TransactionLogEntry{
+		Time:         time.Now().Format(time.RFC3339),
+		Login:        acc.Login,
+		Action:       action,
+		PricePerUnit: unitPrice,
+		PricePayed:   payedPrice,
+		Amount:       o.Amount,
+		Asset:        o.Asset,
+		Balance:      acc.Balance,
+	})
+}
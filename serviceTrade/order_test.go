@@ -0,0 +1,258 @@
+package serviceTrade
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/serviceMarket"
+	"tradingServer/storage"
+)
+
+// newTestBook returns an order book on a memory storage whose user "test" has a balance of 100 and 10 toothpaste
+func newTestBook(t *testing.T) (*OrderBook, *storage.Memory) {
+	t.Helper()
+
+	db := storage.NewMemory()
+	acc, err := db.GetAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc.GetOrCreateUserAsset("toothpaste").Amount = decimal.NewFromInt(10)
+	if err = db.SaveAccount(*acc); err != nil {
+		t.Fatal(err)
+	}
+
+	ob, err := NewOrderBook(db, serviceMarket.NewCandleAggregator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ob, db
+}
+
+func placeTestOrder(t *testing.T, ob *OrderBook, db storage.Storage, o *entity.Order) error {
+	t.Helper()
+
+	acc, err := db.GetAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ob.PlaceOrder(db, acc, o)
+}
+
+// checkAccount compares the balance and toothpaste of the test user
+func checkAccount(t *testing.T, db storage.Storage, balance, toothpaste string) {
+	t.Helper()
+
+	acc, err := db.GetAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.Balance.Equal(decimal.RequireFromString(balance)) {
+		t.Errorf("balance = %v, want %v", acc.Balance, balance)
+	}
+	if got := acc.GetOrCreateUserAsset("toothpaste").Amount; !got.Equal(decimal.RequireFromString(toothpaste)) {
+		t.Errorf("toothpaste = %v, want %v", got, toothpaste)
+	}
+}
+
+func TestPlaceOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		order      entity.Order
+		wantErr    error
+		balance    string
+		toothpaste string
+	}{
+		{"limit buy reserves money", entity.Order{Side: entity.OrderSideBuy, Amount: decimal.NewFromInt(2), Price: decimal.NewFromInt(10)}, nil, "80", "10"},
+		{"limit sell reserves assets", entity.Order{Side: entity.OrderSideSell, Amount: decimal.NewFromInt(2), Price: decimal.NewFromInt(10)}, nil, "100", "8"},
+		{"stop buy reserves nothing", entity.Order{Type: entity.OrderTypeStop, Side: entity.OrderSideBuy, Amount: decimal.NewFromInt(20), Price: decimal.NewFromInt(10)}, nil, "100", "10"},
+		{"take profit sell reserves nothing", entity.Order{Type: entity.OrderTypeTakeProfit, Side: entity.OrderSideSell, Amount: decimal.NewFromInt(20), Price: decimal.NewFromInt(10)}, nil, "100", "10"},
+		{"limit buy without funds", entity.Order{Side: entity.OrderSideBuy, Amount: decimal.NewFromInt(11), Price: decimal.NewFromInt(10)}, ErrInsufficientFunds, "100", "10"},
+		{"limit sell without assets", entity.Order{Side: entity.OrderSideSell, Amount: decimal.NewFromInt(11), Price: decimal.NewFromInt(10)}, ErrInsufficientAssets, "100", "10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob, db := newTestBook(t)
+
+			o := tt.order
+			o.Asset = "toothpaste"
+			err := placeTestOrder(t, ob, db, &o)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("PlaceOrder() error = %v, want %v", err, tt.wantErr)
+			}
+			checkAccount(t, db, tt.balance, tt.toothpaste)
+
+			if inBook := len(ob.open["toothpaste"]) == 1; inBook != (err == nil) {
+				t.Errorf("order in book = %v, want %v", inBook, err == nil)
+			}
+		})
+	}
+}
+
+func TestMatchOrders(t *testing.T) {
+	tests := []struct {
+		name        string
+		typ, side   string
+		amount      int64
+		marketPrice string
+		wantStatus  string
+		balance     string
+		toothpaste  string
+	}{
+		// a limit buy is filled at the market price, the rest of the reservation is returned
+		{"limit buy below limit", entity.OrderTypeLimit, entity.OrderSideBuy, 2, "9", entity.OrderStatusFilled, "82", "12"},
+		{"limit buy above limit", entity.OrderTypeLimit, entity.OrderSideBuy, 2, "11", entity.OrderStatusOpen, "80", "10"},
+		{"limit sell above limit", entity.OrderTypeLimit, entity.OrderSideSell, 2, "11", entity.OrderStatusFilled, "122", "8"},
+		{"limit sell below limit", entity.OrderTypeLimit, entity.OrderSideSell, 2, "9", entity.OrderStatusOpen, "100", "8"},
+		{"stop buy above stop", entity.OrderTypeStop, entity.OrderSideBuy, 2, "11", entity.OrderStatusFilled, "78", "12"},
+		{"stop sell below stop", entity.OrderTypeStop, entity.OrderSideSell, 2, "9", entity.OrderStatusFilled, "118", "8"},
+		{"stop sell above stop", entity.OrderTypeStop, entity.OrderSideSell, 2, "11", entity.OrderStatusOpen, "100", "10"},
+		{"take profit sell above price", entity.OrderTypeTakeProfit, entity.OrderSideSell, 2, "11", entity.OrderStatusFilled, "122", "8"},
+		{"take profit buy below price", entity.OrderTypeTakeProfit, entity.OrderSideBuy, 2, "9", entity.OrderStatusFilled, "82", "12"},
+		// trigger orders reserve nothing, they are rejected if the account can not pay when they are triggered
+		{"stop buy without funds", entity.OrderTypeStop, entity.OrderSideBuy, 20, "11", entity.OrderStatusRejected, "100", "10"},
+		{"stop sell without assets", entity.OrderTypeStop, entity.OrderSideSell, 20, "9", entity.OrderStatusRejected, "100", "10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob, db := newTestBook(t)
+
+			o := entity.Order{Type: tt.typ, Side: tt.side, Asset: "toothpaste", Amount: decimal.NewFromInt(tt.amount), Price: decimal.NewFromInt(10)}
+			if err := placeTestOrder(t, ob, db, &o); err != nil {
+				t.Fatalf("PlaceOrder() error = %v", err)
+			}
+
+			ob.match(entity.MarketAsset{Name: "toothpaste", Price: decimal.RequireFromString(tt.marketPrice)})
+
+			got, err := db.GetOrder(o.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %v, want %v", got.Status, tt.wantStatus)
+			}
+			if tt.wantStatus == entity.OrderStatusFilled && !got.FillPrice.Equal(decimal.RequireFromString(tt.marketPrice)) {
+				t.Errorf("fill price = %v, want %v", got.FillPrice, tt.marketPrice)
+			}
+			checkAccount(t, db, tt.balance, tt.toothpaste)
+
+			if inBook := len(ob.open["toothpaste"]) == 1; inBook != (tt.wantStatus == entity.OrderStatusOpen) {
+				t.Errorf("order in book = %v, want %v", inBook, !inBook)
+			}
+		})
+	}
+}
+
+func TestMatchSkipsOtherAssets(t *testing.T) {
+	ob, db := newTestBook(t)
+
+	o := entity.Order{Side: entity.OrderSideBuy, Asset: "toothpaste", Amount: decimal.NewFromInt(2), Price: decimal.NewFromInt(10)}
+	if err := placeTestOrder(t, ob, db, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	ob.match(entity.MarketAsset{Name: "olive_oil", Price: decimal.NewFromInt(1)})
+
+	got, err := db.GetOrder(o.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != entity.OrderStatusOpen {
+		t.Errorf("status = %v, want %v", got.Status, entity.OrderStatusOpen)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	ob, db := newTestBook(t)
+
+	o := entity.Order{Side: entity.OrderSideBuy, Asset: "toothpaste", Amount: decimal.NewFromInt(2), Price: decimal.NewFromInt(10)}
+	if err := placeTestOrder(t, ob, db, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		login   string
+		wantErr error
+	}{
+		{"other user", "alice", ErrOrderNotFound},
+		{"owner", "test", nil},
+		{"cancelled already", "test", ErrOrderNotOpen},
+	}
+
+	for _, tt := range tests {
+		if _, err := ob.CancelOrder(db, tt.login, o.ID); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: CancelOrder() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// the reservation has been released and the order is not matched anymore
+	checkAccount(t, db, "100", "10")
+	if len(ob.open["toothpaste"]) != 0 {
+		t.Errorf("cancelled order is still in the book")
+	}
+}
+
+func TestReloadPicksUpOrdersOfOtherInstances(t *testing.T) {
+	ob, db := newTestBook(t)
+
+	// an order placed through another instance's book is only in the database
+	other, err := NewOrderBook(db, serviceMarket.NewCandleAggregator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := entity.Order{Side: entity.OrderSideBuy, Asset: "toothpaste", Amount: decimal.NewFromInt(2), Price: decimal.NewFromInt(10)}
+	if err = placeTestOrder(t, other, db, &o); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ob.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	ob.match(entity.MarketAsset{Name: "toothpaste", Price: decimal.NewFromInt(9)})
+
+	got, err := db.GetOrder(o.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != entity.OrderStatusFilled {
+		t.Errorf("status = %v, want %v", got.Status, entity.OrderStatusFilled)
+	}
+}
+
+func TestOrderBookStop(t *testing.T) {
+	db := storage.NewMemory()
+	ob, err := NewOrderBook(db, serviceMarket.NewCandleAggregator())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acc, err := db.GetAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &entity.Order{Side: entity.OrderSideBuy, Asset: "toothpaste", Amount: decimal.NewFromInt(1), Price: decimal.NewFromInt(8)}
+	if err = ob.PlaceOrder(db, acc, o); err != nil {
+		t.Fatal(err)
+	}
+
+	go ob.Run()
+
+	// a price update queued right before the shutdown still executes the orders it triggers
+	ob.OnPrice(entity.MarketAsset{Name: "toothpaste", Price: decimal.RequireFromString("7.5"), When: time.Now()})
+	ob.Stop()
+
+	if o, err = db.GetOrder(o.ID); err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != entity.OrderStatusFilled {
+		t.Errorf("order status after Stop() = %v, want %v", o.Status, entity.OrderStatusFilled)
+	}
+
+	// updates after the shutdown are ignored
+	ob.OnPrice(entity.MarketAsset{Name: "toothpaste", Price: decimal.NewFromInt(1), When: time.Now()})
+}
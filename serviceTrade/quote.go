@@ -0,0 +1,189 @@
+package serviceTrade
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strings"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+const quoteLifetime = 10 * time.Second
+
+var ErrQuoteInvalid = errors.New("quote is invalid")
+var ErrQuoteExpired = errors.New("quote has expired")
+var ErrQuoteUsed = errors.New("quote has been used already")
+var ErrQuoteMismatch = errors.New("quote does not match the transaction")
+
+// quotePayload is the signed content of a quote ID
+type quotePayload struct {
+	Login   string
+	Side    string
+	Asset   string
+	Amount  decimal.Decimal
+	Price   decimal.Decimal
+	Expires int64 // unix milliseconds
+	Nonce   string
+}
+
+// quoteKey signs quote IDs, no quotes are issued before it has been set by SetQuoteKey
+var quoteKey []byte
+
+// SetQuoteKey sets the secret signing quote IDs, server instances sharing a database need the same one. An empty key
+// is replaced by a random one, then quotes do not survive a restart and can not be redeemed at other server instances.
+func SetQuoteKey(key string) error {
+	if key != "" {
+		quoteKey = []byte(key)
+		return nil
+	}
+
+	buf, err := randomBytes(32)
+	if err != nil {
+		return err
+	}
+	quoteKey = buf
+	return nil
+}
+
+// NewQuote offers the current market price of an asset for one trade of the given user
+func NewQuote(db storage.Storage, login string, side string, assetName string, amount decimal.Decimal) (*entity.Quote, error) {
+	if side != entity.OrderSideBuy && side != entity.OrderSideSell {
+		return nil, fmt.Errorf("invalid quote side '%v'", side)
+	}
+	if !amount.IsPositive() {
+		return nil, errors.New("quote amount must be positive")
+	}
+
+	if quoteKey == nil {
+		return nil, errors.New("no quote key has been set")
+	}
+
+	price, err := db.GetAssetPrice(assetName)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(quoteLifetime)
+	payload := quotePayload{
+		Login:   login,
+		Side:    side,
+		Asset:   assetName,
+		Amount:  amount,
+		Price:   price,
+		Expires: expires.UnixMilli(),
+		Nonce:   base64.RawURLEncoding.EncodeToString(nonce),
+	}
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(buf)
+
+	return &entity.Quote{
+		ID:      encoded + "." + signQuote(encoded),
+		Side:    side,
+		Asset:   assetName,
+		Amount:  amount,
+		Price:   price,
+		Expires: time.UnixMilli(payload.Expires),
+	}, nil
+}
+
+// VerifyQuote checks that a quote ID was issued to the given user for a trade of the given side and has not expired.
+// An empty asset or zero amount are taken from the quote, otherwise they have to match it. Whether the quote has been
+// used already is only known when trading at it.
+func VerifyQuote(login string, id string, side string, assetName string, amount decimal.Decimal) (*entity.Quote, error) {
+	idx := strings.IndexByte(id, '.')
+	if quoteKey == nil || idx < 0 || !hmac.Equal([]byte(signQuote(id[:idx])), []byte(id[idx+1:])) {
+		return nil, ErrQuoteInvalid
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(id[:idx])
+	if err != nil {
+		return nil, ErrQuoteInvalid
+	}
+
+	var payload quotePayload
+	if err = json.Unmarshal(buf, &payload); err != nil {
+		return nil, ErrQuoteInvalid
+	}
+
+	if payload.Login != login || payload.Side != side ||
+		(assetName != "" && assetName != payload.Asset) ||
+		(!amount.IsZero() && !amount.Equal(payload.Amount)) {
+		return nil, ErrQuoteMismatch
+	}
+
+	expires := time.UnixMilli(payload.Expires)
+	if time.Now().After(expires) {
+		return nil, ErrQuoteExpired
+	}
+
+	return &entity.Quote{
+		ID:      id,
+		Side:    payload.Side,
+		Asset:   payload.Asset,
+		Amount:  payload.Amount,
+		Price:   payload.Price,
+		Expires: expires,
+	}, nil
+}
+
+// BuyAssetAtQuote buys the quoted amount of an asset at the quoted price and marks the quote as used. The mark is
+// part of the transaction, so a quote stays usable if the trade fails and is rolled back.
+func BuyAssetAtQuote(db storage.Storage, acc *entity.Account, q *entity.Quote) error {
+	if err := buyAssetAt(db, acc, q.Asset, q.Amount, q.Price, "buy"); err != nil {
+		return err
+	}
+	return useQuote(db, q)
+}
+
+// SellAssetAtQuote sells the quoted amount of an asset at the quoted price and marks the quote as used, see
+// BuyAssetAtQuote
+func SellAssetAtQuote(db storage.Storage, acc *entity.Account, q *entity.Quote) error {
+	if err := sellAssetAt(db, acc, q.Asset, q.Amount, q.Price, "sell"); err != nil {
+		return err
+	}
+	return useQuote(db, q)
+}
+
+// useQuote records the redemption of a quote, the signature identifies it
+func useQuote(db storage.Storage, q *entity.Quote) error {
+	signature := q.ID[strings.IndexByte(q.ID, '.')+1:]
+	ok, err := db.UseQuote(signature, q.Expires, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrQuoteUsed
+	}
+	return nil
+}
+
+func signQuote(encodedPayload string) string {
+	mac := hmac.New(sha256.New, quoteKey)
+	mac.Write([]byte(encodedPayload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomBytes(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("reading random bytes failed: %v", err)
+	}
+	return buf, nil
+}
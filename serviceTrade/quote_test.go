@@ -0,0 +1,166 @@
+package serviceTrade
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+// testQuoteID signs a quote payload the way NewQuote does
+func testQuoteID(t *testing.T, payload quotePayload) string {
+	t.Helper()
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(buf)
+	return encoded + "." + signQuote(encoded)
+}
+
+// testRandomBytes returns n random bytes or fails the test
+func testRandomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+
+	buf, err := randomBytes(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// setTestQuoteKey sets a random quote key until the test ends
+func setTestQuoteKey(t *testing.T) {
+	t.Helper()
+
+	key := quoteKey
+	t.Cleanup(func() { quoteKey = key })
+	if err := SetQuoteKey(""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testQuotePayload(t *testing.T, expires time.Time) quotePayload {
+	return quotePayload{
+		Login:   "test",
+		Side:    entity.OrderSideBuy,
+		Asset:   "toothpaste",
+		Amount:  decimal.NewFromInt(2),
+		Price:   decimal.RequireFromString("8.5"),
+		Expires: expires.UnixMilli(),
+		Nonce:   base64.RawURLEncoding.EncodeToString(testRandomBytes(t, 8)),
+	}
+}
+
+func TestVerifyQuote(t *testing.T) {
+	setTestQuoteKey(t)
+	valid := testQuoteID(t, testQuotePayload(t, time.Now().Add(quoteLifetime)))
+	expired := testQuoteID(t, testQuotePayload(t, time.Now().Add(-time.Second)))
+
+	// a quote signed with another key
+	key := quoteKey
+	quoteKey = testRandomBytes(t, 32)
+	foreign := testQuoteID(t, testQuotePayload(t, time.Now().Add(quoteLifetime)))
+	quoteKey = key
+
+	tests := []struct {
+		name    string
+		id      string
+		login   string
+		side    string
+		asset   string
+		amount  decimal.Decimal
+		wantErr error
+	}{
+		{"malformed", "abc", "test", entity.OrderSideBuy, "", decimal.Zero, ErrQuoteInvalid},
+		{"tampered signature", valid + "x", "test", entity.OrderSideBuy, "", decimal.Zero, ErrQuoteInvalid},
+		{"other key", foreign, "test", entity.OrderSideBuy, "", decimal.Zero, ErrQuoteInvalid},
+		{"other user", valid, "alice", entity.OrderSideBuy, "", decimal.Zero, ErrQuoteMismatch},
+		{"other side", valid, "test", entity.OrderSideSell, "", decimal.Zero, ErrQuoteMismatch},
+		{"other asset", valid, "test", entity.OrderSideBuy, "olive_oil", decimal.Zero, ErrQuoteMismatch},
+		{"other amount", valid, "test", entity.OrderSideBuy, "", decimal.NewFromInt(3), ErrQuoteMismatch},
+		{"expired", expired, "test", entity.OrderSideBuy, "", decimal.Zero, ErrQuoteExpired},
+		{"valid", valid, "test", entity.OrderSideBuy, "toothpaste", decimal.NewFromInt(2), nil},
+		// it is used once traded at
+		{"verified again", valid, "test", entity.OrderSideBuy, "", decimal.Zero, nil},
+	}
+
+	for _, tt := range tests {
+		q, err := VerifyQuote(tt.login, tt.id, tt.side, tt.asset, tt.amount)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: VerifyQuote() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (q.Asset != "toothpaste" || !q.Amount.Equal(decimal.NewFromInt(2)) || !q.Price.Equal(decimal.RequireFromString("8.5"))) {
+			t.Errorf("%v: VerifyQuote() = %+v, want the quoted trade", tt.name, q)
+		}
+	}
+}
+
+func TestSetQuoteKey(t *testing.T) {
+	setTestQuoteKey(t)
+	const shared = "a secret shared by all instances of the server"
+
+	// instances sharing a database verify each other's quotes with the same key
+	if err := SetQuoteKey(shared); err != nil {
+		t.Fatal(err)
+	}
+	id := testQuoteID(t, testQuotePayload(t, time.Now().Add(quoteLifetime)))
+
+	if err := SetQuoteKey(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyQuote("test", id, entity.OrderSideBuy, "", decimal.Zero); !errors.Is(err, ErrQuoteInvalid) {
+		t.Errorf("VerifyQuote() with a generated key error = %v, want %v", err, ErrQuoteInvalid)
+	}
+
+	if err := SetQuoteKey(shared); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyQuote("test", id, entity.OrderSideBuy, "", decimal.Zero); err != nil {
+		t.Errorf("VerifyQuote() with the same key error = %v", err)
+	}
+}
+
+func TestBuyAssetAtQuote(t *testing.T) {
+	setTestQuoteKey(t)
+	db := storage.NewMemory()
+	q, err := NewQuote(db, "test", entity.OrderSideBuy, "toothpaste", decimal.NewFromInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err := db.GetAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a quote stays usable if its trade is rolled back
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = BuyAssetAtQuote(tx, acc, q); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if acc, err = db.GetAccount("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err = BuyAssetAtQuote(db, acc, q); err != nil {
+		t.Fatalf("BuyAssetAtQuote() after rollback error = %v", err)
+	}
+	if acc, err = db.GetAccount("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err = BuyAssetAtQuote(db, acc, q); !errors.Is(err, ErrQuoteUsed) {
+		t.Errorf("replayed BuyAssetAtQuote() error = %v, want %v", err, ErrQuoteUsed)
+	}
+}
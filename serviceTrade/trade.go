@@ -6,19 +6,26 @@ import (
 	"github.com/shopspring/decimal"
 	"time"
 	"tradingServer/entity"
+	"tradingServer/metrics"
 	"tradingServer/storage"
 )
 
-func BuyAsset(acc *entity.Account, assetName string, amount decimal.Decimal) error {
-	db := storage.GetDatabase()
+var ErrInsufficientFunds = errors.New("not enough money")
+var ErrInsufficientAssets = errors.New("not enough of the asset")
 
+func BuyAsset(db storage.Storage, acc *entity.Account, assetName string, amount decimal.Decimal) error {
 	assetPrice, err := db.GetAssetPrice(assetName)
 	if err != nil {
 		return err
 	}
 
+	return buyAssetAt(db, acc, assetName, amount, assetPrice, "buy")
+}
+
+// buyAssetAt buys the asset at the given unit price and logs the transaction with the given action
+func buyAssetAt(db storage.Storage, acc *entity.Account, assetName string, amount decimal.Decimal, assetPrice decimal.Decimal, action string) error {
 	if acc.Balance.LessThan(assetPrice.Mul(amount)) {
-		return errors.New("account has not enough money for the requested amount")
+		return fmt.Errorf("account has %w for the requested amount", ErrInsufficientFunds)
 	}
 
 	asset := acc.GetOrCreateUserAsset(assetName)
@@ -27,30 +34,34 @@ func BuyAsset(acc *entity.Account, assetName string, amount decimal.Decimal) err
 	payedPrice := amount.Mul(assetPrice)
 	acc.Balance = acc.Balance.Sub(payedPrice)
 
-	err = db.SaveAccount(*acc)
+	err := db.SaveAccount(*acc)
 	if err != nil {
 		return err
 	}
 
-	return db.LogTransaction(storage.TransactionLogEntry{
+	err = db.LogTransaction(storage.TransactionLogEntry{
 		Time:         time.Now().Format(time.RFC3339),
 		Login:        acc.Login,
-		Action:       "buy",
-		PricePerUnit: assetPrice.InexactFloat64(),
-		PricePayed:   payedPrice.InexactFloat64(),
-		Amount:       asset.Amount.InexactFloat64(),
+		Action:       action,
+		PricePerUnit: assetPrice,
+		PricePayed:   payedPrice,
+		Amount:       asset.Amount,
 		Asset:        assetName,
-		Balance:      acc.Balance.InexactFloat64(),
+		Balance:      acc.Balance,
 	})
-}
+	if err != nil {
+		return err
+	}
 
-func SellAsset(acc *entity.Account, assetName string, amount decimal.Decimal) error {
-	db := storage.GetDatabase()
+	recordTrade(db, entity.OrderSideBuy, assetName, assetPrice, amount)
+	return nil
+}
 
+func SellAsset(db storage.Storage, acc *entity.Account, assetName string, amount decimal.Decimal) error {
 	asset := acc.GetOrCreateUserAsset(assetName)
 
 	if asset.Amount.LessThan(amount) {
-		return fmt.Errorf("user %v has not enough of %v for the requested amount", acc.Login, assetName)
+		return fmt.Errorf("user %v has %w %v for the requested amount", acc.Login, ErrInsufficientAssets, assetName)
 	}
 
 	assetPrice, err := db.GetAssetPrice(assetName)
@@ -58,23 +69,47 @@ func SellAsset(acc *entity.Account, assetName string, amount decimal.Decimal) er
 		return err
 	}
 
+	return sellAssetAt(db, acc, assetName, amount, assetPrice, "sell")
+}
+
+// sellAssetAt sells the asset at the given unit price and logs the transaction with the given action
+func sellAssetAt(db storage.Storage, acc *entity.Account, assetName string, amount decimal.Decimal, assetPrice decimal.Decimal, action string) error {
+	asset := acc.GetOrCreateUserAsset(assetName)
+
+	if asset.Amount.LessThan(amount) {
+		return fmt.Errorf("user %v has %w %v for the requested amount", acc.Login, ErrInsufficientAssets, assetName)
+	}
+
 	asset.Amount = asset.Amount.Sub(amount)
 	payedPrice := amount.Mul(assetPrice)
 	acc.Balance = acc.Balance.Add(payedPrice)
 
-	err = db.SaveAccount(*acc)
+	err := db.SaveAccount(*acc)
 	if err != nil {
 		return err
 	}
 
-	return db.LogTransaction(storage.TransactionLogEntry{
+	err = db.LogTransaction(storage.TransactionLogEntry{
 		Time:         time.Now().Format(time.RFC3339),
 		Login:        acc.Login,
-		Action:       "sell",
-		PricePerUnit: assetPrice.InexactFloat64(),
-		PricePayed:   payedPrice.InexactFloat64(),
-		Amount:       asset.Amount.InexactFloat64(),
+		Action:       action,
+		PricePerUnit: assetPrice,
+		PricePayed:   payedPrice,
+		Amount:       asset.Amount,
 		Asset:        assetName,
-		Balance:      acc.Balance.InexactFloat64(),
+		Balance:      acc.Balance,
+	})
+	if err != nil {
+		return err
+	}
+
+	recordTrade(db, entity.OrderSideSell, assetName, assetPrice, amount)
+	return nil
+}
+
+// recordTrade adds a trade to the metrics once the transaction has been committed
+func recordTrade(db storage.Storage, side string, assetName string, price decimal.Decimal, amount decimal.Decimal) {
+	db.AfterCommit(func() {
+		metrics.RecordTrade(assetName, side, price.Mul(amount))
 	})
 }
@@ -0,0 +1,129 @@
+package serviceUser
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+// apiKeyPrefix starts every API key, so keys are easy to recognize, e.g. by secret scanners
+const apiKeyPrefix = "tsk_"
+
+var ErrAPIKeyInvalid = errors.New("api key is invalid")
+var ErrAPIKeyExpired = errors.New("api key has expired")
+var ErrAPIKeyAddressNotAllowed = errors.New("api key is not allowed from this address")
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// CreateAPIKey creates a named API key for a user. The returned key holds the secret key, which can not be retrieved
+// later. The key never expires if expires is zero.
+func CreateAPIKey(db storage.Storage, login, name string, scopes []string, allowedIPs []string, expires time.Time) (*entity.APIKey, error) {
+	if name == "" || len(name) > 64 {
+		return nil, errors.New("api key name must have 1 to 64 characters")
+	}
+
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required: %v", strings.Join(entity.Scopes, ", "))
+	}
+	for _, scope := range scopes {
+		if !isScope(scope) {
+			return nil, fmt.Errorf("unknown scope '%v', valid scopes are %v", scope, strings.Join(entity.Scopes, ", "))
+		}
+	}
+
+	for _, ip := range allowedIPs {
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return nil, fmt.Errorf("'%v' is neither an IP address nor a CIDR range", ip)
+			}
+		}
+	}
+
+	now := time.Now()
+	if !expires.IsZero() && !expires.After(now) {
+		return nil, errors.New("api key expiry must be in the future")
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	secret := apiKeyPrefix + token
+	k := &entity.APIKey{
+		Login:      login,
+		Name:       name,
+		Prefix:     secret[:len(apiKeyPrefix)+6],
+		Scopes:     scopes,
+		AllowedIPs: allowedIPs,
+		Created:    now.Truncate(time.Second),
+		Expires:    expires.Truncate(time.Second),
+		Hash:       HashToken(secret),
+	}
+	if k.AllowedIPs == nil {
+		k.AllowedIPs = []string{}
+	}
+
+	if err = db.CreateAPIKey(k); err != nil {
+		return nil, err
+	}
+
+	k.Key = secret
+	return k, nil
+}
+
+// GetAPIKey returns the API key a request authenticates with if it is valid for the client's address
+func GetAPIKey(db storage.Storage, key string, clientIP string) (*entity.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	k, err := db.GetAPIKeyByHash(HashToken(key))
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if k.Expired(time.Now()) {
+		return nil, ErrAPIKeyExpired
+	}
+	if !k.AllowsIP(clientIP) {
+		return nil, ErrAPIKeyAddressNotAllowed
+	}
+	return k, nil
+}
+
+// RevokeAPIKey deletes an API key of a user
+func RevokeAPIKey(db storage.Storage, login string, id int64) error {
+	found, err := db.DeleteAPIKey(login, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// ParseList splits a comma separated list of scopes or addresses
+func ParseList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func isScope(scope string) bool {
+	for _, s := range entity.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
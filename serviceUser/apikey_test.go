@@ -0,0 +1,117 @@
+package serviceUser
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	db := storage.NewMemory()
+	read := []string{entity.ScopeRead}
+
+	tests := []struct {
+		name       string
+		keyName    string
+		scopes     []string
+		allowedIPs []string
+		expires    time.Time
+		wantErr    bool
+	}{
+		{"read key", "reader", read, nil, time.Time{}, false},
+		{"all scopes, allowlist and expiry", "trader", entity.Scopes, []string{"10.0.0.1", "192.168.0.0/16"}, time.Now().Add(time.Hour), false},
+		{"no name", "", read, nil, time.Time{}, true},
+		{"no scope", "bot", nil, nil, time.Time{}, true},
+		{"unknown scope", "bot", []string{"admin"}, nil, time.Time{}, true},
+		{"invalid address", "bot", read, []string{"localhost"}, time.Time{}, true},
+		{"invalid range", "bot", read, []string{"10.0.0.0/33"}, time.Time{}, true},
+		{"expired", "bot", read, nil, time.Now().Add(-time.Second), true},
+		{"name taken", "reader", read, nil, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		k, err := CreateAPIKey(db, "test", tt.keyName, tt.scopes, tt.allowedIPs, tt.expires)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: CreateAPIKey() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (k.Key == "" || k.Hash != HashToken(k.Key) || k.Prefix != k.Key[:len(k.Prefix)]) {
+			t.Errorf("%v: CreateAPIKey() = %+v, want a key with its hash and prefix", tt.name, k)
+		}
+	}
+}
+
+func TestGetAPIKey(t *testing.T) {
+	db := storage.NewMemory()
+
+	create := func(name string, scopes []string, allowedIPs []string) *entity.APIKey {
+		t.Helper()
+		k, err := CreateAPIKey(db, "test", name, scopes, allowedIPs, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	open := create("open", []string{entity.ScopeRead}, nil).Key
+	restricted := create("restricted", []string{entity.ScopeRead, entity.ScopeTrade}, []string{"10.0.0.0/8"}).Key
+	revokedKey := create("revoked", []string{entity.ScopeRead}, nil)
+	revoked := revokedKey.Key
+
+	// keys can not be created with an expiry in the past
+	token, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := apiKeyPrefix + token
+	err = db.CreateAPIKey(&entity.APIKey{
+		Login:   "test",
+		Name:    "expired",
+		Prefix:  expired[:len(apiKeyPrefix)+6],
+		Scopes:  []string{entity.ScopeRead},
+		Created: time.Now().Add(-time.Hour),
+		Expires: time.Now().Add(-time.Minute),
+		Hash:    HashToken(expired),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = RevokeAPIKey(db, "alice", revokedKey.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("RevokeAPIKey() of other user's key error = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+	if err = RevokeAPIKey(db, "test", revokedKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = RevokeAPIKey(db, "test", revokedKey.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("second RevokeAPIKey() error = %v, want %v", err, ErrAPIKeyNotFound)
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		ip        string
+		wantErr   error
+		wantScope string
+	}{
+		{"key without allowlist", open, "203.0.113.7", nil, entity.ScopeRead},
+		{"key from allowed range", restricted, "10.1.2.3", nil, entity.ScopeTrade},
+		{"key from other address", restricted, "203.0.113.7", ErrAPIKeyAddressNotAllowed, ""},
+		{"revoked key", revoked, "203.0.113.7", ErrAPIKeyInvalid, ""},
+		{"expired key", expired, "203.0.113.7", ErrAPIKeyExpired, ""},
+		{"unknown key", apiKeyPrefix + "abc", "203.0.113.7", ErrAPIKeyInvalid, ""},
+		{"no api key", "abc", "203.0.113.7", ErrAPIKeyInvalid, ""},
+	}
+
+	for _, tt := range tests {
+		k, err := GetAPIKey(db, tt.key, tt.ip)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: GetAPIKey() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (k.Login != "test" || !k.HasScope(tt.wantScope)) {
+			t.Errorf("%v: GetAPIKey() = %+v, want a key of test with scope %v", tt.name, k, tt.wantScope)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package serviceUser
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/logging"
+	"tradingServer/serviceMail"
+	"tradingServer/storage"
+)
+
+var ErrInvalidConfirmationToken = errors.New("confirmation token is invalid or has expired")
+
+// ValidateEmail checks that a string is a plain email address like user@example.com
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return fmt.Errorf("invalid email address '%v'", email)
+	}
+	return nil
+}
+
+// RequestEmailChange mails a confirmation link to the new address of a user once the transaction has been committed.
+// The address is changed once the link's token is passed to ConfirmEmailChange before the ttl elapses. A previous
+// request of the user is replaced.
+func RequestEmailChange(db storage.Storage, mailer serviceMail.Mailer, login, email, publicURL string, ttl time.Duration) (*entity.EmailConfirmation, error) {
+	now := time.Now()
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	confirmation := entity.EmailConfirmation{
+		TokenHash: HashToken(token),
+		Login:     login,
+		Email:     email,
+		Created:   now.Truncate(time.Second),
+		Expires:   now.Add(ttl).Truncate(time.Second),
+	}
+
+	if err = db.CreateEmailConfirmation(confirmation); err != nil {
+		return nil, err
+	}
+
+	link := strings.TrimSuffix(publicURL, "/") + "/account/email/confirm?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Hello %v,\n\n"+
+		"please confirm the new email address of your trading account by opening\n\n%v\n\n"+
+		"The link expires at %v. If you did not ask for this change, ignore this mail.\n",
+		login, link, confirmation.Expires.Format(time.RFC1123))
+
+	// no link is mailed for a confirmation which is rolled back, and a slow mail server does not hold the transaction
+	db.AfterCommit(func() {
+		if err := mailer.Send(email, "Confirm your email address", body); err != nil {
+			logging.Errorf("mailing the email confirmation of login '%v' failed: %v", login, err)
+		}
+	})
+	return &confirmation, nil
+}
+
+// ConfirmEmailChange applies the email change of a confirmation token and returns it. The previous address of the
+// user, if any, is returned as well so the user can be told about the change.
+func ConfirmEmailChange(db storage.Storage, token string) (*entity.EmailConfirmation, string, error) {
+	confirmation, err := db.GetEmailConfirmation(HashToken(token))
+	if err != nil {
+		return nil, "", err
+	}
+	if confirmation == nil || !time.Now().Before(confirmation.Expires) {
+		return nil, "", ErrInvalidConfirmationToken
+	}
+
+	acc, err := db.GetAccount(confirmation.Login)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = db.UpdateAccount(confirmation.Login, "", confirmation.Email); err != nil {
+		return nil, "", err
+	}
+	if err = db.DeleteEmailConfirmation(confirmation.Login); err != nil {
+		return nil, "", err
+	}
+	return confirmation, acc.Email, nil
+}
+
+// NotifyEmailChanged tells a user at the previous address that the email address has been changed
+func NotifyEmailChanged(mailer serviceMail.Mailer, login, previous, email string) error {
+	body := fmt.Sprintf("Hello %v,\n\n"+
+		"the email address of your trading account has been changed to %v.\n"+
+		"If you did not make this change, contact the operator of the server immediately.\n",
+		login, email)
+	return mailer.Send(previous, "Your email address has been changed", body)
+}
@@ -0,0 +1,117 @@
+package serviceUser
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+	"tradingServer/storage"
+)
+
+// testMailer keeps the mails it is asked to send
+type testMailer struct {
+	to, bodies []string
+}
+
+func (m *testMailer) Send(to, subject, body string) error {
+	m.to = append(m.to, to)
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+var confirmationLink = regexp.MustCompile(`/account/email/confirm\?token=(\S+)`)
+
+// confirmationToken returns the token of the confirmation link in the last mail
+func (m *testMailer) confirmationToken(t *testing.T) string {
+	t.Helper()
+
+	if len(m.bodies) == 0 {
+		t.Fatal("no mail has been sent")
+	}
+	match := confirmationLink.FindStringSubmatch(m.bodies[len(m.bodies)-1])
+	if match == nil {
+		t.Fatalf("mail without confirmation link:\n%v", m.bodies[len(m.bodies)-1])
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	db := storage.NewMemory()
+	mailer := &testMailer{}
+
+	if _, err := RequestEmailChange(db, mailer, "test", "old@example.com", "http://localhost/", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	replaced := mailer.confirmationToken(t)
+	if _, err := RequestEmailChange(db, mailer, "test", "new@example.com", "http://localhost/", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	valid := mailer.confirmationToken(t)
+	if mailer.to[1] != "new@example.com" {
+		t.Errorf("confirmation mailed to %v, want the new address", mailer.to[1])
+	}
+
+	if _, _, err := ConfirmEmailChange(db, replaced); !errors.Is(err, ErrInvalidConfirmationToken) {
+		t.Errorf("ConfirmEmailChange() of a replaced request error = %v, want %v", err, ErrInvalidConfirmationToken)
+	}
+
+	confirmation, _, err := ConfirmEmailChange(db, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err := db.GetAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmation.Email != "new@example.com" || acc.Email != "new@example.com" {
+		t.Errorf("email after confirmation = %v, want new@example.com", acc.Email)
+	}
+
+	if _, _, err = ConfirmEmailChange(db, valid); !errors.Is(err, ErrInvalidConfirmationToken) {
+		t.Errorf("second ConfirmEmailChange() error = %v, want %v", err, ErrInvalidConfirmationToken)
+	}
+}
+
+func TestConfirmEmailChangeExpired(t *testing.T) {
+	db := storage.NewMemory()
+	mailer := &testMailer{}
+
+	if _, err := RequestEmailChange(db, mailer, "test", "new@example.com", "http://localhost", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ConfirmEmailChange(db, mailer.confirmationToken(t)); !errors.Is(err, ErrInvalidConfirmationToken) {
+		t.Errorf("ConfirmEmailChange() of an expired token error = %v, want %v", err, ErrInvalidConfirmationToken)
+	}
+
+	acc, err := db.GetAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acc.Email == "new@example.com" {
+		t.Error("expired confirmation changed the email address")
+	}
+}
+
+func TestCheckPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"correct horse battery", true},
+		{"short", false},
+		{"my-test-password", false},
+		{"Password123", false},
+		{"abababababab", false},
+	}
+
+	for _, tt := range tests {
+		if err := CheckPasswordStrength("test", tt.password, 10); (err == nil) != tt.valid {
+			t.Errorf("CheckPasswordStrength(%v) error = %v, want valid %v", tt.password, err, tt.valid)
+		}
+	}
+}
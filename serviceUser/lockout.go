@@ -0,0 +1,138 @@
+package serviceUser
+
+import (
+	"errors"
+	"time"
+	"tradingServer/config"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+var ErrNotLocked = errors.New("no failed login attempts recorded")
+
+// LoginKey returns the key the failed password attempts for a login are counted under
+func LoginKey(login string) string {
+	return "login:" + login
+}
+
+// AddressKey returns the key the failed password attempts from a client address are counted under
+func AddressKey(address string) string {
+	return "ip:" + address
+}
+
+// CheckLockout returns the end of the lockout of the login or the client address, the later one if both are locked,
+// zero if neither is. It also tells whether failed attempts for the login are on record, a successful login clears them.
+func CheckLockout(db storage.Storage, login, address string, now time.Time) (time.Time, bool, error) {
+	var lockedUntil time.Time
+	loginFailures := false
+
+	for _, key := range []string{LoginKey(login), AddressKey(address)} {
+		f, err := db.GetAuthFailure(key)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if f == nil {
+			continue
+		}
+
+		if key == LoginKey(login) {
+			loginFailures = true
+		}
+		if f.Locked(now) && f.LockedUntil.After(lockedUntil) {
+			lockedUntil = f.LockedUntil
+		}
+	}
+	return lockedUntil, loginFailures, nil
+}
+
+// RecordAuthFailure counts a failed password attempt for the login and the client address in a transaction of its own,
+// so it is not rolled back with the request. It returns the end of the lockout the attempt caused, zero if none.
+func RecordAuthFailure(db storage.Storage, cfg config.LockoutConfig, login, address string, now time.Time) (time.Time, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return time.Time{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.DeleteExpiredAuthFailures(now.Add(-cfg.ResetAfter)); err != nil {
+		return time.Time{}, err
+	}
+
+	var lockedUntil time.Time
+	thresholds := []struct {
+		key       string
+		threshold int
+	}{
+		{LoginKey(login), cfg.LoginThreshold},
+		{AddressKey(address), cfg.AddressThreshold},
+	}
+	for _, t := range thresholds {
+		f, err := tx.GetAuthFailure(t.key)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if f == nil {
+			f = &entity.AuthFailure{Key: t.key}
+		}
+
+		f.Failures++
+		f.LastFailure = now
+		if d := lockoutDuration(cfg, f.Failures, t.threshold); d > 0 {
+			f.LockedUntil = now.Add(d)
+			if f.LockedUntil.After(lockedUntil) {
+				lockedUntil = f.LockedUntil
+			}
+		}
+
+		if err = tx.SaveAuthFailure(*f); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return time.Time{}, err
+	}
+	committed = true
+	return lockedUntil, nil
+}
+
+// ClearLoginFailures forgets the failed password attempts for a login after it has been used successfully.
+// Failures from the client address are kept, a successful login must not allow guessing the passwords of others.
+func ClearLoginFailures(db storage.Storage, login string) error {
+	_, err := db.DeleteAuthFailure(LoginKey(login))
+	return err
+}
+
+// Unlock lifts the lockout of a key, see LoginKey and AddressKey, and forgets its failed password attempts
+func Unlock(db storage.Storage, key string) error {
+	found, err := db.DeleteAuthFailure(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotLocked
+	}
+	return nil
+}
+
+// lockoutDuration returns the lockout after the given number of failures: none below the threshold, then the
+// configured duration doubling with every further failure up to the maximum
+func lockoutDuration(cfg config.LockoutConfig, failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	d := cfg.Duration
+	for i := threshold; i < failures && d < cfg.MaxDuration; i++ {
+		d *= 2
+	}
+	if d > cfg.MaxDuration {
+		d = cfg.MaxDuration
+	}
+	return d
+}
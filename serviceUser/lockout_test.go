@@ -0,0 +1,142 @@
+package serviceUser
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"tradingServer/config"
+	"tradingServer/storage"
+)
+
+var testLockout = config.LockoutConfig{
+	LoginThreshold:   3,
+	AddressThreshold: 5,
+	Duration:         time.Minute,
+	MaxDuration:      10 * time.Minute,
+	ResetAfter:       time.Hour,
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := lockoutDuration(testLockout, tt.failures, 3); got != tt.want {
+			t.Errorf("lockoutDuration() after %v failures = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRecordAuthFailure(t *testing.T) {
+	db := storage.NewMemory()
+	start := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// the failures come a second apart, the login reaches its threshold before the address
+	tests := []struct {
+		name       string
+		login      string
+		wantLocked time.Duration
+	}{
+		{"first failure", "alice", 0},
+		{"second failure", "alice", 0},
+		{"login threshold", "alice", time.Minute},
+		{"login lockout doubled", "alice", 2 * time.Minute},
+		// the address reaches its threshold, the lockout of the login is longer
+		{"address threshold", "alice", 4 * time.Minute},
+		{"address lockout doubled for other login", "bob", 2 * time.Minute},
+	}
+
+	for i, tt := range tests {
+		now := start.Add(time.Duration(i) * time.Second)
+		lockedUntil, err := RecordAuthFailure(db, testLockout, tt.login, "10.0.0.1", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var want time.Time
+		if tt.wantLocked > 0 {
+			want = now.Add(tt.wantLocked)
+		}
+		if !lockedUntil.Equal(want) {
+			t.Errorf("%v: locked until %v, want %v", tt.name, lockedUntil, want)
+		}
+	}
+}
+
+func TestCheckLockout(t *testing.T) {
+	db := storage.NewMemory()
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < testLockout.LoginThreshold; i++ {
+		if _, err := RecordAuthFailure(db, testLockout, "alice", "10.0.0.1", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		login        string
+		address      string
+		at           time.Time
+		wantLocked   time.Time
+		wantFailures bool
+	}{
+		{"locked login", "alice", "10.0.0.2", now, now.Add(time.Minute), true},
+		{"lockout over", "alice", "10.0.0.2", now.Add(time.Minute), time.Time{}, true},
+		{"other login from the address", "bob", "10.0.0.1", now, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		lockedUntil, failures, err := CheckLockout(db, tt.login, tt.address, tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !lockedUntil.Equal(tt.wantLocked) || failures != tt.wantFailures {
+			t.Errorf("%v: CheckLockout() = %v, %v, want %v, %v", tt.name, lockedUntil, failures, tt.wantLocked, tt.wantFailures)
+		}
+	}
+
+	if err := ClearLoginFailures(db, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if lockedUntil, failures, _ := CheckLockout(db, "alice", "10.0.0.2", now); !lockedUntil.IsZero() || failures {
+		t.Errorf("login still locked after clearing its failures")
+	}
+	if err := Unlock(db, LoginKey("alice")); !errors.Is(err, ErrNotLocked) {
+		t.Errorf("Unlock() of cleared login error = %v, want %v", err, ErrNotLocked)
+	}
+	if err := Unlock(db, AddressKey("10.0.0.1")); err != nil {
+		t.Errorf("Unlock() of address error = %v", err)
+	}
+}
+
+func TestRecordAuthFailureForgetsOldFailures(t *testing.T) {
+	db := storage.NewMemory()
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < testLockout.LoginThreshold-1; i++ {
+		if _, err := RecordAuthFailure(db, testLockout, "alice", "10.0.0.1", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// after ResetAfter without failures the count starts over, the next failure does not lock the login
+	lockedUntil, err := RecordAuthFailure(db, testLockout, "alice", "10.0.0.1", now.Add(testLockout.ResetAfter+time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lockedUntil.IsZero() {
+		t.Errorf("locked until %v after the failures should have been forgotten", lockedUntil)
+	}
+}
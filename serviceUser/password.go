@@ -0,0 +1,53 @@
+package serviceUser
+
+import (
+	"fmt"
+	"strings"
+	"tradingServer/storage"
+	"unicode/utf8"
+)
+
+// commonPasswords are rejected regardless of their length
+var commonPasswords = map[string]bool{
+	"1234567890": true, "12345678910": true, "123456789012": true, "0987654321": true, "1q2w3e4r5t": true,
+	"qwertyuiop": true, "qwertyuiop123": true, "1qaz2wsx3edc": true, "asdfghjkl1": true, "password12": true,
+	"password123": true, "password1234": true, "passw0rd123": true, "iloveyou123": true, "letmein123": true,
+	"trustno1234": true, "welcome123": true, "sunshine123": true, "football123": true, "monkey12345": true,
+	"tradingserver": true, "tradingserver1": true, "tradingserver123": true,
+}
+
+// minDistinctCharacters rejects passwords like "aaaaaaaaaaaa" or "abababababab"
+const minDistinctCharacters = 5
+
+// CheckPasswordStrength tells why a password chosen by a user is too weak, nil if it is acceptable
+func CheckPasswordStrength(login, password string, minLength int) error {
+	if utf8.RuneCountInString(password) < minLength {
+		return fmt.Errorf("password must have at least %v characters", minLength)
+	}
+
+	lower := strings.ToLower(password)
+	if login != "" && strings.Contains(lower, strings.ToLower(login)) {
+		return fmt.Errorf("password must not contain the login")
+	}
+	if commonPasswords[lower] {
+		return fmt.Errorf("password is too common")
+	}
+
+	distinct := make(map[rune]bool)
+	for _, r := range password {
+		distinct[r] = true
+	}
+	if len(distinct) < minDistinctCharacters {
+		return fmt.Errorf("password must consist of at least %v different characters", minDistinctCharacters)
+	}
+	return nil
+}
+
+// ChangePassword replaces a user's password and logs out all other sessions of the user,
+// the session of the given access token hash is kept
+func ChangePassword(db storage.Storage, login, password, keepSessionHash string) error {
+	if err := db.UpdateAccount(login, password, ""); err != nil {
+		return err
+	}
+	return db.DeleteSessions(login, keepSessionHash)
+}
@@ -0,0 +1,116 @@
+package serviceUser
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"regexp"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+// Registration modes of the accounts.registration setting
+const (
+	RegistrationClosed = "closed"
+	RegistrationInvite = "invite"
+	RegistrationOpen   = "open"
+)
+
+const (
+	minLoginLength = 3
+	maxLoginLength = 32
+	// inviteCodeRandomBytes is encoded as 16 characters
+	inviteCodeRandomBytes = 10
+)
+
+var (
+	ErrLoginTaken         = errors.New("login is taken already")
+	ErrInviteCodeRequired = errors.New("an invite code is required")
+	ErrInviteCodeInvalid  = errors.New("invite code is invalid, used up or has expired")
+	ErrInviteCodeNotFound = errors.New("invite code not found")
+)
+
+var validLogin = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateLogin checks the login chosen by a new user: 3 to 32 letters, digits, '_', '.' or '-', starting with a
+// letter or digit
+func ValidateLogin(login string) error {
+	if len(login) < minLoginLength || len(login) > maxLoginLength {
+		return fmt.Errorf("login must have %v to %v characters", minLoginLength, maxLoginLength)
+	}
+	if !validLogin.MatchString(login) {
+		return fmt.Errorf("login may only contain letters, digits, '_', '.' and '-' and must start with a letter or digit")
+	}
+	return nil
+}
+
+// Register creates the account of a new user with the given starting balance. The invite code is counted as used,
+// it is required unless registration is open to everyone. Login and password must have been validated.
+func Register(db storage.Storage, mode, login, password, inviteCode string, balance decimal.Decimal) error {
+	if _, err := db.GetAccount(login); err == nil {
+		return ErrLoginTaken
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	if inviteCode == "" && mode != RegistrationOpen {
+		return ErrInviteCodeRequired
+	}
+	if inviteCode != "" {
+		ok, err := db.UseInviteCode(inviteCode, time.Now())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInviteCodeInvalid
+		}
+	}
+
+	return db.AddAccount(login, password, "", balance)
+}
+
+// CreateInviteCode generates an invite code. createdBy is the admin's login, empty on the command line.
+func CreateInviteCode(db storage.Storage, createdBy string, maxUses int, expires time.Time) (*entity.InviteCode, error) {
+	now := time.Now()
+	if maxUses < 0 {
+		return nil, errors.New("the maximum number of uses must not be negative")
+	}
+	if !expires.IsZero() && !expires.After(now) {
+		return nil, errors.New("the expiry time must be in the future")
+	}
+
+	buf := make([]byte, inviteCodeRandomBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("reading random bytes failed: %v", err)
+	}
+
+	code := &entity.InviteCode{
+		Code:      base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf),
+		CreatedBy: createdBy,
+		Created:   now.Truncate(time.Second),
+		MaxUses:   maxUses,
+	}
+	if !expires.IsZero() {
+		code.Expires = expires.Truncate(time.Second)
+	}
+
+	if err := db.CreateInviteCode(*code); err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// RevokeInviteCode deletes an invite code, accounts registered with it are kept
+func RevokeInviteCode(db storage.Storage, code string) error {
+	found, err := db.DeleteInviteCode(code)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrInviteCodeNotFound
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package serviceUser
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+	"tradingServer/storage"
+)
+
+func TestValidateLogin(t *testing.T) {
+	tests := []struct {
+		login string
+		valid bool
+	}{
+		{"alice", true},
+		{"a_b.c-1", true},
+		{"ab", false},
+		{"_alice", false},
+		{"alice smith", false},
+		{"abcdefghijklmnopqrstuvwxyz0123456", false},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateLogin(tt.login); (err == nil) != tt.valid {
+			t.Errorf("ValidateLogin(%v) error = %v, want valid %v", tt.login, err, tt.valid)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	db := storage.NewMemory()
+	balance := decimal.NewFromInt(100)
+
+	once, err := CreateInviteCode(db, "admin", 1, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CreateInviteCode(db, "admin", 1, time.Now().Add(-time.Second)); err == nil {
+		t.Error("CreateInviteCode() with an expiry in the past succeeded")
+	}
+
+	tests := []struct {
+		name    string
+		mode    string
+		login   string
+		code    string
+		wantErr error
+	}{
+		{"invite required", RegistrationInvite, "alice", "", ErrInviteCodeRequired},
+		{"unknown invite code", RegistrationInvite, "alice", "NONE", ErrInviteCodeInvalid},
+		{"invite code", RegistrationInvite, "alice", once.Code, nil},
+		{"used up invite code", RegistrationInvite, "bob", once.Code, ErrInviteCodeInvalid},
+		{"login taken", RegistrationOpen, "alice", "", ErrLoginTaken},
+		{"open registration", RegistrationOpen, "bob", "", nil},
+		{"closed registration", RegistrationClosed, "carol", "", ErrInviteCodeRequired},
+	}
+
+	for _, tt := range tests {
+		if err = Register(db, tt.mode, tt.login, "correct horse battery", tt.code, balance); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: Register() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	codes, err := db.GetInviteCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 1 || codes[0].Uses != 1 {
+		t.Errorf("GetInviteCodes() = %+v, want one code used once", codes)
+	}
+
+	if err = RevokeInviteCode(db, once.Code); err != nil {
+		t.Fatal(err)
+	}
+	if err = RevokeInviteCode(db, once.Code); !errors.Is(err, ErrInviteCodeNotFound) {
+		t.Errorf("second RevokeInviteCode() error = %v, want %v", err, ErrInviteCodeNotFound)
+	}
+}
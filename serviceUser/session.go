@@ -0,0 +1,99 @@
+package serviceUser
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+// TokenType is the authorization scheme access tokens are sent with
+const TokenType = "Bearer"
+
+var ErrInvalidRefreshToken = errors.New("refresh token is invalid or has expired")
+
+// CreateSession starts a session for a user whose password has been verified and returns its tokens.
+// Expired sessions of all users are cleaned up on the way.
+func CreateSession(db storage.Storage, login string, accessTTL, refreshTTL time.Duration) (*entity.SessionTokens, error) {
+	now := time.Now()
+	if _, err := db.DeleteExpiredSessions(now); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &entity.SessionTokens{
+		AccessToken:    accessToken,
+		RefreshToken:   refreshToken,
+		TokenType:      TokenType,
+		AccessExpires:  now.Add(accessTTL).Truncate(time.Second),
+		RefreshExpires: now.Add(refreshTTL).Truncate(time.Second),
+	}
+
+	err = db.CreateSession(entity.Session{
+		AccessTokenHash:  HashToken(tokens.AccessToken),
+		RefreshTokenHash: HashToken(tokens.RefreshToken),
+		Login:            login,
+		Created:          now,
+		AccessExpires:    tokens.AccessExpires,
+		RefreshExpires:   tokens.RefreshExpires,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RefreshSession replaces a session by a new one if the refresh token is valid. The old tokens can not be used anymore.
+func RefreshSession(db storage.Storage, refreshToken string, accessTTL, refreshTTL time.Duration) (*entity.SessionTokens, error) {
+	session, err := db.GetSessionByRefreshToken(HashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || !time.Now().Before(session.RefreshExpires) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if err = db.DeleteSession(session.AccessTokenHash); err != nil {
+		return nil, err
+	}
+	return CreateSession(db, session.Login, accessTTL, refreshTTL)
+}
+
+// GetSession returns the session of an access token or nil if the token is unknown or has expired
+func GetSession(db storage.Storage, accessToken string) (*entity.Session, error) {
+	session, err := db.GetSession(HashToken(accessToken))
+	if err != nil || session == nil {
+		return nil, err
+	}
+	if !time.Now().Before(session.AccessExpires) {
+		return nil, nil
+	}
+	return session, nil
+}
+
+// HashToken returns the hash a token is stored as. Tokens are random, so a fast hash is sufficient.
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("reading random bytes failed: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
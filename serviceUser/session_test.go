@@ -0,0 +1,150 @@
+package serviceUser
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+// sessionLogin returns the login of the session an access token authenticates, "" if it authenticates none
+func sessionLogin(t *testing.T, db storage.Storage, accessToken string) string {
+	t.Helper()
+
+	session, err := GetSession(db, accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session == nil {
+		return ""
+	}
+	return session.Login
+}
+
+func TestCreateSession(t *testing.T) {
+	db := storage.NewMemory()
+
+	tests := []struct {
+		name       string
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+		wantLogin  string
+	}{
+		{"valid", time.Minute, time.Hour, "test"},
+		{"access token expired", -time.Second, time.Hour, ""},
+	}
+
+	for _, tt := range tests {
+		tokens, err := CreateSession(db, "test", tt.accessTTL, tt.refreshTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens.AccessToken == tokens.RefreshToken || tokens.TokenType != TokenType {
+			t.Errorf("%v: CreateSession() = %+v, want two distinct bearer tokens", tt.name, tokens)
+		}
+		if got := sessionLogin(t, db, tokens.AccessToken); got != tt.wantLogin {
+			t.Errorf("%v: access token authenticates %q, want %q", tt.name, got, tt.wantLogin)
+		}
+		if got := sessionLogin(t, db, tokens.RefreshToken); got != "" {
+			t.Errorf("%v: refresh token authenticates %q as access token", tt.name, got)
+		}
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	db := storage.NewMemory()
+
+	tokens, err := CreateSession(db, "test", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := RefreshSession(db, tokens.RefreshToken, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"new access token", refreshed.AccessToken, "test"},
+		{"replaced access token", tokens.AccessToken, ""},
+	}
+
+	for _, tt := range tests {
+		if got := sessionLogin(t, db, tt.token); got != tt.want {
+			t.Errorf("%v authenticates %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	expired, err := CreateSession(db, "test", -time.Hour, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []struct {
+		name  string
+		token string
+	}{
+		{"replaced refresh token", tokens.RefreshToken},
+		{"expired refresh token", expired.RefreshToken},
+		{"access token", refreshed.AccessToken},
+		{"unknown token", "abc"},
+	}
+
+	for _, tt := range invalid {
+		if _, err = RefreshSession(db, tt.token, time.Minute, time.Hour); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("%v: RefreshSession() error = %v, want %v", tt.name, err, ErrInvalidRefreshToken)
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	db := storage.NewMemory()
+
+	tokens, err := CreateSession(db, "test", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := CreateSession(db, "test", time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logging out deletes the session of the access token, both of its tokens are revoked
+	if err = db.DeleteSession(HashToken(tokens.AccessToken)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sessionLogin(t, db, tokens.AccessToken); got != "" {
+		t.Errorf("revoked access token authenticates %q", got)
+	}
+	if _, err = RefreshSession(db, tokens.RefreshToken, time.Minute, time.Hour); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("RefreshSession() of revoked session error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if got := sessionLogin(t, db, other.AccessToken); got != "test" {
+		t.Errorf("other session authenticates %q after logout, want %q", got, "test")
+	}
+}
+
+func TestCreateSessionDeletesExpiredSessions(t *testing.T) {
+	db := storage.NewMemory()
+
+	expired, err := CreateSession(db, "test", -time.Hour, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CreateSession(db, "test", time.Minute, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	var session *entity.Session
+	if session, err = db.GetSessionByRefreshToken(HashToken(expired.RefreshToken)); err != nil {
+		t.Fatal(err)
+	}
+	if session != nil {
+		t.Errorf("expired session %+v has not been deleted", session)
+	}
+}
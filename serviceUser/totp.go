@@ -0,0 +1,267 @@
+package serviceUser
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/logging"
+	"tradingServer/storage"
+)
+
+const (
+	// totpPeriod, totpDigits and the SHA-1 HMAC are the defaults of RFC 6238 every authenticator app supports
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	// totpSkew accepts codes of the neighbouring time steps to allow for clock drift
+	totpSkew          = 1
+	totpSecretBytes   = 20
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is encoded as 10 characters
+	recoveryCodeBytes = 6
+)
+
+var (
+	ErrTOTPNotEnrolled    = errors.New("two-factor authentication has not been set up")
+	ErrTOTPEnabledAlready = errors.New("two-factor authentication is enabled already")
+	ErrTOTPNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTOTPInvalid        = errors.New("invalid two-factor code")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// BeginTOTPEnrollment generates a new secret for a user. It has to be confirmed with a code by EnableTOTP before it
+// is required, an enrollment which has not been confirmed is replaced.
+func BeginTOTPEnrollment(db storage.Storage, login, issuer string) (*entity.TOTPEnrollment, error) {
+	t, err := db.GetTOTP(login)
+	if err != nil {
+		return nil, err
+	}
+	if t.Enabled {
+		return nil, ErrTOTPEnabledAlready
+	}
+
+	buf := make([]byte, totpSecretBytes)
+	if _, err = rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("reading random bytes failed: %v", err)
+	}
+	secret := base32NoPadding.EncodeToString(buf)
+
+	if err = db.SaveTOTP(login, entity.TOTP{Secret: secret}); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(int(totpPeriod.Seconds())))
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + issuer + ":" + login, RawQuery: query.Encode()}
+
+	return &entity.TOTPEnrollment{Secret: secret, URL: u.String()}, nil
+}
+
+// EnableTOTP confirms the enrollment of a user with a code of the authenticator app and returns the recovery codes,
+// each of which can be used once instead of a code
+func EnableTOTP(db storage.Storage, login, code string) ([]string, error) {
+	t, err := db.GetTOTP(login)
+	if err != nil {
+		return nil, err
+	}
+	if t.Enabled {
+		return nil, ErrTOTPEnabledAlready
+	}
+	if t.Secret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	step, ok := verifyTOTPCode(t, code, time.Now())
+	if !ok {
+		return nil, ErrTOTPInvalid
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	t.Enabled = true
+	t.LastStep = step
+	t.RecoveryCodeHashes = hashes
+	if err = db.SaveTOTP(login, *t); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off. The caller has to verify a code first, unless an admin resets it
+// for a user who lost the authenticator app and the recovery codes.
+func DisableTOTP(db storage.Storage, login string) error {
+	return db.SaveTOTP(login, entity.TOTP{})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user, the caller has to verify a code first
+func RegenerateRecoveryCodes(db storage.Storage, login string) ([]string, error) {
+	t, err := db.GetTOTP(login)
+	if err != nil {
+		return nil, err
+	}
+	if !t.Enabled {
+		return nil, ErrTOTPNotEnabled
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	t.RecoveryCodeHashes = hashes
+	if err = db.SaveTOTP(login, *t); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// SecondFactorUse tells which code VerifySecondFactor accepted, see ConsumeSecondFactor
+type SecondFactorUse struct {
+	// Step is the time step of a code of the authenticator app
+	Step int64
+	// RecoveryCodeHash is the hash of a recovery code
+	RecoveryCodeHash string
+}
+
+// VerifySecondFactor checks a code of the user's authenticator app or one of the recovery codes. Codes are accepted
+// once, a used recovery code is removed.
+func VerifySecondFactor(db storage.Storage, login, code string) (*SecondFactorUse, error) {
+	t, err := db.GetTOTP(login)
+	if err != nil {
+		return nil, err
+	}
+	if !t.Enabled {
+		return nil, ErrTOTPNotEnabled
+	}
+
+	if step, ok := verifyTOTPCode(t, code, time.Now()); ok {
+		t.LastStep = step
+		return &SecondFactorUse{Step: step}, db.SaveTOTP(login, *t)
+	}
+
+	hash := HashToken(normalizeRecoveryCode(code))
+	for i, h := range t.RecoveryCodeHashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			t.RecoveryCodeHashes = append(t.RecoveryCodeHashes[:i:i], t.RecoveryCodeHashes[i+1:]...)
+			logging.Infof("login '%v' used a recovery code, %v left", login, len(t.RecoveryCodeHashes))
+			return &SecondFactorUse{RecoveryCodeHash: hash}, db.SaveTOTP(login, *t)
+		}
+	}
+	return nil, ErrTOTPInvalid
+}
+
+// ConsumeSecondFactor marks a code accepted by VerifySecondFactor as used in a transaction of its own. It has to be
+// called if the transaction in which the code was verified is rolled back, otherwise the code could be used again.
+func ConsumeSecondFactor(db storage.Storage, login string, use SecondFactorUse) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	t, err := tx.GetTOTP(login)
+	if err != nil {
+		return err
+	}
+
+	if use.Step > t.LastStep {
+		t.LastStep = use.Step
+	}
+	for i, h := range t.RecoveryCodeHashes {
+		if h == use.RecoveryCodeHash {
+			t.RecoveryCodeHashes = append(t.RecoveryCodeHashes[:i:i], t.RecoveryCodeHashes[i+1:]...)
+			break
+		}
+	}
+
+	if err = tx.SaveTOTP(login, *t); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// verifyTOTPCode returns the time step of the code if it is valid and newer than the last one accepted
+func verifyTOTPCode(t *entity.TOTP, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	secret, err := base32NoPadding.DecodeString(t.Secret)
+	if err != nil {
+		logging.Errorf("invalid totp secret: %v", err)
+		return 0, false
+	}
+
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > t.LastStep && subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the code of a time step as defined by RFC 4226, section 5.3
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// newRecoveryCodes returns recovery codes like "abcde-fghij" and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("reading random bytes failed: %v", err)
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(buf))
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = HashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts recovery codes typed without dash or in upper case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
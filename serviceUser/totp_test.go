@@ -0,0 +1,142 @@
+package serviceUser
+
+import (
+	"errors"
+	"testing"
+	"time"
+	"tradingServer/entity"
+	"tradingServer/storage"
+)
+
+// rfcSecret is the SHA-1 secret of the test vectors in RFC 6238, appendix B
+var rfcSecret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// the RFC lists 8 digit codes, the last 6 digits are the 6 digit codes
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := totpCode(rfcSecret, tt.unix/30); got != tt.want {
+			t.Errorf("totpCode() at %v = %v, want %v", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTPCode(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / 30
+	secret := base32NoPadding.EncodeToString(rfcSecret)
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", totpCode(rfcSecret, step), 0, step, true},
+		{"surrounding spaces", " " + totpCode(rfcSecret, step) + " ", 0, step, true},
+		{"previous step", totpCode(rfcSecret, step-1), 0, step - 1, true},
+		{"next step", totpCode(rfcSecret, step+1), 0, step + 1, true},
+		{"two steps ago", totpCode(rfcSecret, step-2), 0, 0, false},
+		{"two steps ahead", totpCode(rfcSecret, step+2), 0, 0, false},
+		{"replayed", totpCode(rfcSecret, step), step, 0, false},
+		{"older than the last accepted", totpCode(rfcSecret, step-1), step, 0, false},
+		{"newer than the last accepted", totpCode(rfcSecret, step+1), step, step + 1, true},
+		{"too short", totpCode(rfcSecret, step)[1:], 0, 0, false},
+		{"empty", "", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := verifyTOTPCode(&entity.TOTP{Secret: secret, Enabled: true, LastStep: tt.lastStep}, tt.code, now)
+		if got != tt.wantStep || ok != tt.wantOK {
+			t.Errorf("%v: verifyTOTPCode(%q) = %v, %v, want %v, %v", tt.name, tt.code, got, ok, tt.wantStep, tt.wantOK)
+		}
+	}
+}
+
+// enableTestTOTP enables two-factor authentication for the user "test" with the RFC secret and one recovery code
+func enableTestTOTP(t *testing.T, db storage.Storage, recoveryCode string) {
+	t.Helper()
+
+	err := db.SaveTOTP("test", entity.TOTP{
+		Secret:             base32NoPadding.EncodeToString(rfcSecret),
+		Enabled:            true,
+		RecoveryCodeHashes: []string{HashToken(normalizeRecoveryCode(recoveryCode))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	db := storage.NewMemory()
+	enableTestTOTP(t, db, "abcde-fghij")
+	code := totpCode(rfcSecret, time.Now().Unix()/30)
+
+	tests := []struct {
+		name    string
+		code    string
+		wantErr error
+	}{
+		{"code", code, nil},
+		{"replayed code", code, ErrTOTPInvalid},
+		{"recovery code", "ABCDEFGHIJ", nil},
+		{"used recovery code", "abcde-fghij", ErrTOTPInvalid},
+		{"wrong code", "12345", ErrTOTPInvalid},
+	}
+
+	for _, tt := range tests {
+		if _, err := VerifySecondFactor(db, "test", tt.code); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: VerifySecondFactor() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestConsumeSecondFactorAfterRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		code func() string
+	}{
+		{"code", func() string { return totpCode(rfcSecret, time.Now().Unix()/30) }},
+		{"recovery code", func() string { return "abcde-fghij" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := storage.NewMemory()
+			enableTestTOTP(t, db, "abcde-fghij")
+			code := tt.code()
+
+			// the code is accepted in a request which fails afterwards
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			use, err := VerifySecondFactor(tx, "test", code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = tx.Rollback(); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = ConsumeSecondFactor(db, "test", *use); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = VerifySecondFactor(db, "test", code); !errors.Is(err, ErrTOTPInvalid) {
+				t.Errorf("VerifySecondFactor() of consumed code error = %v, want %v", err, ErrTOTPInvalid)
+			}
+		})
+	}
+}
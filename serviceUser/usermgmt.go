@@ -1,6 +1,7 @@
 package serviceUser
 
 import (
+	"github.com/shopspring/decimal"
 	"log"
 	"math/rand"
 	"strings"
@@ -9,15 +10,14 @@ import (
 	"tradingServer/storage"
 )
 
-func AddUser(login, password, email string) {
+func AddUser(db storage.Storage, login, password, email string, balance decimal.Decimal) {
 	autogen := false
 	if password == "" {
 		autogen = true
 		password = GenPassword(24)
 	}
 
-	db := storage.GetDatabase()
-	if err := db.AddAccount(login, password, email); err != nil {
+	if err := db.AddAccount(login, password, email, balance); err != nil {
 		log.Fatalf("could not create user account: %v", err)
 	}
 
@@ -41,15 +41,13 @@ func GenPassword(length int) string {
 	return pw.String()
 }
 
-func ChangeUser(login, password, email string) {
+func ChangeUser(db storage.Storage, login, password, email string) {
 	autogen := false
 	if password == "" && email == "" {
 		autogen = true
 		password = GenPassword(24)
 	}
 
-	db := storage.GetDatabase()
-
 	if err := db.UpdateAccount(login, password, email); err != nil {
 		log.Fatalf("could not update user account: %v", err)
 	}
@@ -61,10 +59,35 @@ func ChangeUser(login, password, email string) {
 	}
 }
 
+// SetTier assigns a rate limit tier to a user, the empty tier restores the default limits
+func SetTier(db storage.Storage, login, tier string) {
+	if err := db.SetAccountTier(login, tier); err != nil {
+		log.Fatalf("could not update user account: %v", err)
+	}
+
+	if tier == "" {
+		log.Printf("user account '%v' has been reset to the default rate limits\n", login)
+	} else {
+		log.Printf("user account '%v' has been assigned to tier '%v'\n", login, tier)
+	}
+}
+
+// SetAdmin grants or revokes the admin role of a user
+func SetAdmin(db storage.Storage, login string, admin bool) {
+	if err := db.SetAccountAdmin(login, admin); err != nil {
+		log.Fatalf("could not update user account: %v", err)
+	}
+
+	if admin {
+		log.Printf("user account '%v' has been granted the admin role\n", login)
+	} else {
+		log.Printf("user account '%v' has lost the admin role\n", login)
+	}
+}
+
 // RemoveUsers deletes all user accounts except "roman"
-func RemoveUsers() {
+func RemoveUsers(db storage.Storage) {
 	const exception = "roman"
-	db := storage.GetDatabase()
 	var accs []*entity.PublicAccount
 	var err error
 	if accs, err = db.GetAccounts(); err != nil {
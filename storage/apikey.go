@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"tradingServer/entity"
+)
+
+const apiKeyColumns = `id, login, name, key_hash, prefix, scopes, allowed_ips, created, expires`
+
+func (db *Database) CreateAPIKey(k *entity.APIKey) error {
+	expires := sql.NullInt64{Int64: k.Expires.Unix(), Valid: !k.Expires.IsZero()}
+
+	q := `INSERT INTO api_keys (login, name, key_hash, prefix, scopes, allowed_ips, created, expires) VALUES (?,?,?,?,?,?,?,?) RETURNING id`
+	err := db.QueryRow(q, k.Login, k.Name, k.Hash, k.Prefix, strings.Join(k.Scopes, ","), strings.Join(k.AllowedIPs, ","),
+		k.Created.Unix(), expires).Scan(&k.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") || strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("api key '%v' exists already", k.Name)
+		}
+		return fmt.Errorf("insert api key failed: %v", err)
+	}
+	return nil
+}
+
+func (db *Database) GetAPIKeyByHash(hash string) (*entity.APIKey, error) {
+	keys, err := db.queryAPIKeys(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, hash)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+func (db *Database) GetAPIKeys(login string) ([]*entity.APIKey, error) {
+	return db.queryAPIKeys(`SELECT `+apiKeyColumns+` FROM api_keys WHERE login = ? ORDER BY id`, login)
+}
+
+func (db *Database) DeleteAPIKey(login string, id int64) (bool, error) {
+	res, err := db.Exec(`DELETE FROM api_keys WHERE login = ? AND id = ?`, login, id)
+	if err != nil {
+		return false, fmt.Errorf("delete api key %v failed: %v", id, err)
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (db *Database) queryAPIKeys(q string, args ...interface{}) ([]*entity.APIKey, error) {
+	res, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query api keys failed: %v", err)
+	}
+	defer res.Close()
+
+	keys := []*entity.APIKey{}
+	for res.Next() {
+		var k entity.APIKey
+		var scopes, allowedIPs string
+		var created int64
+		var expires sql.NullInt64
+
+		err = res.Scan(&k.ID, &k.Login, &k.Name, &k.Hash, &k.Prefix, &scopes, &allowedIPs, &created, &expires)
+		if err != nil {
+			return nil, fmt.Errorf("scan api key failed: %v", err)
+		}
+
+		k.Scopes = splitList(scopes)
+		k.AllowedIPs = splitList(allowedIPs)
+		k.Created = time.Unix(created, 0)
+		if expires.Valid {
+			k.Expires = time.Unix(expires.Int64, 0)
+		}
+		keys = append(keys, &k)
+	}
+	return keys, res.Err()
+}
+
+// splitList splits a comma separated list, the empty string is the empty list
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
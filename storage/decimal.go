@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"strconv"
+	"strings"
+)
+
+// decimalTables describes the tables holding decimal numbers. The numbers are stored as text to keep them exact.
+// Up to schema version 3 they were stored as REAL.
+var decimalTables = []struct {
+	name       string
+	columns    []string
+	realSchema string
+	textSchema string // %v is replaced by the table name
+}{
+	{
+		name:       "users",
+		columns:    []string{"balance"},
+		realSchema: usersSchemaV1,
+		textSchema: `CREATE TABLE %v (login varchar(64) PRIMARY KEY, password varchar(255) NOT NULL, email varchar(255), balance TEXT NOT NULL)`,
+	},
+	{
+		name:       "market_assets",
+		columns:    []string{"price"},
+		realSchema: marketAssetsSchemaV1,
+		textSchema: `CREATE TABLE %v (name varchar(64) PRIMARY KEY, price TEXT NOT NULL)`,
+	},
+	{
+		name:       "user_assets",
+		columns:    []string{"amount"},
+		realSchema: userAssetsSchemaV1,
+		textSchema: `CREATE TABLE %v (
+	login varchar(64),
+	asset varchar(255),
+	amount TEXT NOT NULL,
+	PRIMARY KEY (login, asset),
+	FOREIGN KEY (login) REFERENCES users (login),
+	FOREIGN KEY (asset) REFERENCES market_assets (name)
+)`,
+	},
+	{
+		name:       "transaction_log",
+		columns:    []string{"unit_price", "payed_price", "amount", "balance"},
+		realSchema: transactionLogSchemaV1,
+		textSchema: `CREATE TABLE %v (
+	time VARCHAR(64),
+	login VARCHAR(64),
+	action VARCHAR(64),
+	unit_price TEXT,
+	payed_price TEXT,
+	amount TEXT,
+	asset VARCHAR(64),
+	balance TEXT
+)`,
+	},
+	{
+		name:       "price_history",
+		columns:    []string{"price"},
+		realSchema: priceHistorySchemaV2,
+		textSchema: `CREATE TABLE %v (
+	time INT,
+	asset VARCHAR(255),
+	price TEXT,
+	PRIMARY KEY (time, asset),
+	FOREIGN KEY (asset) REFERENCES market_assets (name)
+)`,
+	},
+	{
+		name:       "orders",
+		columns:    []string{"amount", "price", "fill_price"},
+		realSchema: ordersSchemaV3,
+		textSchema: `CREATE TABLE %v (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login VARCHAR(64) NOT NULL,
+	type VARCHAR(16) NOT NULL,
+	side VARCHAR(8) NOT NULL,
+	asset VARCHAR(64) NOT NULL,
+	amount TEXT NOT NULL,
+	price TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	created VARCHAR(64),
+	updated VARCHAR(64),
+	fill_price TEXT,
+	FOREIGN KEY (login) REFERENCES users (login),
+	FOREIGN KEY (asset) REFERENCES market_assets (name)
+)`,
+	},
+}
+
+// convertDecimalColumns rebuilds every table still storing decimal numbers as REAL with text columns
+func convertDecimalColumns(tx *sql.Tx) error {
+	for _, t := range decimalTables {
+		isReal, err := hasRealColumns(tx, t.name, t.columns)
+		if err != nil {
+			return fmt.Errorf("could not inspect table %v: %v", t.name, err)
+		}
+		if !isReal {
+			continue
+		}
+
+		if err = rebuildTable(tx, t.name, t.textSchema, t.columns, exactDecimalText); err != nil {
+			return fmt.Errorf("could not convert decimal columns of table %v: %v", t.name, err)
+		}
+	}
+	return nil
+}
+
+// revertDecimalColumns rebuilds every table storing decimal numbers as text with REAL columns
+func revertDecimalColumns(tx *sql.Tx) error {
+	for _, t := range decimalTables {
+		isReal, err := hasRealColumns(tx, t.name, t.columns)
+		if err != nil {
+			return fmt.Errorf("could not inspect table %v: %v", t.name, err)
+		}
+		if isReal {
+			continue
+		}
+
+		if err = rebuildTable(tx, t.name, t.realSchema, t.columns, decimalTextToReal); err != nil {
+			return fmt.Errorf("could not revert decimal columns of table %v: %v", t.name, err)
+		}
+	}
+	return nil
+}
+
+func hasRealColumns(tx *sql.Tx, table string, columns []string) (bool, error) {
+	res, err := tx.Query(`SELECT name, type FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer res.Close()
+
+	for res.Next() {
+		var name, colType string
+		if err = res.Scan(&name, &colType); err != nil {
+			return false, err
+		}
+
+		for _, col := range columns {
+			if col == name && strings.EqualFold(colType, "REAL") {
+				return true, nil
+			}
+		}
+	}
+
+	return false, res.Err()
+}
+
+// rebuildTable copies all rows of a table into a new table created by schema, converting the decimal columns,
+// and replaces the old table by the new one. Foreign key checks have to be disabled.
+func rebuildTable(tx *sql.Tx, table string, schema string, decimalColumns []string, convert func(interface{}) interface{}) error {
+	newTable := table + "_rebuild"
+	if _, err := tx.Exec(fmt.Sprintf(schema, newTable)); err != nil {
+		return err
+	}
+
+	res, err := tx.Query("SELECT * FROM " + table)
+	if err != nil {
+		return err
+	}
+
+	columns, err := res.Columns()
+	if err != nil {
+		res.Close()
+		return err
+	}
+
+	isDecimal := make([]bool, len(columns))
+	for i, col := range columns {
+		for _, decCol := range decimalColumns {
+			isDecimal[i] = isDecimal[i] || col == decCol
+		}
+	}
+
+	var rows [][]interface{}
+	for res.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+
+		if err = res.Scan(pointers...); err != nil {
+			res.Close()
+			return err
+		}
+
+		for i := range values {
+			if isDecimal[i] {
+				values[i] = convert(values[i])
+			}
+		}
+		rows = append(rows, values)
+	}
+	res.Close()
+	if err = res.Err(); err != nil {
+		return err
+	}
+
+	insert := fmt.Sprintf("INSERT INTO %v (%v) VALUES (?%v)",
+		newTable, strings.Join(columns, ","), strings.Repeat(",?", len(columns)-1))
+	for _, values := range rows {
+		if _, err = tx.Exec(insert, values...); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec("DROP TABLE " + table); err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %v RENAME TO %v", newTable, table))
+	return err
+}
+
+// exactDecimalText converts a number read from a REAL column to the shortest decimal text representing it
+func exactDecimalText(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		return decimal.NewFromFloat(v).String()
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case []byte:
+		return string(v)
+	default:
+		// NULL or text already
+		return v
+	}
+}
+
+// decimalTextToReal converts decimal text to the nearest float64
+func decimalTextToReal(value interface{}) interface{} {
+	var str string
+	switch v := value.(type) {
+	case string:
+		str = v
+	case []byte:
+		str = string(v)
+	default:
+		// NULL or number already
+		return v
+	}
+
+	d, err := decimal.NewFromString(str)
+	if err != nil {
+		return value
+	}
+	return d.InexactFloat64()
+}
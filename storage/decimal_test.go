@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+func TestDecimalColumnsMigration(t *testing.T) {
+	db := openTestDatabase(t, 3)
+
+	// rows written by servers before schema version 4
+	seed := []string{
+		`UPDATE users SET balance = 0.1 + 0.2 WHERE login = 'test'`,
+		`INSERT INTO users (login, password, balance) VALUES ('alice', 'x', 12.3)`,
+		`UPDATE market_assets SET price = 0.07 WHERE name = 'white_wool'`,
+		`INSERT INTO user_assets (login, asset, amount) VALUES ('alice', 'toothpaste', 2.5)`,
+		`INSERT INTO transaction_log (time, login, action, unit_price, payed_price, amount, asset, balance)
+			VALUES ('2022-03-01', 'alice', 'buy', 8.5, 21.25, 2.5, 'toothpaste', 12.3)`,
+		`INSERT INTO price_history (time, asset, price) VALUES (1646136000, 'olive_oil', 126.99)`,
+		`INSERT INTO orders (login, type, side, asset, amount, price, status, fill_price)
+			VALUES ('alice', 'limit', 'buy', 'toothpaste', 1, 8.1, 'open', NULL)`,
+	}
+	for _, q := range seed {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		table, column, where string
+		want                 sql.NullString
+		wantReverted         float64
+	}{
+		{"users", "balance", "login = 'test'", sql.NullString{String: "0.30000000000000004", Valid: true}, 0.30000000000000004},
+		{"users", "balance", "login = 'alice'", sql.NullString{String: "12.3", Valid: true}, 12.3},
+		{"market_assets", "price", "name = 'white_wool'", sql.NullString{String: "0.07", Valid: true}, 0.07},
+		{"market_assets", "price", "name = 'olive_oil'", sql.NullString{String: "127", Valid: true}, 127},
+		{"user_assets", "amount", "login = 'alice'", sql.NullString{String: "2.5", Valid: true}, 2.5},
+		{"transaction_log", "payed_price", "login = 'alice'", sql.NullString{String: "21.25", Valid: true}, 21.25},
+		{"price_history", "price", "asset = 'olive_oil'", sql.NullString{String: "126.99", Valid: true}, 126.99},
+		{"orders", "amount", "login = 'alice'", sql.NullString{String: "1", Valid: true}, 1},
+		{"orders", "price", "login = 'alice'", sql.NullString{String: "8.1", Valid: true}, 8.1},
+		{"orders", "fill_price", "login = 'alice'", sql.NullString{}, 0},
+	}
+
+	if err := db.runMigrations(migrations[3:4], true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		var typ string
+		var got sql.NullString
+		q := fmt.Sprintf("SELECT typeof(%[1]v), %[1]v FROM %[2]v WHERE %[3]v", tt.column, tt.table, tt.where)
+		if err := db.QueryRow(q).Scan(&typ, &got); err != nil {
+			t.Fatalf("%v: %v", q, err)
+		}
+		wantType := "text"
+		if !tt.want.Valid {
+			wantType = "null"
+		}
+		if typ != wantType || got != tt.want {
+			t.Errorf("%v.%v = %v %q, want %v %q", tt.table, tt.column, typ, got.String, wantType, tt.want.String)
+		}
+	}
+
+	// reverting the migration restores the REAL columns
+	if err := db.runMigrations(migrations[3:4], false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		var got sql.NullFloat64
+		q := fmt.Sprintf("SELECT %v FROM %v WHERE %v", tt.column, tt.table, tt.where)
+		if err := db.QueryRow(q).Scan(&got); err != nil {
+			t.Fatalf("%v: %v", q, err)
+		}
+		if got.Valid != tt.want.Valid || got.Float64 != tt.wantReverted {
+			t.Errorf("%v.%v after revert = %v, want %v", tt.table, tt.column, got.Float64, tt.wantReverted)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"tradingServer/entity"
+)
+
+func (db *Database) CreateEmailConfirmation(e entity.EmailConfirmation) error {
+	if err := db.DeleteEmailConfirmation(e.Login); err != nil {
+		return err
+	}
+
+	q := `INSERT INTO email_confirmations (login, token_hash, email, created, expires) VALUES (?,?,?,?,?)`
+	if _, err := db.Exec(q, e.Login, e.TokenHash, e.Email, e.Created.Unix(), e.Expires.Unix()); err != nil {
+		return fmt.Errorf("insert email confirmation failed: %v", err)
+	}
+	return nil
+}
+
+func (db *Database) GetEmailConfirmation(tokenHash string) (*entity.EmailConfirmation, error) {
+	var e entity.EmailConfirmation
+	var created, expires int64
+
+	q := `SELECT login, token_hash, email, created, expires FROM email_confirmations WHERE token_hash = ?`
+	err := db.QueryRow(q, tokenHash).Scan(&e.Login, &e.TokenHash, &e.Email, &created, &expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query email confirmation failed: %v", err)
+	}
+
+	e.Created = time.Unix(created, 0)
+	e.Expires = time.Unix(expires, 0)
+	return &e, nil
+}
+
+func (db *Database) DeleteEmailConfirmation(login string) error {
+	if _, err := db.Exec(`DELETE FROM email_confirmations WHERE login = ?`, login); err != nil {
+		return fmt.Errorf("delete email confirmation failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+var historyStart = time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// addTestPrices stores a price of 1 for each asset at the given offset from historyStart
+func addTestPrices(t *testing.T, db *Database, prices map[time.Duration][]string) {
+	t.Helper()
+
+	for offset, assets := range prices {
+		for _, a := range assets {
+			_, err := db.Exec(`INSERT INTO price_history (time, asset, price) VALUES (?,?,1)`, historyStart.Add(offset).UnixMilli(), a)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// historyTimes returns the offsets from historyStart of the stored prices of an asset
+func historyTimes(t *testing.T, db *Database, asset string) []time.Duration {
+	t.Helper()
+
+	history, err := db.GetPriceHistory(asset, historyStart, historyStart.Add(24*time.Hour), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []time.Duration
+	for _, h := range history {
+		offsets = append(offsets, h.When.Sub(historyStart))
+	}
+	return offsets
+}
+
+func equalDurations(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGetPriceHistory(t *testing.T) {
+	db := openTestDatabase(t, len(migrations))
+	addTestPrices(t, db, map[time.Duration][]string{
+		0:               {"toothpaste", "olive_oil"},
+		time.Second:     {"toothpaste"},
+		2 * time.Second: {"toothpaste", "olive_oil"},
+	})
+
+	tests := []struct {
+		name     string
+		asset    string
+		from, to time.Duration
+		limit    int
+		want     int
+	}{
+		{"single asset", "toothpaste", 0, time.Minute, 100, 3},
+		{"all assets", "", 0, time.Minute, 100, 5},
+		{"range is inclusive", "", time.Second, 2 * time.Second, 100, 3},
+		{"limit", "", 0, time.Minute, 2, 2},
+		{"unknown asset", "old_tires", 0, time.Minute, 100, 0},
+	}
+
+	for _, tt := range tests {
+		got, err := db.GetPriceHistory(tt.asset, historyStart.Add(tt.from), historyStart.Add(tt.to), tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("%v: GetPriceHistory() returned %v entries, want %v", tt.name, len(got), tt.want)
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i].When.Before(got[i-1].When) {
+				t.Errorf("%v: entries not ordered by time", tt.name)
+			}
+		}
+	}
+}
+
+func TestDownsamplePriceHistory(t *testing.T) {
+	db := openTestDatabase(t, len(migrations))
+	addTestPrices(t, db, map[time.Duration][]string{
+		0:                              {"toothpaste"},
+		20 * time.Second:               {"toothpaste"},
+		30 * time.Second:               {"olive_oil"},
+		50 * time.Second:               {"toothpaste"},
+		time.Minute + 10*time.Second:   {"toothpaste"},
+		2*time.Minute + 10*time.Second: {"toothpaste"},
+		2*time.Minute + 20*time.Second: {"toothpaste"},
+		3*time.Minute + 5*time.Second:  {"olive_oil"},
+	})
+
+	// the last price per minute is kept for everything older than 2:30
+	n, err := db.DownsamplePriceHistory(historyStart.Add(2*time.Minute+30*time.Second), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("DownsamplePriceHistory() deleted %v entries, want 3", n)
+	}
+
+	tests := []struct {
+		asset string
+		want  []time.Duration
+	}{
+		{"toothpaste", []time.Duration{50 * time.Second, time.Minute + 10*time.Second, 2*time.Minute + 20*time.Second}},
+		// the other asset keeps its own price of the first minute
+		{"olive_oil", []time.Duration{30 * time.Second, 3*time.Minute + 5*time.Second}},
+	}
+
+	for _, tt := range tests {
+		if got := historyTimes(t, db, tt.asset); !equalDurations(got, tt.want) {
+			t.Errorf("%v: history after downsampling = %v, want %v", tt.asset, got, tt.want)
+		}
+	}
+}
+
+func TestPurgePriceHistory(t *testing.T) {
+	db := openTestDatabase(t, len(migrations))
+	addTestPrices(t, db, map[time.Duration][]string{
+		0:           {"toothpaste", "olive_oil"},
+		time.Minute: {"toothpaste"},
+	})
+
+	n, err := db.PurgePriceHistory(historyStart.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("PurgePriceHistory() deleted %v entries, want 2", n)
+	}
+	if got := historyTimes(t, db, ""); !equalDurations(got, []time.Duration{time.Minute}) {
+		t.Errorf("history after purge = %v, want [%v]", got, time.Minute)
+	}
+}
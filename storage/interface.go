@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"time"
+	"tradingServer/entity"
+)
+
+// ErrNotFound is wrapped by the error of GetAccount if the login does not exist
+var ErrNotFound = errors.New("not found")
+
+// Storage is implemented by every storage backend. Services get it injected instead of opening the database themselves.
+type Storage interface {
+	// Begin starts a transaction and returns a Storage running all its operations in that transaction
+	Begin() (Storage, error)
+	// Commit commits the transaction started by Begin and runs the functions registered by AfterCommit
+	Commit() error
+	// Rollback aborts the transaction started by Begin. Functions registered by AfterCommit are discarded.
+	Rollback() error
+	// AfterCommit registers a function to be run once the transaction has been committed successfully.
+	// Outside of a transaction the function is run immediately.
+	AfterCommit(fn func())
+	// SetRequestID tags the transaction log entries written in the transaction with the X-Request-ID of its request
+	SetRequestID(id string)
+	Close() error
+	// Ping checks that the storage can be reached
+	Ping() error
+	// SchemaVersion returns the version of the last migration applied, storages without schema are always up to date
+	SchemaVersion() (int, error)
+	// HoldMarketLock tells whether this server instance runs the market, i.e. varies the prices and executes the
+	// orders. Of the instances sharing a PostgreSQL database the first to call it gets the lock and keeps it until it
+	// closes the storage or loses its connection, then the next caller takes over. Other storages are used by a single
+	// instance, which always holds the lock.
+	HoldMarketLock() (bool, error)
+
+	LogAccess(e AccessLogEntry) error
+	LogTransaction(e TransactionLogEntry) error
+	// GetAccessLog returns the last count access log entries, newest first. All entries are returned if count < 0.
+	GetAccessLog(count int) ([]AccessLogEntry, error)
+	// GetTransactionLog returns the last count transaction log entries, newest first. All entries are returned if count < 0.
+	GetTransactionLog(count int) ([]TransactionLogEntry, error)
+	// ClearLogs deletes all access and transaction log entries and returns the number of deleted entries of each
+	ClearLogs() (int64, int64, error)
+
+	GetAssets() ([]entity.MarketAsset, error)
+	GetAssetPrice(assetName string) (decimal.Decimal, error)
+	SetAssetPrice(assetName string, price decimal.Decimal) error
+	CreateMarketAsset(asset entity.MarketAsset) error
+	UpdateMarketAsset(name string, price decimal.Decimal) error
+	// GetAsset returns a market asset or nil if there is none
+	GetAsset(name string) (*entity.MarketAsset, error)
+	SetAssetHalted(name string, halted bool) error
+	// RemoveMarketAsset deletes an asset unless users hold it or orders refer to it
+	RemoveMarketAsset(name string) error
+
+	GetPriceHistory(assetName string, from, to time.Time, limit int) ([]entity.MarketAsset, error)
+	DownsamplePriceHistory(before time.Time, bucket time.Duration) (int64, error)
+	PurgePriceHistory(before time.Time) (int64, error)
+
+	GetAccounts() ([]*entity.PublicAccount, error)
+	// GetAccount returns the account of a login, the error wraps ErrNotFound if there is none
+	GetAccount(login string) (*entity.Account, error)
+	SaveAccount(acc entity.Account) error
+	AddAccount(login string, password string, email string, balance decimal.Decimal) error
+	UpdateAccount(login, password, email string) error
+	SetAccountTier(login string, tier string) error
+	SetAccountAdmin(login string, admin bool) error
+	SetAccountDisabled(login string, disabled bool) error
+	GetTOTP(login string) (*entity.TOTP, error)
+	// SaveTOTP replaces the two-factor authentication settings of a user, the zero value removes them
+	SaveTOTP(login string, t entity.TOTP) error
+	RemoveAccount(account *entity.PublicAccount) error
+
+	CreateOrder(o *entity.Order) error
+	UpdateOrder(o entity.Order) error
+	GetOrder(id int64) (*entity.Order, error)
+	GetOrders(login string, openOnly bool) ([]*entity.Order, error)
+	GetOpenOrders() ([]*entity.Order, error)
+
+	CreateSession(s entity.Session) error
+	// GetSession returns the session of an access token hash or nil if there is none
+	GetSession(accessTokenHash string) (*entity.Session, error)
+	// GetSessionByRefreshToken returns the session of a refresh token hash or nil if there is none.
+	// In a transaction the session is locked.
+	GetSessionByRefreshToken(refreshTokenHash string) (*entity.Session, error)
+	DeleteSession(accessTokenHash string) error
+	// DeleteExpiredSessions deletes the sessions whose refresh token expired before the given time
+	DeleteExpiredSessions(before time.Time) (int64, error)
+	// DeleteSessions deletes the sessions of a user except the one of the given access token hash
+	DeleteSessions(login string, exceptAccessTokenHash string) error
+
+	// CreateAPIKey stores a new API key and sets its ID
+	CreateAPIKey(k *entity.APIKey) error
+	// GetAPIKeyByHash returns the API key of a key hash or nil if there is none
+	GetAPIKeyByHash(hash string) (*entity.APIKey, error)
+	// GetAPIKeys returns the API keys of a user, oldest first
+	GetAPIKeys(login string) ([]*entity.APIKey, error)
+	// DeleteAPIKey deletes an API key of a user and tells whether it existed
+	DeleteAPIKey(login string, id int64) (bool, error)
+
+	// CreateEmailConfirmation stores a pending email change, replacing the user's previous one if any
+	CreateEmailConfirmation(e entity.EmailConfirmation) error
+	// GetEmailConfirmation returns the pending email change of a token hash or nil if there is none
+	GetEmailConfirmation(tokenHash string) (*entity.EmailConfirmation, error)
+	DeleteEmailConfirmation(login string) error
+
+	CreateInviteCode(i entity.InviteCode) error
+	// GetInviteCodes returns all invite codes, oldest first
+	GetInviteCodes() ([]*entity.InviteCode, error)
+	// UseInviteCode counts a registration with an invite code and tells whether the code was still valid at the given
+	// time. Invalid codes are left unchanged.
+	UseInviteCode(code string, now time.Time) (bool, error)
+	// DeleteInviteCode deletes an invite code and tells whether it existed
+	DeleteInviteCode(code string) (bool, error)
+
+	// UseQuote records the redemption of the quote with the given signature and tells whether it had not been redeemed
+	// before. Quotes which expired before the given time are forgotten.
+	UseQuote(signature string, expires time.Time, now time.Time) (bool, error)
+
+	// GetAuthFailure returns the failed password attempts of a key or nil if there are none. In a transaction the
+	// entry is locked.
+	GetAuthFailure(key string) (*entity.AuthFailure, error)
+	// GetAuthFailures returns the failed password attempts of all keys ordered by key
+	GetAuthFailures() ([]*entity.AuthFailure, error)
+	SaveAuthFailure(f entity.AuthFailure) error
+	// DeleteAuthFailure deletes the failed password attempts of a key, lifting its lockout, and tells whether there
+	// were any
+	DeleteAuthFailure(key string) (bool, error)
+	// DeleteExpiredAuthFailures deletes the entries whose last failure happened before the given time
+	DeleteExpiredAuthFailures(before time.Time) (int64, error)
+}
+
+// Open opens the storage backend of the given name: "sqlite" (default), "postgres" or "memory". The data source name
+// is passed to OpenDatabase. SQL databases are migrated to the latest schema version, the memory storage starts out
+// with the initial data.
+func Open(backend string, dsn string) (Storage, error) {
+	switch backend {
+	case "":
+		backend = BackendSQLite
+	case BackendSQLite, BackendPostgres:
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend '%v'", backend)
+	}
+
+	db, err := NewDatabase(backend, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
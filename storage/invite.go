@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"tradingServer/entity"
+)
+
+func (db *Database) CreateInviteCode(i entity.InviteCode) error {
+	expires := sql.NullInt64{Int64: i.Expires.Unix(), Valid: !i.Expires.IsZero()}
+
+	q := `INSERT INTO invite_codes (code, created_by, created, expires, max_uses, uses) VALUES (?,?,?,?,?,?)`
+	if _, err := db.Exec(q, i.Code, i.CreatedBy, i.Created.Unix(), expires, i.MaxUses, i.Uses); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") || strings.Contains(err.Error(), "duplicate key") {
+			return fmt.Errorf("invite code '%v' exists already", i.Code)
+		}
+		return fmt.Errorf("insert invite code failed: %v", err)
+	}
+	return nil
+}
+
+func (db *Database) GetInviteCodes() ([]*entity.InviteCode, error) {
+	res, err := db.Query(`SELECT code, created_by, created, expires, max_uses, uses FROM invite_codes ORDER BY created, code`)
+	if err != nil {
+		return nil, fmt.Errorf("query invite codes failed: %v", err)
+	}
+	defer res.Close()
+
+	codes := []*entity.InviteCode{}
+	for res.Next() {
+		var i entity.InviteCode
+		var created int64
+		var expires sql.NullInt64
+
+		if err = res.Scan(&i.Code, &i.CreatedBy, &created, &expires, &i.MaxUses, &i.Uses); err != nil {
+			return nil, fmt.Errorf("scan invite code failed: %v", err)
+		}
+
+		i.Created = time.Unix(created, 0)
+		if expires.Valid {
+			i.Expires = time.Unix(expires.Int64, 0)
+		}
+		codes = append(codes, &i)
+	}
+	return codes, res.Err()
+}
+
+func (db *Database) UseInviteCode(code string, now time.Time) (bool, error) {
+	// checking and counting in one statement keeps concurrent registrations from exceeding max_uses
+	q := `UPDATE invite_codes SET uses = uses + 1
+		WHERE code = ? AND (expires IS NULL OR expires > ?) AND (max_uses = 0 OR uses < max_uses)`
+	res, err := db.Exec(q, code, now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("update invite code failed: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (db *Database) DeleteInviteCode(code string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM invite_codes WHERE code = ?`, code)
+	if err != nil {
+		return false, fmt.Errorf("delete invite code failed: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+	"tradingServer/entity"
+)
+
+const authFailureColumns = `auth_key, failures, last_failure, locked_until`
+
+func (db *Database) GetAuthFailure(key string) (*entity.AuthFailure, error) {
+	failures, err := db.queryAuthFailures(`SELECT `+authFailureColumns+` FROM auth_failures WHERE auth_key = ?`+db.forUpdate(), key)
+	if err != nil || len(failures) == 0 {
+		return nil, err
+	}
+	return failures[0], nil
+}
+
+func (db *Database) GetAuthFailures() ([]*entity.AuthFailure, error) {
+	return db.queryAuthFailures(`SELECT ` + authFailureColumns + ` FROM auth_failures ORDER BY auth_key`)
+}
+
+func (db *Database) SaveAuthFailure(f entity.AuthFailure) error {
+	if _, err := db.DeleteAuthFailure(f.Key); err != nil {
+		return err
+	}
+
+	var lockedUntil int64
+	if !f.LockedUntil.IsZero() {
+		lockedUntil = f.LockedUntil.Unix()
+	}
+
+	q := `INSERT INTO auth_failures (` + authFailureColumns + `) VALUES (?,?,?,?)`
+	if _, err := db.Exec(q, f.Key, f.Failures, f.LastFailure.Unix(), lockedUntil); err != nil {
+		return fmt.Errorf("insert auth failure failed: %v", err)
+	}
+	return nil
+}
+
+func (db *Database) DeleteAuthFailure(key string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM auth_failures WHERE auth_key = ?`, key)
+	if err != nil {
+		return false, fmt.Errorf("delete auth failure failed: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (db *Database) DeleteExpiredAuthFailures(before time.Time) (int64, error) {
+	res, err := db.Exec(`DELETE FROM auth_failures WHERE last_failure < ?`, before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("delete expired auth failures failed: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+func (db *Database) queryAuthFailures(q string, args ...interface{}) ([]*entity.AuthFailure, error) {
+	res, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query auth failures failed: %v", err)
+	}
+	defer res.Close()
+
+	failures := []*entity.AuthFailure{}
+	for res.Next() {
+		var f entity.AuthFailure
+		var lastFailure, lockedUntil int64
+		if err = res.Scan(&f.Key, &f.Failures, &lastFailure, &lockedUntil); err != nil {
+			return nil, fmt.Errorf("scan auth failure failed: %v", err)
+		}
+
+		f.LastFailure = time.Unix(lastFailure, 0)
+		if lockedUntil != 0 {
+			f.LockedUntil = time.Unix(lockedUntil, 0)
+		}
+		failures = append(failures, &f)
+	}
+	return failures, res.Err()
+}
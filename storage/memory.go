@@ -0,0 +1,1100 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/shopspring/decimal"
+	"sort"
+	"sync"
+	"time"
+	"tradingServer/entity"
+)
+
+// Memory is a Storage keeping all data in memory, e.g. for tests and throwaway servers. Nothing is persisted.
+// A transaction works on a copy of the data and holds the lock until it is committed or rolled back, so
+// transactions are serialized just like the write transactions of the sqlite database.
+type Memory struct {
+	shared      *memoryShared
+	tx          *memoryData
+	afterCommit []func()
+	requestID   string
+}
+
+type memoryShared struct {
+	sync.Mutex
+	data *memoryData
+}
+
+type memoryData struct {
+	users          map[string]*memoryUser
+	assets         map[string]decimal.Decimal
+	halted         map[string]bool
+	priceHistory   []entity.MarketAsset
+	orders         map[int64]entity.Order
+	lastOrderID    int64
+	sessions       map[string]entity.Session // by access token hash
+	apiKeys        map[int64]entity.APIKey
+	lastAPIKeyID   int64
+	emailChanges   map[string]entity.EmailConfirmation // by login
+	inviteCodes    map[string]entity.InviteCode
+	usedQuotes     map[string]time.Time // quote signature -> expiry
+	authFailures   map[string]entity.AuthFailure
+	accessLog      []AccessLogEntry
+	transactionLog []TransactionLogEntry
+}
+
+type memoryUser struct {
+	password string
+	email    string
+	balance  decimal.Decimal
+	tier     string
+	admin    bool
+	disabled bool
+	totp     entity.TOTP
+	assets   map[string]decimal.Decimal
+}
+
+// testPasswordHash is the bcrypt hash of the password of the initial user "test"
+const testPasswordHash = "$2a$10$rQC.2gYIUvoFG7FVJW/BjeMs4FZPEgneLkFMwWKogIimscvqyUYza"
+
+// NewMemory creates an empty memory storage holding the same initial data as a new sqlite database
+func NewMemory() *Memory {
+	d := &memoryData{
+		users:        make(map[string]*memoryUser),
+		assets:       make(map[string]decimal.Decimal),
+		halted:       make(map[string]bool),
+		orders:       make(map[int64]entity.Order),
+		sessions:     make(map[string]entity.Session),
+		apiKeys:      make(map[int64]entity.APIKey),
+		emailChanges: make(map[string]entity.EmailConfirmation),
+		inviteCodes:  make(map[string]entity.InviteCode),
+		usedQuotes:   make(map[string]time.Time),
+		authFailures: make(map[string]entity.AuthFailure),
+	}
+
+	d.users["test"] = &memoryUser{
+		password: testPasswordHash,
+		balance:  decimal.NewFromInt(100),
+		assets:   make(map[string]decimal.Decimal),
+	}
+
+	d.assets["white_wool"] = decimal.NewFromInt(45)
+	d.assets["black_wool"] = decimal.NewFromInt(42)
+	d.assets["toothpaste"] = decimal.RequireFromString("8.5")
+	d.assets["old_tires"] = decimal.RequireFromString("19.2")
+	d.assets["olive_oil"] = decimal.NewFromInt(127)
+
+	return &Memory{shared: &memoryShared{data: d}}
+}
+
+// clone copies the data so a transaction can modify it without affecting the shared data.
+// Logs and history are only appended to or replaced as a whole, so their backing arrays can be shared.
+func (d *memoryData) clone() *memoryData {
+	c := *d
+
+	c.users = make(map[string]*memoryUser, len(d.users))
+	for login, u := range d.users {
+		u2 := *u
+		u2.totp.RecoveryCodeHashes = append([]string(nil), u.totp.RecoveryCodeHashes...)
+		u2.assets = make(map[string]decimal.Decimal, len(u.assets))
+		for name, amount := range u.assets {
+			u2.assets[name] = amount
+		}
+		c.users[login] = &u2
+	}
+
+	c.assets = make(map[string]decimal.Decimal, len(d.assets))
+	for name, price := range d.assets {
+		c.assets[name] = price
+	}
+
+	c.halted = make(map[string]bool, len(d.halted))
+	for name, halted := range d.halted {
+		c.halted[name] = halted
+	}
+
+	c.orders = make(map[int64]entity.Order, len(d.orders))
+	for id, o := range d.orders {
+		c.orders[id] = o
+	}
+
+	c.sessions = make(map[string]entity.Session, len(d.sessions))
+	for hash, session := range d.sessions {
+		c.sessions[hash] = session
+	}
+
+	c.apiKeys = make(map[int64]entity.APIKey, len(d.apiKeys))
+	for id, k := range d.apiKeys {
+		c.apiKeys[id] = k
+	}
+
+	c.emailChanges = make(map[string]entity.EmailConfirmation, len(d.emailChanges))
+	for login, e := range d.emailChanges {
+		c.emailChanges[login] = e
+	}
+
+	c.inviteCodes = make(map[string]entity.InviteCode, len(d.inviteCodes))
+	for code, i := range d.inviteCodes {
+		c.inviteCodes[code] = i
+	}
+
+	c.usedQuotes = make(map[string]time.Time, len(d.usedQuotes))
+	for signature, expires := range d.usedQuotes {
+		c.usedQuotes[signature] = expires
+	}
+
+	c.authFailures = make(map[string]entity.AuthFailure, len(d.authFailures))
+	for key, f := range d.authFailures {
+		c.authFailures[key] = f
+	}
+
+	return &c
+}
+
+// do runs fn on the transaction's data or, outside of a transaction, on the shared data while holding the lock
+func (m *Memory) do(fn func(d *memoryData) error) error {
+	if m.tx != nil {
+		return fn(m.tx)
+	}
+
+	m.shared.Lock()
+	defer m.shared.Unlock()
+
+	return fn(m.shared.data)
+}
+
+func (m *Memory) Begin() (Storage, error) {
+	if m.tx != nil {
+		return nil, errors.New("transaction in progress already")
+	}
+
+	m.shared.Lock()
+	return &Memory{shared: m.shared, tx: m.shared.data.clone()}, nil
+}
+
+func (m *Memory) Commit() error {
+	if m.tx == nil {
+		return errors.New("no transaction in progress")
+	}
+
+	m.shared.data = m.tx
+	m.tx = nil
+	m.shared.Unlock()
+
+	for _, fn := range m.afterCommit {
+		fn()
+	}
+	m.afterCommit = nil
+
+	return nil
+}
+
+func (m *Memory) Rollback() error {
+	if m.tx == nil {
+		return errors.New("no transaction in progress")
+	}
+
+	m.tx = nil
+	m.afterCommit = nil
+	m.shared.Unlock()
+
+	return nil
+}
+
+func (m *Memory) SetRequestID(id string) {
+	m.requestID = id
+}
+
+func (m *Memory) AfterCommit(fn func()) {
+	if m.tx == nil {
+		fn()
+		return
+	}
+	m.afterCommit = append(m.afterCommit, fn)
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) Ping() error {
+	return nil
+}
+
+func (m *Memory) HoldMarketLock() (bool, error) {
+	return true, nil
+}
+
+func (m *Memory) SchemaVersion() (int, error) {
+	return LatestSchemaVersion(), nil
+}
+
+func (m *Memory) LogAccess(e AccessLogEntry) error {
+	return m.do(func(d *memoryData) error {
+		d.accessLog = append(d.accessLog, e)
+		return nil
+	})
+}
+
+func (m *Memory) LogTransaction(e TransactionLogEntry) error {
+	if e.RequestID == "" {
+		e.RequestID = m.requestID
+	}
+	return m.do(func(d *memoryData) error {
+		d.transactionLog = append(d.transactionLog, e)
+		return nil
+	})
+}
+
+func (m *Memory) GetAccessLog(count int) ([]AccessLogEntry, error) {
+	var entries []AccessLogEntry
+	err := m.do(func(d *memoryData) error {
+		for i := len(d.accessLog) - 1; i >= 0 && (count < 0 || len(entries) < count); i-- {
+			entries = append(entries, d.accessLog[i])
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (m *Memory) GetTransactionLog(count int) ([]TransactionLogEntry, error) {
+	var entries []TransactionLogEntry
+	err := m.do(func(d *memoryData) error {
+		for i := len(d.transactionLog) - 1; i >= 0 && (count < 0 || len(entries) < count); i-- {
+			entries = append(entries, d.transactionLog[i])
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (m *Memory) ClearLogs() (int64, int64, error) {
+	var accessRows, transactionRows int64
+	err := m.do(func(d *memoryData) error {
+		accessRows, transactionRows = int64(len(d.accessLog)), int64(len(d.transactionLog))
+		d.accessLog, d.transactionLog = nil, nil
+		return nil
+	})
+	return accessRows, transactionRows, err
+}
+
+func (m *Memory) GetAssets() ([]entity.MarketAsset, error) {
+	var assets []entity.MarketAsset
+	err := m.do(func(d *memoryData) error {
+		now := time.Now()
+		for name, price := range d.assets {
+			assets = append(assets, entity.MarketAsset{Name: name, Price: price, When: now, Halted: d.halted[name]})
+		}
+		return nil
+	})
+
+	sort.Slice(assets, func(i, j int) bool {
+		return assets[i].Name < assets[j].Name
+	})
+	return assets, err
+}
+
+func (m *Memory) GetAssetPrice(assetName string) (decimal.Decimal, error) {
+	var price decimal.Decimal
+	err := m.do(func(d *memoryData) error {
+		var ok bool
+		if price, ok = d.assets[assetName]; !ok {
+			return fmt.Errorf("no such asset: %v", assetName)
+		}
+		return nil
+	})
+	return price, err
+}
+
+func (m *Memory) SetAssetPrice(assetName string, price decimal.Decimal) error {
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.assets[assetName]; ok {
+			d.assets[assetName] = price
+		}
+
+		// replace a price recorded for the same asset in the same millisecond
+		now := time.UnixMilli(time.Now().UnixMilli())
+		for i := len(d.priceHistory) - 1; i >= 0 && d.priceHistory[i].When.Equal(now); i-- {
+			if d.priceHistory[i].Name == assetName {
+				// entries must not be modified in place, a transaction shares them with the committed data
+				history := append([]entity.MarketAsset(nil), d.priceHistory...)
+				history[i].Price = price
+				d.priceHistory = history
+				return nil
+			}
+		}
+
+		d.priceHistory = append(d.priceHistory, entity.MarketAsset{Name: assetName, Price: price, When: now})
+		return nil
+	})
+}
+
+func (m *Memory) CreateMarketAsset(asset entity.MarketAsset) error {
+	if asset.Price.IsNegative() || asset.Price.IsZero() {
+		return errors.New("invalid price: must be positive")
+	}
+
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.assets[asset.Name]; ok {
+			return errors.New("asset already exists")
+		}
+		d.assets[asset.Name] = asset.Price
+		return nil
+	})
+}
+
+func (m *Memory) GetAsset(name string) (*entity.MarketAsset, error) {
+	var asset *entity.MarketAsset
+	err := m.do(func(d *memoryData) error {
+		if price, ok := d.assets[name]; ok {
+			asset = &entity.MarketAsset{Name: name, Price: price, When: time.Now(), Halted: d.halted[name]}
+		}
+		return nil
+	})
+	return asset, err
+}
+
+func (m *Memory) SetAssetHalted(name string, halted bool) error {
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.assets[name]; !ok {
+			return fmt.Errorf("asset %v not found", name)
+		}
+		if halted {
+			d.halted[name] = true
+		} else {
+			delete(d.halted, name)
+		}
+		return nil
+	})
+}
+
+func (m *Memory) RemoveMarketAsset(name string) error {
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.assets[name]; !ok {
+			return fmt.Errorf("asset %v not found", name)
+		}
+
+		held, orders := 0, 0
+		for _, u := range d.users {
+			if amount, ok := u.assets[name]; ok && !amount.IsZero() {
+				held++
+			}
+		}
+		for _, o := range d.orders {
+			if o.Asset == name {
+				orders++
+			}
+		}
+		if held > 0 || orders > 0 {
+			return fmt.Errorf("asset %v is held by %v users and referenced by %v orders, halt it instead", name, held, orders)
+		}
+
+		for _, u := range d.users {
+			delete(u.assets, name)
+		}
+		history := []entity.MarketAsset{}
+		for _, h := range d.priceHistory {
+			if h.Name != name {
+				history = append(history, h)
+			}
+		}
+		d.priceHistory = history
+		delete(d.assets, name)
+		delete(d.halted, name)
+		return nil
+	})
+}
+
+func (m *Memory) UpdateMarketAsset(name string, price decimal.Decimal) error {
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.assets[name]; !ok {
+			return fmt.Errorf("market_asset %v could not be updated: no such asset", name)
+		}
+		d.assets[name] = price
+		return nil
+	})
+}
+
+func (m *Memory) GetPriceHistory(assetName string, from, to time.Time, limit int) ([]entity.MarketAsset, error) {
+	history := []entity.MarketAsset{}
+	err := m.do(func(d *memoryData) error {
+		for _, p := range d.priceHistory {
+			if (assetName == "" || p.Name == assetName) && !p.When.Before(from) && !p.When.After(to) {
+				history = append(history, p)
+			}
+		}
+		return nil
+	})
+
+	sort.SliceStable(history, func(i, j int) bool {
+		if history[i].When.Equal(history[j].When) {
+			return history[i].Name < history[j].Name
+		}
+		return history[i].When.Before(history[j].When)
+	})
+	if limit >= 0 && len(history) > limit {
+		history = history[:limit]
+	}
+	return history, err
+}
+
+func (m *Memory) DownsamplePriceHistory(before time.Time, bucket time.Duration) (int64, error) {
+	type bucketKey struct {
+		asset string
+		index int64
+	}
+
+	var removed int64
+	err := m.do(func(d *memoryData) error {
+		// the last price of every asset per bucket is kept
+		latest := make(map[bucketKey]time.Time)
+		for _, p := range d.priceHistory {
+			if p.When.Before(before) {
+				key := bucketKey{p.Name, p.When.UnixMilli() / bucket.Milliseconds()}
+				if p.When.After(latest[key]) {
+					latest[key] = p.When
+				}
+			}
+		}
+
+		kept := make([]entity.MarketAsset, 0, len(d.priceHistory))
+		for _, p := range d.priceHistory {
+			key := bucketKey{p.Name, p.When.UnixMilli() / bucket.Milliseconds()}
+			if p.When.Before(before) && !p.When.Equal(latest[key]) {
+				removed++
+				continue
+			}
+			kept = append(kept, p)
+		}
+		d.priceHistory = kept
+		return nil
+	})
+	return removed, err
+}
+
+func (m *Memory) PurgePriceHistory(before time.Time) (int64, error) {
+	var removed int64
+	err := m.do(func(d *memoryData) error {
+		kept := make([]entity.MarketAsset, 0, len(d.priceHistory))
+		for _, p := range d.priceHistory {
+			if p.When.Before(before) {
+				removed++
+				continue
+			}
+			kept = append(kept, p)
+		}
+		d.priceHistory = kept
+		return nil
+	})
+	return removed, err
+}
+
+func (m *Memory) GetAccounts() ([]*entity.PublicAccount, error) {
+	var accList []*entity.PublicAccount
+	err := m.do(func(d *memoryData) error {
+		for login, u := range d.users {
+			accList = append(accList, u.publicAccount(login))
+		}
+		return nil
+	})
+
+	sort.Slice(accList, func(i, j int) bool {
+		if accList[i].Balance.Equal(accList[j].Balance) {
+			return accList[i].Login < accList[j].Login
+		}
+		return accList[i].Balance.GreaterThan(accList[j].Balance)
+	})
+	return accList, err
+}
+
+func (m *Memory) GetAccount(login string) (*entity.Account, error) {
+	var acc *entity.Account
+	err := m.do(func(d *memoryData) error {
+		u, ok := d.users[login]
+		if !ok {
+			return fmt.Errorf("login %v %w", login, ErrNotFound)
+		}
+
+		acc = &entity.Account{
+			PublicAccount: *u.publicAccount(login),
+			Email:         u.email,
+			Tier:          u.tier,
+			Admin:         u.admin,
+			Disabled:      u.disabled,
+			TOTPEnabled:   u.totp.Enabled,
+		}
+		acc.SetPassword(u.password)
+		return nil
+	})
+	return acc, err
+}
+
+// publicAccount returns the user's balance and assets, leaving out assets the user has none of
+func (u *memoryUser) publicAccount(login string) *entity.PublicAccount {
+	acc := &entity.PublicAccount{
+		Login:   login,
+		Balance: u.balance,
+		Assets:  []*entity.UserAsset{},
+	}
+
+	for name, amount := range u.assets {
+		if !amount.IsZero() {
+			acc.Assets = append(acc.Assets, &entity.UserAsset{Name: name, Amount: amount})
+		}
+	}
+	sort.Slice(acc.Assets, func(i, j int) bool {
+		return acc.Assets[i].Name < acc.Assets[j].Name
+	})
+
+	return acc
+}
+
+func (m *Memory) SaveAccount(acc entity.Account) error {
+	return m.do(func(d *memoryData) error {
+		u, ok := d.users[acc.Login]
+		if !ok {
+			return fmt.Errorf("update balance for user %v failed: no such user", acc.Login)
+		}
+
+		u.balance = acc.Balance
+		for _, ass := range acc.Assets {
+			u.assets[ass.Name] = ass.Amount
+		}
+		return nil
+	})
+}
+
+func (m *Memory) AddAccount(login string, password string, email string, balance decimal.Decimal) error {
+	acc := entity.Account{Email: email}
+	if password != "" {
+		if err := acc.SetAndHashPassword(password); err != nil {
+			return err
+		}
+	}
+
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.users[login]; ok {
+			return errors.New("account exists already")
+		}
+
+		d.users[login] = &memoryUser{
+			password: acc.GetPassword(),
+			email:    acc.Email,
+			balance:  balance,
+			assets:   make(map[string]decimal.Decimal),
+		}
+		return nil
+	})
+}
+
+func (m *Memory) UpdateAccount(login, password, email string) error {
+	if password == "" && email == "" {
+		return errors.New("either password or email have to be set")
+	}
+
+	acc := entity.Account{}
+	if password != "" {
+		if err := acc.SetAndHashPassword(password); err != nil {
+			return err
+		}
+	}
+
+	return m.do(func(d *memoryData) error {
+		u, ok := d.users[login]
+		if !ok {
+			return fmt.Errorf("login %v not found", login)
+		}
+
+		if email != "" {
+			u.email = email
+		}
+		if password != "" {
+			u.password = acc.GetPassword()
+		}
+		return nil
+	})
+}
+
+func (m *Memory) SetAccountTier(login string, tier string) error {
+	return m.do(func(d *memoryData) error {
+		u, ok := d.users[login]
+		if !ok {
+			return fmt.Errorf("login %v not found", login)
+		}
+
+		u.tier = tier
+		return nil
+	})
+}
+
+func (m *Memory) SetAccountAdmin(login string, admin bool) error {
+	return m.do(func(d *memoryData) error {
+		u, ok := d.users[login]
+		if !ok {
+			return fmt.Errorf("login %v not found", login)
+		}
+
+		u.admin = admin
+		return nil
+	})
+}
+
+func (m *Memory) SetAccountDisabled(login string, disabled bool) error {
+	return m.do(func(d *memoryData) error {
+		u, ok := d.users[login]
+		if !ok {
+			return fmt.Errorf("login %v not found", login)
+		}
+
+		u.disabled = disabled
+		return nil
+	})
+}
+
+func (m *Memory) GetTOTP(login string) (*entity.TOTP, error) {
+	var totp *entity.TOTP
+	err := m.do(func(d *memoryData) error {
+		u, ok := d.users[login]
+		if !ok {
+			return fmt.Errorf("login %v not found", login)
+		}
+
+		t := u.totp
+		t.RecoveryCodeHashes = append([]string{}, u.totp.RecoveryCodeHashes...)
+		totp = &t
+		return nil
+	})
+	return totp, err
+}
+
+func (m *Memory) SaveTOTP(login string, t entity.TOTP) error {
+	return m.do(func(d *memoryData) error {
+		u, ok := d.users[login]
+		if !ok {
+			return fmt.Errorf("login %v not found", login)
+		}
+
+		t.RecoveryCodeHashes = append([]string(nil), t.RecoveryCodeHashes...)
+		u.totp = t
+		return nil
+	})
+}
+
+func (m *Memory) RemoveAccount(account *entity.PublicAccount) error {
+	if account == nil || account.Login == "" {
+		return fmt.Errorf("invalid account to be deleted: %v", account)
+	}
+
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.users[account.Login]; !ok {
+			return fmt.Errorf("account %v has not been deleted: no such account", account.Login)
+		}
+
+		for id, o := range d.orders {
+			if o.Login == account.Login {
+				delete(d.orders, id)
+			}
+		}
+		for hash, session := range d.sessions {
+			if session.Login == account.Login {
+				delete(d.sessions, hash)
+			}
+		}
+		for id, k := range d.apiKeys {
+			if k.Login == account.Login {
+				delete(d.apiKeys, id)
+			}
+		}
+		delete(d.emailChanges, account.Login)
+		delete(d.users, account.Login)
+		return nil
+	})
+}
+
+func (m *Memory) CreateOrder(o *entity.Order) error {
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.users[o.Login]; !ok {
+			return fmt.Errorf("insert order failed: no such user %v", o.Login)
+		}
+
+		d.lastOrderID++
+		o.ID = d.lastOrderID
+		d.orders[o.ID] = *o
+		return nil
+	})
+}
+
+func (m *Memory) UpdateOrder(o entity.Order) error {
+	return m.do(func(d *memoryData) error {
+		stored, ok := d.orders[o.ID]
+		if !ok {
+			return fmt.Errorf("order %v has not been updated: no such order", o.ID)
+		}
+
+		stored.Status = o.Status
+		stored.Updated = o.Updated
+		stored.FillPrice = o.FillPrice
+		d.orders[o.ID] = stored
+		return nil
+	})
+}
+
+func (m *Memory) GetOrder(id int64) (*entity.Order, error) {
+	var order *entity.Order
+	err := m.do(func(d *memoryData) error {
+		if o, ok := d.orders[id]; ok {
+			order = &o
+		}
+		return nil
+	})
+	return order, err
+}
+
+func (m *Memory) GetOrders(login string, openOnly bool) ([]*entity.Order, error) {
+	orders, err := m.queryOrders(func(o entity.Order) bool {
+		return o.Login == login && (!openOnly || o.Status == entity.OrderStatusOpen)
+	})
+
+	// newest first
+	for i, j := 0, len(orders)-1; i < j; i, j = i+1, j-1 {
+		orders[i], orders[j] = orders[j], orders[i]
+	}
+	return orders, err
+}
+
+func (m *Memory) GetOpenOrders() ([]*entity.Order, error) {
+	return m.queryOrders(func(o entity.Order) bool {
+		return o.Status == entity.OrderStatusOpen
+	})
+}
+
+// queryOrders returns the orders selected by the filter, oldest first
+func (m *Memory) queryOrders(filter func(o entity.Order) bool) ([]*entity.Order, error) {
+	orders := []*entity.Order{}
+	err := m.do(func(d *memoryData) error {
+		for _, o := range d.orders {
+			if filter(o) {
+				o := o
+				orders = append(orders, &o)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].ID < orders[j].ID
+	})
+	return orders, err
+}
+
+func (m *Memory) CreateSession(s entity.Session) error {
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.users[s.Login]; !ok {
+			return fmt.Errorf("insert session failed: no such user %v", s.Login)
+		}
+		if _, ok := d.sessions[s.AccessTokenHash]; ok {
+			return errors.New("insert session failed: duplicate access token")
+		}
+
+		d.sessions[s.AccessTokenHash] = s
+		return nil
+	})
+}
+
+func (m *Memory) GetSession(accessTokenHash string) (*entity.Session, error) {
+	var session *entity.Session
+	err := m.do(func(d *memoryData) error {
+		if s, ok := d.sessions[accessTokenHash]; ok {
+			session = &s
+		}
+		return nil
+	})
+	return session, err
+}
+
+func (m *Memory) GetSessionByRefreshToken(refreshTokenHash string) (*entity.Session, error) {
+	var session *entity.Session
+	err := m.do(func(d *memoryData) error {
+		for _, s := range d.sessions {
+			if s.RefreshTokenHash == refreshTokenHash {
+				s := s
+				session = &s
+				break
+			}
+		}
+		return nil
+	})
+	return session, err
+}
+
+func (m *Memory) DeleteSession(accessTokenHash string) error {
+	return m.do(func(d *memoryData) error {
+		delete(d.sessions, accessTokenHash)
+		return nil
+	})
+}
+
+func (m *Memory) DeleteExpiredSessions(before time.Time) (int64, error) {
+	var n int64
+	err := m.do(func(d *memoryData) error {
+		for hash, s := range d.sessions {
+			if s.RefreshExpires.Before(before) {
+				delete(d.sessions, hash)
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (m *Memory) DeleteSessions(login string, exceptAccessTokenHash string) error {
+	return m.do(func(d *memoryData) error {
+		for hash, s := range d.sessions {
+			if s.Login == login && hash != exceptAccessTokenHash {
+				delete(d.sessions, hash)
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Memory) CreateAPIKey(k *entity.APIKey) error {
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.users[k.Login]; !ok {
+			return fmt.Errorf("insert api key failed: no such user %v", k.Login)
+		}
+		for _, existing := range d.apiKeys {
+			if existing.Login == k.Login && existing.Name == k.Name {
+				return fmt.Errorf("api key '%v' exists already", k.Name)
+			}
+		}
+
+		d.lastAPIKeyID++
+		k.ID = d.lastAPIKeyID
+		stored := *k
+		stored.Key = ""
+		d.apiKeys[k.ID] = stored
+		return nil
+	})
+}
+
+func (m *Memory) GetAPIKeyByHash(hash string) (*entity.APIKey, error) {
+	var key *entity.APIKey
+	err := m.do(func(d *memoryData) error {
+		for _, k := range d.apiKeys {
+			if k.Hash == hash {
+				k := k
+				key = &k
+				break
+			}
+		}
+		return nil
+	})
+	return key, err
+}
+
+func (m *Memory) GetAPIKeys(login string) ([]*entity.APIKey, error) {
+	keys := []*entity.APIKey{}
+	err := m.do(func(d *memoryData) error {
+		for _, k := range d.apiKeys {
+			if k.Login == login {
+				k := k
+				keys = append(keys, &k)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, err
+}
+
+func (m *Memory) DeleteAPIKey(login string, id int64) (bool, error) {
+	found := false
+	err := m.do(func(d *memoryData) error {
+		if k, ok := d.apiKeys[id]; ok && k.Login == login {
+			delete(d.apiKeys, id)
+			found = true
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (m *Memory) CreateEmailConfirmation(e entity.EmailConfirmation) error {
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.users[e.Login]; !ok {
+			return fmt.Errorf("insert email confirmation failed: no such user %v", e.Login)
+		}
+
+		d.emailChanges[e.Login] = e
+		return nil
+	})
+}
+
+func (m *Memory) GetEmailConfirmation(tokenHash string) (*entity.EmailConfirmation, error) {
+	var confirmation *entity.EmailConfirmation
+	err := m.do(func(d *memoryData) error {
+		for _, e := range d.emailChanges {
+			if e.TokenHash == tokenHash {
+				e := e
+				confirmation = &e
+				break
+			}
+		}
+		return nil
+	})
+	return confirmation, err
+}
+
+func (m *Memory) DeleteEmailConfirmation(login string) error {
+	return m.do(func(d *memoryData) error {
+		delete(d.emailChanges, login)
+		return nil
+	})
+}
+
+func (m *Memory) CreateInviteCode(i entity.InviteCode) error {
+	return m.do(func(d *memoryData) error {
+		if _, ok := d.inviteCodes[i.Code]; ok {
+			return fmt.Errorf("invite code '%v' exists already", i.Code)
+		}
+
+		// times are stored with a precision of seconds like in the database
+		i.Created = time.Unix(i.Created.Unix(), 0)
+		if !i.Expires.IsZero() {
+			i.Expires = time.Unix(i.Expires.Unix(), 0)
+		}
+		d.inviteCodes[i.Code] = i
+		return nil
+	})
+}
+
+func (m *Memory) GetInviteCodes() ([]*entity.InviteCode, error) {
+	codes := []*entity.InviteCode{}
+	err := m.do(func(d *memoryData) error {
+		for _, i := range d.inviteCodes {
+			i := i
+			codes = append(codes, &i)
+		}
+		return nil
+	})
+
+	sort.Slice(codes, func(i, j int) bool {
+		if !codes[i].Created.Equal(codes[j].Created) {
+			return codes[i].Created.Before(codes[j].Created)
+		}
+		return codes[i].Code < codes[j].Code
+	})
+	return codes, err
+}
+
+func (m *Memory) UseInviteCode(code string, now time.Time) (bool, error) {
+	used := false
+	err := m.do(func(d *memoryData) error {
+		if i, ok := d.inviteCodes[code]; ok && i.Valid(now) {
+			i.Uses++
+			d.inviteCodes[code] = i
+			used = true
+		}
+		return nil
+	})
+	return used, err
+}
+
+func (m *Memory) DeleteInviteCode(code string) (bool, error) {
+	found := false
+	err := m.do(func(d *memoryData) error {
+		if _, ok := d.inviteCodes[code]; ok {
+			delete(d.inviteCodes, code)
+			found = true
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (m *Memory) UseQuote(signature string, expires time.Time, now time.Time) (bool, error) {
+	used := false
+	err := m.do(func(d *memoryData) error {
+		for s, e := range d.usedQuotes {
+			if e.Before(now) {
+				delete(d.usedQuotes, s)
+			}
+		}
+		if _, ok := d.usedQuotes[signature]; !ok {
+			d.usedQuotes[signature] = expires
+			used = true
+		}
+		return nil
+	})
+	return used, err
+}
+
+func (m *Memory) GetAuthFailure(key string) (*entity.AuthFailure, error) {
+	var failure *entity.AuthFailure
+	err := m.do(func(d *memoryData) error {
+		if f, ok := d.authFailures[key]; ok {
+			failure = &f
+		}
+		return nil
+	})
+	return failure, err
+}
+
+func (m *Memory) GetAuthFailures() ([]*entity.AuthFailure, error) {
+	failures := []*entity.AuthFailure{}
+	err := m.do(func(d *memoryData) error {
+		for _, f := range d.authFailures {
+			f := f
+			failures = append(failures, &f)
+		}
+		return nil
+	})
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Key < failures[j].Key
+	})
+	return failures, err
+}
+
+func (m *Memory) SaveAuthFailure(f entity.AuthFailure) error {
+	return m.do(func(d *memoryData) error {
+		// times are stored with a precision of seconds like in the database
+		f.LastFailure = time.Unix(f.LastFailure.Unix(), 0)
+		if !f.LockedUntil.IsZero() {
+			f.LockedUntil = time.Unix(f.LockedUntil.Unix(), 0)
+		}
+		d.authFailures[f.Key] = f
+		return nil
+	})
+}
+
+func (m *Memory) DeleteAuthFailure(key string) (bool, error) {
+	found := false
+	err := m.do(func(d *memoryData) error {
+		if _, ok := d.authFailures[key]; ok {
+			delete(d.authFailures, key)
+			found = true
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (m *Memory) DeleteExpiredAuthFailures(before time.Time) (int64, error) {
+	var n int64
+	err := m.do(func(d *memoryData) error {
+		for key, f := range d.authFailures {
+			if f.LastFailure.Unix() < before.Unix() {
+				delete(d.authFailures, key)
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
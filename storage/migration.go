@@ -0,0 +1,720 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+	"tradingServer/entity"
+)
+
+// Migration changes the database schema from the previous version to Version.
+// Migrations are idempotent, so databases created before schema versioning are brought up to date as well.
+// Each backend has its own implementation, PostgreSQL ones use $1, $2, ... placeholders.
+type Migration struct {
+	Version      int
+	Description  string
+	up           func(tx *sql.Tx) error
+	down         func(tx *sql.Tx) error
+	postgresUp   func(tx *sql.Tx) error
+	postgresDown func(tx *sql.Tx) error
+}
+
+// MigrationStatus tells whether a migration has been applied to the database
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt string
+}
+
+// schemas of the tables as created by the migrations introducing them. %v is replaced by the table name.
+const (
+	usersSchemaV1 = `CREATE TABLE %v (login varchar(64) PRIMARY KEY, password varchar(255) NOT NULL, email varchar(255), balance real NOT NULL)`
+
+	marketAssetsSchemaV1 = `CREATE TABLE %v (name varchar(64) PRIMARY KEY, price real NOT NULL)`
+
+	userAssetsSchemaV1 = `CREATE TABLE %v (
+	login varchar(64),
+	asset varchar(255),
+	amount real NOT NULL,
+	PRIMARY KEY (login, asset),
+	FOREIGN KEY (login) REFERENCES users (login),
+	FOREIGN KEY (asset) REFERENCES market_assets (name)
+)`
+
+	transactionLogSchemaV1 = `CREATE TABLE %v (
+	time VARCHAR(64),
+	login VARCHAR(64),
+	action VARCHAR(64),
+	unit_price REAL,
+	payed_price REAL,
+	amount REAL,
+	asset VARCHAR(64),
+	balance REAL
+)`
+
+	accessLogSchemaV1 = `CREATE TABLE %v (
+	time VARCHAR(64),
+	duration REAL,
+	login VARCHAR(64),
+	path VARCHAR(255),
+	status INT,
+	address VARCHAR(255)
+)`
+
+	priceHistorySchemaV2 = `CREATE TABLE %v (
+	time INT,
+	asset VARCHAR(255),
+	price REAL,
+	PRIMARY KEY (time, asset),
+	FOREIGN KEY (asset) REFERENCES market_assets (name)
+)`
+
+	ordersSchemaV3 = `CREATE TABLE %v (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login VARCHAR(64) NOT NULL,
+	type VARCHAR(16) NOT NULL,
+	side VARCHAR(8) NOT NULL,
+	asset VARCHAR(64) NOT NULL,
+	amount REAL NOT NULL,
+	price REAL NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	created VARCHAR(64),
+	updated VARCHAR(64),
+	fill_price REAL,
+	FOREIGN KEY (login) REFERENCES users (login),
+	FOREIGN KEY (asset) REFERENCES market_assets (name)
+)`
+
+	sessionsSchemaV6 = `CREATE TABLE %v (
+	access_hash VARCHAR(64) PRIMARY KEY,
+	refresh_hash VARCHAR(64) NOT NULL UNIQUE,
+	login VARCHAR(64) NOT NULL,
+	created INT NOT NULL,
+	access_expires INT NOT NULL,
+	refresh_expires INT NOT NULL,
+	FOREIGN KEY (login) REFERENCES users (login)
+)`
+
+	apiKeysSchemaV7 = `CREATE TABLE %v (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	login VARCHAR(64) NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	key_hash VARCHAR(64) NOT NULL UNIQUE,
+	prefix VARCHAR(16) NOT NULL,
+	scopes VARCHAR(255) NOT NULL,
+	allowed_ips VARCHAR(1024) NOT NULL,
+	created INT NOT NULL,
+	expires INT,
+	UNIQUE (login, name),
+	FOREIGN KEY (login) REFERENCES users (login)
+)`
+
+	emailConfirmationsSchemaV9 = `CREATE TABLE %v (
+	login VARCHAR(64) PRIMARY KEY,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	email VARCHAR(255) NOT NULL,
+	created INT NOT NULL,
+	expires INT NOT NULL,
+	FOREIGN KEY (login) REFERENCES users (login)
+)`
+
+	inviteCodesSchemaV10 = `CREATE TABLE %v (
+	code VARCHAR(32) PRIMARY KEY,
+	created_by VARCHAR(64) NOT NULL,
+	created INT NOT NULL,
+	expires INT,
+	max_uses INT NOT NULL,
+	uses INT NOT NULL DEFAULT 0
+)`
+
+	authFailuresSchemaV11 = `CREATE TABLE %v (
+	auth_key VARCHAR(128) PRIMARY KEY,
+	failures INT NOT NULL,
+	last_failure INT NOT NULL,
+	locked_until INT NOT NULL
+)`
+
+	usedQuotesSchemaV14 = `CREATE TABLE %v (
+	signature VARCHAR(64) PRIMARY KEY,
+	expires INT NOT NULL
+)`
+)
+
+// migrations lists all schema changes in order. Append new migrations, never change applied ones.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		up: func(tx *sql.Tx) error {
+			created, err := createTableIfMissing(tx, "users", usersSchemaV1)
+			if err != nil {
+				return err
+			}
+			if created {
+				hash, err := entity.HashEncodePassword("test")
+				if err != nil {
+					return err
+				}
+				q := "INSERT INTO users (login,password,balance) VALUES ('test',?,?)"
+				if _, err = tx.Exec(q, hash, 100); err != nil {
+					return err
+				}
+			}
+
+			created, err = createTableIfMissing(tx, "market_assets", marketAssetsSchemaV1)
+			if err != nil {
+				return err
+			}
+			if created {
+				q := `INSERT INTO market_assets (name, price) VALUES 
+			('white_wool',45),
+			('black_wool',42),
+			('toothpaste',8.5),
+			('old_tires',19.2),
+			('olive_oil',127)`
+				if _, err = tx.Exec(q); err != nil {
+					return err
+				}
+			}
+
+			if _, err = createTableIfMissing(tx, "user_assets", userAssetsSchemaV1); err != nil {
+				return err
+			}
+			if _, err = createTableIfMissing(tx, "transaction_log", transactionLogSchemaV1); err != nil {
+				return err
+			}
+			_, err = createTableIfMissing(tx, "access_log", accessLogSchemaV1)
+			return err
+		},
+		down: func(tx *sql.Tx) error {
+			return dropTables(tx, "access_log", "transaction_log", "user_assets", "market_assets", "users")
+		},
+		postgresUp: postgresInitialSchema,
+		postgresDown: func(tx *sql.Tx) error {
+			return dropTables(tx, "access_log", "transaction_log", "user_assets", "market_assets", "users")
+		},
+	},
+	{
+		Version:     2,
+		Description: "price history",
+		up: func(tx *sql.Tx) error {
+			_, err := createTableIfMissing(tx, "price_history", priceHistorySchemaV2)
+			return err
+		},
+		down: func(tx *sql.Tx) error {
+			return dropTables(tx, "price_history")
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			_, err := createPostgresTableIfMissing(tx, "price_history", postgresPriceHistorySchemaV2)
+			return err
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			return dropTables(tx, "price_history")
+		},
+	},
+	{
+		Version:     3,
+		Description: "order book",
+		up: func(tx *sql.Tx) error {
+			_, err := createTableIfMissing(tx, "orders", ordersSchemaV3)
+			return err
+		},
+		down: func(tx *sql.Tx) error {
+			return dropTables(tx, "orders")
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			_, err := createPostgresTableIfMissing(tx, "orders", postgresOrdersSchemaV3)
+			return err
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			return dropTables(tx, "orders")
+		},
+	},
+	{
+		Version:     4,
+		Description: "exact decimal columns",
+		up:          convertDecimalColumns,
+		down:        revertDecimalColumns,
+		// PostgreSQL tables have been created with exact NUMERIC columns
+		postgresUp:   noChange,
+		postgresDown: noChange,
+	},
+	{
+		Version:      5,
+		Description:  "user tiers",
+		up:           addColumn("users", "tier", "VARCHAR(32) NOT NULL DEFAULT ''"),
+		down:         dropColumn("users", "tier"),
+		postgresUp:   postgresAddColumn("users", "tier", "VARCHAR(32) NOT NULL DEFAULT ''"),
+		postgresDown: postgresDropColumn("users", "tier"),
+	},
+	{
+		Version:     6,
+		Description: "sessions",
+		up: func(tx *sql.Tx) error {
+			_, err := createTableIfMissing(tx, "sessions", sessionsSchemaV6)
+			return err
+		},
+		down: func(tx *sql.Tx) error {
+			return dropTables(tx, "sessions")
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			_, err := createPostgresTableIfMissing(tx, "sessions", postgresSessionsSchemaV6)
+			return err
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			return dropTables(tx, "sessions")
+		},
+	},
+	{
+		Version:     7,
+		Description: "api keys",
+		up: func(tx *sql.Tx) error {
+			if _, err := createTableIfMissing(tx, "api_keys", apiKeysSchemaV7); err != nil {
+				return err
+			}
+			return addColumn("access_log", "api_key", "INT")(tx)
+		},
+		down: func(tx *sql.Tx) error {
+			if err := dropColumn("access_log", "api_key")(tx); err != nil {
+				return err
+			}
+			return dropTables(tx, "api_keys")
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			if _, err := createPostgresTableIfMissing(tx, "api_keys", postgresAPIKeysSchemaV7); err != nil {
+				return err
+			}
+			return postgresAddColumn("access_log", "api_key", "BIGINT")(tx)
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			if err := postgresDropColumn("access_log", "api_key")(tx); err != nil {
+				return err
+			}
+			return dropTables(tx, "api_keys")
+		},
+	},
+	{
+		Version:     8,
+		Description: "admin role, disabled users and halted assets",
+		up: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				addColumn("users", "admin", "BOOLEAN NOT NULL DEFAULT 0"),
+				addColumn("users", "disabled", "BOOLEAN NOT NULL DEFAULT 0"),
+				addColumn("market_assets", "halted", "BOOLEAN NOT NULL DEFAULT 0"))
+		},
+		down: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				dropColumn("users", "admin"),
+				dropColumn("users", "disabled"),
+				dropColumn("market_assets", "halted"))
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				postgresAddColumn("users", "admin", "BOOLEAN NOT NULL DEFAULT FALSE"),
+				postgresAddColumn("users", "disabled", "BOOLEAN NOT NULL DEFAULT FALSE"),
+				postgresAddColumn("market_assets", "halted", "BOOLEAN NOT NULL DEFAULT FALSE"))
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				postgresDropColumn("users", "admin"),
+				postgresDropColumn("users", "disabled"),
+				postgresDropColumn("market_assets", "halted"))
+		},
+	},
+	{
+		Version:     9,
+		Description: "email confirmations",
+		up: func(tx *sql.Tx) error {
+			_, err := createTableIfMissing(tx, "email_confirmations", emailConfirmationsSchemaV9)
+			return err
+		},
+		down: func(tx *sql.Tx) error {
+			return dropTables(tx, "email_confirmations")
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			_, err := createPostgresTableIfMissing(tx, "email_confirmations", postgresEmailConfirmationsSchemaV9)
+			return err
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			return dropTables(tx, "email_confirmations")
+		},
+	},
+	{
+		Version:     10,
+		Description: "invite codes",
+		up: func(tx *sql.Tx) error {
+			_, err := createTableIfMissing(tx, "invite_codes", inviteCodesSchemaV10)
+			return err
+		},
+		down: func(tx *sql.Tx) error {
+			return dropTables(tx, "invite_codes")
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			_, err := createPostgresTableIfMissing(tx, "invite_codes", postgresInviteCodesSchemaV10)
+			return err
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			return dropTables(tx, "invite_codes")
+		},
+	},
+	{
+		Version:     11,
+		Description: "auth failures and lockout events",
+		up: func(tx *sql.Tx) error {
+			if _, err := createTableIfMissing(tx, "auth_failures", authFailuresSchemaV11); err != nil {
+				return err
+			}
+			return addColumn("access_log", "event", "VARCHAR(32)")(tx)
+		},
+		down: func(tx *sql.Tx) error {
+			if err := dropColumn("access_log", "event")(tx); err != nil {
+				return err
+			}
+			return dropTables(tx, "auth_failures")
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			if _, err := createPostgresTableIfMissing(tx, "auth_failures", postgresAuthFailuresSchemaV11); err != nil {
+				return err
+			}
+			return postgresAddColumn("access_log", "event", "VARCHAR(32)")(tx)
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			if err := postgresDropColumn("access_log", "event")(tx); err != nil {
+				return err
+			}
+			return dropTables(tx, "auth_failures")
+		},
+	},
+	{
+		Version:     12,
+		Description: "two-factor authentication",
+		up: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				addColumn("users", "totp_secret", "VARCHAR(64) NOT NULL DEFAULT ''"),
+				addColumn("users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT 0"),
+				addColumn("users", "totp_recovery", "VARCHAR(1024) NOT NULL DEFAULT ''"),
+				addColumn("users", "totp_last_step", "INT NOT NULL DEFAULT 0"))
+		},
+		down: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				dropColumn("users", "totp_secret"),
+				dropColumn("users", "totp_enabled"),
+				dropColumn("users", "totp_recovery"),
+				dropColumn("users", "totp_last_step"))
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				postgresAddColumn("users", "totp_secret", "VARCHAR(64) NOT NULL DEFAULT ''"),
+				postgresAddColumn("users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT FALSE"),
+				postgresAddColumn("users", "totp_recovery", "VARCHAR(1024) NOT NULL DEFAULT ''"),
+				postgresAddColumn("users", "totp_last_step", "BIGINT NOT NULL DEFAULT 0"))
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				postgresDropColumn("users", "totp_secret"),
+				postgresDropColumn("users", "totp_enabled"),
+				postgresDropColumn("users", "totp_recovery"),
+				postgresDropColumn("users", "totp_last_step"))
+		},
+	},
+	{
+		Version:     13,
+		Description: "request ids",
+		up: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				addColumn("access_log", "request_id", "VARCHAR(64)"),
+				addColumn("transaction_log", "request_id", "VARCHAR(64)"))
+		},
+		down: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				dropColumn("access_log", "request_id"),
+				dropColumn("transaction_log", "request_id"))
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				postgresAddColumn("access_log", "request_id", "VARCHAR(64)"),
+				postgresAddColumn("transaction_log", "request_id", "VARCHAR(64)"))
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			return applyAll(tx,
+				postgresDropColumn("access_log", "request_id"),
+				postgresDropColumn("transaction_log", "request_id"))
+		},
+	},
+	{
+		Version:     14,
+		Description: "used quotes",
+		up: func(tx *sql.Tx) error {
+			_, err := createTableIfMissing(tx, "used_quotes", usedQuotesSchemaV14)
+			return err
+		},
+		down: func(tx *sql.Tx) error {
+			return dropTables(tx, "used_quotes")
+		},
+		postgresUp: func(tx *sql.Tx) error {
+			_, err := createPostgresTableIfMissing(tx, "used_quotes", postgresUsedQuotesSchemaV14)
+			return err
+		},
+		postgresDown: func(tx *sql.Tx) error {
+			return dropTables(tx, "used_quotes")
+		},
+	},
+}
+
+// LatestSchemaVersion returns the schema version the server expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the last migration applied to the database, 0 if there is none
+func (db *Database) SchemaVersion() (int, error) {
+	if err := db.createSchemaVersionTable(); err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	err := db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("query schema version failed: %v", err)
+	}
+
+	return int(version.Int64), nil
+}
+
+// MigrationStatus lists all known migrations and whether they have been applied
+func (db *Database) MigrationStatus() ([]MigrationStatus, error) {
+	if err := db.createSchemaVersionTable(); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]string)
+	res, err := db.Query(`SELECT version, applied FROM schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("query schema version failed: %v", err)
+	}
+	defer res.Close()
+
+	for res.Next() {
+		var version int
+		var appliedAt string
+		if err = res.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scan schema version failed: %v", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	var status []MigrationStatus
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		status = append(status, MigrationStatus{Migration: m, Applied: ok, AppliedAt: appliedAt})
+	}
+
+	return status, nil
+}
+
+// MigrateUp applies all pending migrations in order and returns them.
+// With dryRun set the migrations are executed but rolled back in the end.
+func (db *Database) MigrateUp(dryRun bool) ([]Migration, error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending, db.runMigrations(pending, true, dryRun)
+}
+
+// MigrateDown reverts all applied migrations above the target version, newest first, and returns them.
+// With dryRun set the migrations are executed but rolled back in the end.
+func (db *Database) MigrateDown(target int, dryRun bool) ([]Migration, error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if target < 0 || target > version {
+		return nil, fmt.Errorf("invalid target version %v, the database is at version %v", target, version)
+	}
+
+	var reverted []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if migrations[i].Version > target && migrations[i].Version <= version {
+			reverted = append(reverted, migrations[i])
+		}
+	}
+
+	return reverted, db.runMigrations(reverted, false, dryRun)
+}
+
+// runMigrations runs the given migrations in one transaction. Migrations applied meanwhile by another server
+// instance sharing the database are skipped.
+func (db *Database) runMigrations(list []Migration, up bool, dryRun bool) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if db.backend == BackendSQLite {
+		// the foreign keys setting is per connection and can not be changed within a transaction.
+		// Foreign key checks are deferred until all migrations have been run, so tables can be rebuilt.
+		if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if db.backend == BackendPostgres {
+		if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range list {
+		var applied int
+		err = tx.QueryRow(db.rebind(`SELECT COUNT(*) FROM schema_version WHERE version = ?`), m.Version).Scan(&applied)
+		if err != nil {
+			return err
+		}
+		if (applied > 0) == up {
+			continue
+		}
+
+		if up {
+			err = db.migrationFunc(m.up, m.postgresUp)(tx)
+			if err == nil {
+				_, err = tx.Exec(db.rebind(`INSERT INTO schema_version (version, description, applied) VALUES (?,?,?)`),
+					m.Version, m.Description, time.Now().Format(time.RFC3339))
+			}
+		} else {
+			err = db.migrationFunc(m.down, m.postgresDown)(tx)
+			if err == nil {
+				_, err = tx.Exec(db.rebind(`DELETE FROM schema_version WHERE version = ?`), m.Version)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("migration %v (%v) failed: %v", m.Version, m.Description, err)
+		}
+	}
+
+	if db.backend == BackendSQLite {
+		res, err := tx.Query(`PRAGMA foreign_key_check`)
+		if err != nil {
+			return err
+		}
+		violation := res.Next()
+		res.Close()
+		if violation {
+			return errors.New("migrations violate foreign key constraints")
+		}
+	}
+
+	if dryRun {
+		return nil
+	}
+	return tx.Commit()
+}
+
+// migrationFunc selects the implementation of a migration for the database's backend
+func (db *Database) migrationFunc(sqlite, postgres func(tx *sql.Tx) error) func(tx *sql.Tx) error {
+	if db.backend == BackendPostgres {
+		return postgres
+	}
+	return sqlite
+}
+
+// applyAll runs several migration steps in order
+func applyAll(tx *sql.Tx, steps ...func(tx *sql.Tx) error) error {
+	for _, step := range steps {
+		if err := step(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func noChange(tx *sql.Tx) error {
+	return nil
+}
+
+func (db *Database) createSchemaVersionTable() error {
+	q := `CREATE TABLE IF NOT EXISTS schema_version (version INT PRIMARY KEY, description VARCHAR(255), applied VARCHAR(64))`
+	if _, err := db.Exec(q); err != nil {
+		return fmt.Errorf("could not create schema_version table: %v", err)
+	}
+	return nil
+}
+
+// createTableIfMissing creates a table from a schema unless it exists already and tells whether it has been created
+func createTableIfMissing(tx *sql.Tx, table string, schema string) (bool, error) {
+	var n int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n)
+	if err != nil || n > 0 {
+		return false, err
+	}
+
+	if _, err = tx.Exec(fmt.Sprintf(schema, table)); err != nil {
+		return false, fmt.Errorf("could not create table %v: %v", table, err)
+	}
+	return true, nil
+}
+
+func dropTables(tx *sql.Tx, tables ...string) error {
+	for _, table := range tables {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return fmt.Errorf("could not drop table %v: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// addColumn returns a migration adding a column to a table unless it exists already
+func addColumn(table string, column string, definition string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		exists, err := hasColumn(tx, table, column)
+		if err != nil || exists {
+			return err
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", table, column, definition))
+		return err
+	}
+}
+
+// dropColumn returns a migration dropping a column of a table if it exists
+func dropColumn(table string, column string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		exists, err := hasColumn(tx, table, column)
+		if err != nil || !exists {
+			return err
+		}
+
+		_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %v DROP COLUMN %v", table, column))
+		return err
+	}
+}
+
+func hasColumn(tx *sql.Tx, table string, column string) (bool, error) {
+	var n int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	return n > 0, err
+}
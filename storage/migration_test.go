@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDatabase opens a new sqlite database in a temporary directory migrated up to the given schema version
+func openTestDatabase(t *testing.T, version int) *Database {
+	t.Helper()
+
+	db, err := OpenDatabase(BackendSQLite, filepath.Join(t.TempDir(), dbFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err = db.createSchemaVersionTable(); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.runMigrations(migrations[:version], true, false); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// tables returns the names of all tables except the schema_version table and the internal ones of sqlite
+func tables(t *testing.T, db *Database) map[string]bool {
+	t.Helper()
+
+	res, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_version'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	names := make(map[string]bool)
+	for res.Next() {
+		var name string
+		if err = res.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names[name] = true
+	}
+	return names
+}
+
+func schemaVersion(t *testing.T, db *Database) int {
+	t.Helper()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrateUp(t *testing.T) {
+	db := openTestDatabase(t, 0)
+
+	applied, err := db.MigrateUp(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("MigrateUp() applied %v migrations, want %v", len(applied), len(migrations))
+	}
+	if v := schemaVersion(t, db); v != LatestSchemaVersion() {
+		t.Errorf("schema version = %v, want %v", v, LatestSchemaVersion())
+	}
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status {
+		if !m.Applied || m.AppliedAt == "" {
+			t.Errorf("migration %v not marked as applied", m.Version)
+		}
+	}
+
+	if applied, err = db.MigrateUp(false); err != nil || len(applied) != 0 {
+		t.Errorf("second MigrateUp() = %v, %v, want nothing to do", len(applied), err)
+	}
+}
+
+func TestMigrateDown(t *testing.T) {
+	db := openTestDatabase(t, len(migrations))
+	withAll := tables(t, db)
+
+	// every migration is reverted and applied again on its own
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		before := tables(t, db)
+
+		reverted, err := db.MigrateDown(m.Version-1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reverted) != 1 || reverted[0].Version != m.Version {
+			t.Errorf("MigrateDown(%v) reverted %v, want migration %v", m.Version-1, reverted, m.Version)
+		}
+		if v := schemaVersion(t, db); v != m.Version-1 {
+			t.Errorf("schema version after reverting %v = %v, want %v", m.Version, v, m.Version-1)
+		}
+
+		if err = db.runMigrations(migrations[i:i+1], true, false); err != nil {
+			t.Fatalf("migration %v can not be applied again: %v", m.Version, err)
+		}
+		if after := tables(t, db); fmt.Sprint(after) != fmt.Sprint(before) {
+			t.Errorf("tables after reverting and applying %v = %v, want %v", m.Version, after, before)
+		}
+
+		if _, err = db.MigrateDown(m.Version-1, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if left := tables(t, db); len(left) != 0 {
+		t.Errorf("tables left after reverting all migrations: %v", left)
+	}
+
+	// and all of them are applied in one go again
+	if _, err := db.MigrateUp(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := tables(t, db); fmt.Sprint(got) != fmt.Sprint(withAll) {
+		t.Errorf("tables after migrating up again = %v, want %v", got, withAll)
+	}
+
+	if _, err := db.MigrateDown(LatestSchemaVersion()+1, false); err == nil {
+		t.Error("MigrateDown() above the schema version succeeded")
+	}
+	if _, err := db.MigrateDown(-1, false); err == nil {
+		t.Error("MigrateDown() to a negative version succeeded")
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	db := openTestDatabase(t, 0)
+
+	applied, err := db.MigrateUp(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("MigrateUp() dry run returned %v migrations, want %v", len(applied), len(migrations))
+	}
+	if v := schemaVersion(t, db); v != 0 {
+		t.Errorf("schema version after dry run = %v, want 0", v)
+	}
+	if got := tables(t, db); len(got) != 0 {
+		t.Errorf("dry run left tables %v", got)
+	}
+
+	if _, err = db.MigrateUp(false); err != nil {
+		t.Fatal(err)
+	}
+	withAll := tables(t, db)
+
+	reverted, err := db.MigrateDown(0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != len(migrations) {
+		t.Errorf("MigrateDown() dry run returned %v migrations, want %v", len(reverted), len(migrations))
+	}
+	if v := schemaVersion(t, db); v != LatestSchemaVersion() {
+		t.Errorf("schema version after dry run = %v, want %v", v, LatestSchemaVersion())
+	}
+	if got := tables(t, db); fmt.Sprint(got) != fmt.Sprint(withAll) {
+		t.Errorf("tables after dry run = %v, want %v", got, withAll)
+	}
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	db := openTestDatabase(t, 0)
+
+	// a database created before schema versioning, with an account of its own
+	for table, schema := range map[string]string{"users": usersSchemaV1, "market_assets": marketAssetsSchemaV1} {
+		if _, err := db.Exec(fmt.Sprintf(schema, table)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO users (login, password, balance) VALUES ('alice', 'x', 12)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.MigrateUp(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the existing tables are kept as they are, without the seed rows of a new database
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("users table has %v rows, want 1", n)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM market_assets`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("market_assets table has %v rows, want 0", n)
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/shopspring/decimal"
+	"time"
+	"tradingServer/entity"
+)
+
+const orderColumns = `id, login, type, side, asset, amount, price, status, created, updated, fill_price`
+
+func (db *Database) CreateOrder(o *entity.Order) error {
+	q := `INSERT INTO orders (login, type, side, asset, amount, price, status, created, updated) VALUES (?,?,?,?,?,?,?,?,?) RETURNING id`
+	err := db.QueryRow(q, o.Login, o.Type, o.Side, o.Asset, o.Amount, o.Price, o.Status,
+		o.Created.Format(time.RFC3339Nano), o.Updated.Format(time.RFC3339Nano)).Scan(&o.ID)
+	if err != nil {
+		return fmt.Errorf("insert order failed: %v", err)
+	}
+	return nil
+}
+
+func (db *Database) UpdateOrder(o entity.Order) error {
+	fillPrice := decimal.NullDecimal{
+		Decimal: o.FillPrice,
+		Valid:   !o.FillPrice.IsZero(),
+	}
+
+	q := `UPDATE orders SET status = ?, updated = ?, fill_price = ? WHERE id = ?`
+	res, err := db.Exec(q, o.Status, o.Updated.Format(time.RFC3339Nano), fillPrice, o.ID)
+	if err != nil {
+		return fmt.Errorf("update order %v failed: %v", o.ID, err)
+	}
+
+	if n, err := res.RowsAffected(); n != 1 {
+		return fmt.Errorf("order %v has not been updated: %v", o.ID, err)
+	}
+	return nil
+}
+
+// GetOrder returns the order with the given id or nil if there is none. In a transaction the order is locked.
+func (db *Database) GetOrder(id int64) (*entity.Order, error) {
+	orders, err := db.queryOrders(`SELECT `+orderColumns+` FROM orders WHERE id = ?`+db.forUpdate(), id)
+	if err != nil || len(orders) == 0 {
+		return nil, err
+	}
+	return orders[0], nil
+}
+
+// GetOrders returns a user's orders, newest first. Only open orders are returned if openOnly is set.
+func (db *Database) GetOrders(login string, openOnly bool) ([]*entity.Order, error) {
+	if openOnly {
+		q := `SELECT ` + orderColumns + ` FROM orders WHERE login = ? AND status = ? ORDER BY id DESC`
+		return db.queryOrders(q, login, entity.OrderStatusOpen)
+	}
+
+	q := `SELECT ` + orderColumns + ` FROM orders WHERE login = ? ORDER BY id DESC`
+	return db.queryOrders(q, login)
+}
+
+// GetOpenOrders returns the open orders of all users, oldest first
+func (db *Database) GetOpenOrders() ([]*entity.Order, error) {
+	q := `SELECT ` + orderColumns + ` FROM orders WHERE status = ? ORDER BY id`
+	return db.queryOrders(q, entity.OrderStatusOpen)
+}
+
+func (db *Database) queryOrders(q string, args ...interface{}) ([]*entity.Order, error) {
+	res, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query orders failed: %v", err)
+	}
+	defer res.Close()
+
+	orders := []*entity.Order{}
+	for res.Next() {
+		var o entity.Order
+		var created, updated string
+		var fillPrice decimal.NullDecimal
+
+		err = res.Scan(&o.ID, &o.Login, &o.Type, &o.Side, &o.Asset, &o.Amount, &o.Price, &o.Status, &created, &updated, &fillPrice)
+		if err != nil {
+			return nil, fmt.Errorf("scan order failed: %v", err)
+		}
+
+		o.Created, _ = time.Parse(time.RFC3339Nano, created)
+		o.Updated, _ = time.Parse(time.RFC3339Nano, updated)
+		if fillPrice.Valid {
+			o.FillPrice = fillPrice.Decimal
+		}
+
+		orders = append(orders, &o)
+	}
+
+	return orders, nil
+}
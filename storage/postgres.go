@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"tradingServer/entity"
+)
+
+// schemas of the PostgreSQL tables. Decimals are stored as NUMERIC, which is exact, so unlike the sqlite schema
+// they never needed a conversion. %v is replaced by the table name.
+const (
+	postgresUsersSchemaV1 = `CREATE TABLE %v (login VARCHAR(64) PRIMARY KEY, password VARCHAR(255) NOT NULL, email VARCHAR(255), balance NUMERIC NOT NULL)`
+
+	postgresMarketAssetsSchemaV1 = `CREATE TABLE %v (name VARCHAR(64) PRIMARY KEY, price NUMERIC NOT NULL)`
+
+	postgresUserAssetsSchemaV1 = `CREATE TABLE %v (
+	login VARCHAR(64) REFERENCES users (login),
+	asset VARCHAR(255) REFERENCES market_assets (name),
+	amount NUMERIC NOT NULL,
+	PRIMARY KEY (login, asset)
+)`
+
+	postgresTransactionLogSchemaV1 = `CREATE TABLE %v (
+	time VARCHAR(64),
+	login VARCHAR(64),
+	action VARCHAR(64),
+	unit_price NUMERIC,
+	payed_price NUMERIC,
+	amount NUMERIC,
+	asset VARCHAR(64),
+	balance NUMERIC
+)`
+
+	postgresAccessLogSchemaV1 = `CREATE TABLE %v (
+	time VARCHAR(64),
+	duration DOUBLE PRECISION,
+	login VARCHAR(64),
+	path VARCHAR(255),
+	status INT,
+	address VARCHAR(255)
+)`
+
+	postgresPriceHistorySchemaV2 = `CREATE TABLE %v (
+	time BIGINT,
+	asset VARCHAR(255) REFERENCES market_assets (name),
+	price NUMERIC,
+	PRIMARY KEY (time, asset)
+)`
+
+	postgresOrdersSchemaV3 = `CREATE TABLE %v (
+	id BIGSERIAL PRIMARY KEY,
+	login VARCHAR(64) NOT NULL REFERENCES users (login),
+	type VARCHAR(16) NOT NULL,
+	side VARCHAR(8) NOT NULL,
+	asset VARCHAR(64) NOT NULL REFERENCES market_assets (name),
+	amount NUMERIC NOT NULL,
+	price NUMERIC NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	created VARCHAR(64),
+	updated VARCHAR(64),
+	fill_price NUMERIC
+)`
+
+	postgresSessionsSchemaV6 = `CREATE TABLE %v (
+	access_hash VARCHAR(64) PRIMARY KEY,
+	refresh_hash VARCHAR(64) NOT NULL UNIQUE,
+	login VARCHAR(64) NOT NULL REFERENCES users (login),
+	created BIGINT NOT NULL,
+	access_expires BIGINT NOT NULL,
+	refresh_expires BIGINT NOT NULL
+)`
+
+	postgresAPIKeysSchemaV7 = `CREATE TABLE %v (
+	id BIGSERIAL PRIMARY KEY,
+	login VARCHAR(64) NOT NULL REFERENCES users (login),
+	name VARCHAR(64) NOT NULL,
+	key_hash VARCHAR(64) NOT NULL UNIQUE,
+	prefix VARCHAR(16) NOT NULL,
+	scopes VARCHAR(255) NOT NULL,
+	allowed_ips VARCHAR(1024) NOT NULL,
+	created BIGINT NOT NULL,
+	expires BIGINT,
+	UNIQUE (login, name)
+)`
+
+	postgresEmailConfirmationsSchemaV9 = `CREATE TABLE %v (
+	login VARCHAR(64) PRIMARY KEY REFERENCES users (login),
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	email VARCHAR(255) NOT NULL,
+	created BIGINT NOT NULL,
+	expires BIGINT NOT NULL
+)`
+
+	postgresInviteCodesSchemaV10 = `CREATE TABLE %v (
+	code VARCHAR(32) PRIMARY KEY,
+	created_by VARCHAR(64) NOT NULL,
+	created BIGINT NOT NULL,
+	expires BIGINT,
+	max_uses INTEGER NOT NULL,
+	uses INTEGER NOT NULL DEFAULT 0
+)`
+
+	postgresAuthFailuresSchemaV11 = `CREATE TABLE %v (
+	auth_key VARCHAR(128) PRIMARY KEY,
+	failures INTEGER NOT NULL,
+	last_failure BIGINT NOT NULL,
+	locked_until BIGINT NOT NULL
+)`
+
+	postgresUsedQuotesSchemaV14 = `CREATE TABLE %v (
+	signature VARCHAR(64) PRIMARY KEY,
+	expires BIGINT NOT NULL
+)`
+)
+
+// migrationLockID identifies the advisory lock serializing migrations of server instances sharing a database
+const migrationLockID = 7265312
+
+// marketLockID identifies the advisory lock held by the server instance running the market
+const marketLockID = 7265313
+
+func (db *Database) HoldMarketLock() (bool, error) {
+	if db.backend != BackendPostgres {
+		return true, nil
+	}
+
+	db.marketMu.Lock()
+	defer db.marketMu.Unlock()
+
+	// the session lock is held as long as the connection lives, so check that it still does
+	ctx := context.Background()
+	if db.marketConn != nil {
+		if _, err := db.marketConn.ExecContext(ctx, `SELECT 1`); err == nil {
+			return true, nil
+		}
+		discardConn(db.marketConn)
+		db.marketConn = nil
+	}
+
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var locked bool
+	if err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, marketLockID).Scan(&locked); err != nil || !locked {
+		conn.Close()
+		return false, err
+	}
+
+	db.marketConn = conn
+	return true, nil
+}
+
+// Close closes the database, ending the session holding the market lock right away lets another instance take over
+func (db *Database) Close() error {
+	db.marketMu.Lock()
+	if db.marketConn != nil {
+		discardConn(db.marketConn)
+		db.marketConn = nil
+	}
+	db.marketMu.Unlock()
+
+	return db.DB.Close()
+}
+
+// discardConn closes the connection instead of returning it to the pool, ending its session and the locks it holds
+func discardConn(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+	conn.Close()
+}
+
+func postgresInitialSchema(tx *sql.Tx) error {
+	created, err := createPostgresTableIfMissing(tx, "users", postgresUsersSchemaV1)
+	if err != nil {
+		return err
+	}
+	if created {
+		hash, err := entity.HashEncodePassword("test")
+		if err != nil {
+			return err
+		}
+		q := `INSERT INTO users (login,password,balance) VALUES ('test',$1,$2)`
+		if _, err = tx.Exec(q, hash, 100); err != nil {
+			return err
+		}
+	}
+
+	created, err = createPostgresTableIfMissing(tx, "market_assets", postgresMarketAssetsSchemaV1)
+	if err != nil {
+		return err
+	}
+	if created {
+		q := `INSERT INTO market_assets (name, price) VALUES
+			('white_wool',45),
+			('black_wool',42),
+			('toothpaste',8.5),
+			('old_tires',19.2),
+			('olive_oil',127)`
+		if _, err = tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	if _, err = createPostgresTableIfMissing(tx, "user_assets", postgresUserAssetsSchemaV1); err != nil {
+		return err
+	}
+	if _, err = createPostgresTableIfMissing(tx, "transaction_log", postgresTransactionLogSchemaV1); err != nil {
+		return err
+	}
+	_, err = createPostgresTableIfMissing(tx, "access_log", postgresAccessLogSchemaV1)
+	return err
+}
+
+// createPostgresTableIfMissing creates a table from a schema unless it exists already and tells whether it has been created
+func createPostgresTableIfMissing(tx *sql.Tx, table string, schema string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, table).Scan(&exists)
+	if err != nil || exists {
+		return false, err
+	}
+
+	if _, err = tx.Exec(fmt.Sprintf(schema, table)); err != nil {
+		return false, fmt.Errorf("could not create table %v: %v", table, err)
+	}
+	return true, nil
+}
+
+// postgresAddColumn returns a migration adding a column to a table unless it exists already
+func postgresAddColumn(table string, column string, definition string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN IF NOT EXISTS %v %v", table, column, definition))
+		return err
+	}
+}
+
+// postgresDropColumn returns a migration dropping a column of a table if it exists
+func postgresDropColumn(table string, column string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(fmt.Sprintf("ALTER TABLE %v DROP COLUMN IF EXISTS %v", table, column))
+		return err
+	}
+}
+
+// rebind replaces the ? placeholders of a query by the numbered placeholders $1, $2, ... PostgreSQL expects
+func rebind(query string) string {
+	var sb strings.Builder
+	n := 0
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
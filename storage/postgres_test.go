@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"database/sql"
+	"github.com/shopspring/decimal"
+	"os"
+	"testing"
+	"time"
+)
+
+// postgresDSNEnv names the environment variable holding the connection string of a PostgreSQL database the tests
+// may wipe. The PostgreSQL tests are skipped if it is not set.
+const postgresDSNEnv = "TRADINGSERVER_TEST_POSTGRES_DSN"
+
+// openPostgresTestDatabase opens the PostgreSQL test database with all migrations reverted
+func openPostgresTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%v not set", postgresDSNEnv)
+	}
+
+	db, err := OpenDatabase(BackendPostgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err = db.MigrateDown(0, false); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// postgresColumns returns the columns of a table of the PostgreSQL test database
+func postgresColumns(t *testing.T, db *Database, table string) map[string]bool {
+	t.Helper()
+
+	res, err := db.Query(`SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ?`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	columns := make(map[string]bool)
+	for res.Next() {
+		var name string
+		if err = res.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		columns[name] = true
+	}
+	return columns
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`SELECT 1`, `SELECT 1`},
+		{`SELECT * FROM users WHERE login = ?`, `SELECT * FROM users WHERE login = $1`},
+		{`INSERT INTO users (login,password,balance) VALUES (?,?,?)`, `INSERT INTO users (login,password,balance) VALUES ($1,$2,$3)`},
+		{`SELECT * FROM users WHERE email = '?' AND login = ?`, `SELECT * FROM users WHERE email = '?' AND login = $1`},
+		{`SELECT * FROM users WHERE email = 'it''s ?' AND login = ?`, `SELECT * FROM users WHERE email = 'it''s ?' AND login = $1`},
+		{`UPDATE t SET a = ? WHERE b = '?' OR c = ? OR d = '??'`, `UPDATE t SET a = $1 WHERE b = '?' OR c = $2 OR d = '??'`},
+	}
+
+	for _, tt := range tests {
+		if got := rebind(tt.query); got != tt.want {
+			t.Errorf("rebind(%v) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	sqlite := &Database{backend: BackendSQLite}
+	if got := sqlite.rebind(`SELECT ?`); got != `SELECT ?` {
+		t.Errorf("rebind() of sqlite = %v, want the query unchanged", got)
+	}
+}
+
+func TestForUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		db      *Database
+		wantSQL string
+	}{
+		{"sqlite", &Database{backend: BackendSQLite}, ""},
+		{"postgres without transaction", &Database{backend: BackendPostgres}, ""},
+		{"postgres in a transaction", &Database{backend: BackendPostgres, tx: &sql.Tx{}}, " FOR UPDATE"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.db.forUpdate(); got != tt.wantSQL {
+			t.Errorf("%v: forUpdate() = %q, want %q", tt.name, got, tt.wantSQL)
+		}
+	}
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	db := openPostgresTestDatabase(t)
+
+	applied, err := db.MigrateUp(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("MigrateUp() applied %v migrations, want %v", len(applied), len(migrations))
+	}
+	if v := schemaVersion(t, db); v != LatestSchemaVersion() {
+		t.Errorf("schema version = %v, want %v", v, LatestSchemaVersion())
+	}
+	if !postgresColumns(t, db, "users")["tier"] {
+		t.Error("column tier of users has not been added")
+	}
+
+	// the added column is dropped again, reverting twice is harmless
+	if _, err = db.MigrateDown(4, false); err != nil {
+		t.Fatal(err)
+	}
+	if postgresColumns(t, db, "users")["tier"] {
+		t.Error("column tier of users has not been dropped")
+	}
+	tx, err := db.DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = postgresDropColumn("users", "tier")(tx); err != nil {
+		t.Errorf("dropping a missing column failed: %v", err)
+	}
+	if err = postgresAddColumn("users", "balance", "NUMERIC")(tx); err != nil {
+		t.Errorf("adding an existing column failed: %v", err)
+	}
+	tx.Rollback()
+
+	if _, err = db.MigrateUp(false); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, db); v != LatestSchemaVersion() {
+		t.Errorf("schema version after migrating up again = %v, want %v", v, LatestSchemaVersion())
+	}
+
+	if _, err = db.MigrateDown(0, false); err != nil {
+		t.Fatal(err)
+	}
+	if columns := postgresColumns(t, db, "users"); len(columns) != 0 {
+		t.Errorf("table users left after reverting all migrations: %v", columns)
+	}
+}
+
+func TestPostgresSelectForUpdate(t *testing.T) {
+	db := openPostgresTestDatabase(t)
+	if _, err := db.MigrateUp(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// both transactions add to the balance read, the second one has to wait for the first to commit
+	add := func(tx Storage, amount int64) {
+		acc, err := tx.GetAccount("test")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		acc.Balance = acc.Balance.Add(decimal.NewFromInt(amount))
+		if err = tx.SaveAccount(*acc); err != nil {
+			t.Error(err)
+		}
+	}
+
+	first, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(first, 10)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		second, err := db.Begin()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		add(second, 5)
+		if err = second.Commit(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("second transaction read the account locked by the first one")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err = first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	acc, err := db.GetAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !acc.Balance.Equal(decimal.NewFromInt(115)) {
+		t.Errorf("balance = %v, want 115", acc.Balance)
+	}
+}
+
+func TestPostgresHoldMarketLock(t *testing.T) {
+	first := openPostgresTestDatabase(t)
+	second, err := OpenDatabase(BackendPostgres, os.Getenv(postgresDSNEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	holds := func(name string, db *Database, want bool) {
+		t.Helper()
+		got, err := db.HoldMarketLock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%v: HoldMarketLock() = %v, want %v", name, got, want)
+		}
+	}
+
+	holds("first instance", first, true)
+	holds("first instance again", first, true)
+	holds("second instance", second, false)
+
+	// the lock is released once its session ends, the holder takes it again on its next check
+	if _, err = second.Exec(`SELECT pg_terminate_backend(pid) FROM pg_locks WHERE locktype = 'advisory' AND objid = ?`, marketLockID); err != nil {
+		t.Fatal(err)
+	}
+	holds("first instance after losing its connection", first, true)
+	holds("second instance", second, false)
+
+	// closing the storage discards the session right away
+	if err = first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	holds("second instance after the first closed", second, true)
+}
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+func (db *Database) UseQuote(signature string, expires time.Time, now time.Time) (bool, error) {
+	if _, err := db.Exec(`DELETE FROM used_quotes WHERE expires < ?`, now.Unix()); err != nil {
+		return false, fmt.Errorf("delete expired quotes failed: %v", err)
+	}
+
+	// the primary key lets only one of concurrent redemptions of a quote insert it, also across server instances
+	res, err := db.Exec(`INSERT INTO used_quotes (signature, expires) VALUES (?,?) ON CONFLICT (signature) DO NOTHING`,
+		signature, expires.Unix())
+	if err != nil {
+		return false, fmt.Errorf("insert used quote failed: %v", err)
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"tradingServer/entity"
+)
+
+const sessionColumns = `access_hash, refresh_hash, login, created, access_expires, refresh_expires`
+
+func (db *Database) CreateSession(s entity.Session) error {
+	q := `INSERT INTO sessions (` + sessionColumns + `) VALUES (?,?,?,?,?,?)`
+	_, err := db.Exec(q, s.AccessTokenHash, s.RefreshTokenHash, s.Login,
+		s.Created.Unix(), s.AccessExpires.Unix(), s.RefreshExpires.Unix())
+	if err != nil {
+		return fmt.Errorf("insert session failed: %v", err)
+	}
+	return nil
+}
+
+func (db *Database) GetSession(accessTokenHash string) (*entity.Session, error) {
+	q := `SELECT ` + sessionColumns + ` FROM sessions WHERE access_hash = ?`
+	return db.querySession(q, accessTokenHash)
+}
+
+func (db *Database) GetSessionByRefreshToken(refreshTokenHash string) (*entity.Session, error) {
+	q := `SELECT ` + sessionColumns + ` FROM sessions WHERE refresh_hash = ?` + db.forUpdate()
+	return db.querySession(q, refreshTokenHash)
+}
+
+func (db *Database) DeleteSession(accessTokenHash string) error {
+	if _, err := db.Exec(`DELETE FROM sessions WHERE access_hash = ?`, accessTokenHash); err != nil {
+		return fmt.Errorf("delete session failed: %v", err)
+	}
+	return nil
+}
+
+func (db *Database) DeleteExpiredSessions(before time.Time) (int64, error) {
+	res, err := db.Exec(`DELETE FROM sessions WHERE refresh_expires < ?`, before.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("delete expired sessions failed: %v", err)
+	}
+	return res.RowsAffected()
+}
+
+func (db *Database) DeleteSessions(login string, exceptAccessTokenHash string) error {
+	q := `DELETE FROM sessions WHERE login = ? AND access_hash <> ?`
+	if _, err := db.Exec(q, login, exceptAccessTokenHash); err != nil {
+		return fmt.Errorf("delete sessions failed: %v", err)
+	}
+	return nil
+}
+
+func (db *Database) querySession(q string, args ...interface{}) (*entity.Session, error) {
+	var s entity.Session
+	var created, accessExpires, refreshExpires int64
+
+	err := db.QueryRow(q, args...).Scan(&s.AccessTokenHash, &s.RefreshTokenHash, &s.Login,
+		&created, &accessExpires, &refreshExpires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query session failed: %v", err)
+	}
+
+	s.Created = time.Unix(created, 0)
+	s.AccessExpires = time.Unix(accessExpires, 0)
+	s.RefreshExpires = time.Unix(refreshExpires, 0)
+	return &s, nil
+}
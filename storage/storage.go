@@ -4,166 +4,224 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	_ "github.com/mattn/go-sqlite3"
+	_ "github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	"github.com/shopspring/decimal"
-	"log"
-	"os"
+	"math"
 	"strings"
 	"sync"
 	"time"
 	"tradingServer/entity"
+	"tradingServer/logging"
+	"tradingServer/metrics"
 )
 
 const dbFile = "database.sqlite3"
 
+// dsnOptions apply to every sqlite connection: foreign key checks, write ahead log and immediate write locks on
+// transaction begin, so concurrent read-modify-write transactions are serialized instead of failing on commit.
+const dsnOptions = "?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// SQL backends implemented by Database
+const (
+	BackendSQLite   = "sqlite"
+	BackendPostgres = "postgres"
+)
+
+// timestampFormat is used to store access log times, it is the sqlite driver's format for time values
+const timestampFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// Database is the Storage backed by an SQL database, either an sqlite database file or a PostgreSQL server shared by
+// several server instances. It runs its operations either against the connection pool or, if created by Begin, in a
+// transaction.
 type Database struct {
 	*sql.DB
+	backend     string
+	tx          *sql.Tx
+	afterCommit []func()
+	requestID   string
+	// marketConn holds the PostgreSQL session owning the market lock, see HoldMarketLock. marketMu guards it, the
+	// storage may be closed while the market is being checked.
+	marketMu   sync.Mutex
+	marketConn *sql.Conn
 }
 
-var db *Database
-var dbMu sync.Mutex
-
-func GetDatabase() *Database {
-	dbMu.Lock()
-	defer dbMu.Unlock()
+// NewDatabase opens the database and migrates its schema to the latest version
+func NewDatabase(backend string, dsn string) (*Database, error) {
+	db, err := OpenDatabase(backend, dsn)
+	if err != nil {
+		return nil, err
+	}
 
-	if db != nil {
-		return db
+	applied, err := db.MigrateUp(false)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not migrate database: %v", err)
+	}
+	for _, m := range applied {
+		logging.Infof("applied database migration %v: %v", m.Version, m.Description)
 	}
 
-	freshlyCreated := false
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
-		freshlyCreated = true
-		f, err := os.Create(dbFile)
-		if err != nil {
-			log.Fatalf("could not create file %v\n", dbFile)
+	return db, nil
+}
+
+// OpenDatabase opens the database without touching its schema. For sqlite the data source name is the database
+// file, database.sqlite3 in the working directory by default. For PostgreSQL it is a connection URL or key=value
+// connection string.
+func OpenDatabase(backend string, dsn string) (*Database, error) {
+	var sqlDb *sql.DB
+	var err error
+	switch backend {
+	case BackendSQLite:
+		if dsn == "" {
+			dsn = dbFile
 		}
-		f.Close()
+		sqlDb, err = sql.Open("sqlite3", "file:"+dsn+dsnOptions)
+	case BackendPostgres:
+		if dsn == "" {
+			return nil, errors.New("could not open database: PostgreSQL needs a connection string")
+		}
+		sqlDb, err = sql.Open("postgres", dsn)
+	default:
+		return nil, fmt.Errorf("could not open database: unknown backend '%v'", backend)
 	}
-
-	sqlite3Db, err := sql.Open("sqlite3", dbFile)
 	if err != nil {
-		log.Fatalf("could not open database: %v\n", err)
+		return nil, fmt.Errorf("could not open database: %v", err)
 	}
 
-	db = &Database{sqlite3Db}
+	if err = sqlDb.Ping(); err != nil {
+		sqlDb.Close()
+		return nil, fmt.Errorf("could not open database: %v", err)
+	}
 
-	// enable foreign keys
-	q := "PRAGMA foreign_keys = ON;"
-	_, err = sqlite3Db.Exec(q)
-	if err != nil {
-		log.Fatalf("could not activate foreign key checks: %v", err)
+	return &Database{DB: sqlDb, backend: backend}, nil
+}
+
+func (db *Database) Begin() (Storage, error) {
+	if db.tx != nil {
+		return nil, errors.New("transaction in progress already")
 	}
 
-	q2 := "PRAGMA journal_mode = WAL;"
-	_, err = sqlite3Db.Exec(q2)
+	tx, err := db.DB.Begin()
 	if err != nil {
-		log.Fatalf("could not switch journal mode to WAL: %v", err)
+		return nil, err
 	}
 
-	if freshlyCreated {
-		db.initDatabase()
+	return &Database{DB: db.DB, backend: db.backend, tx: tx}, nil
+}
+
+func (db *Database) Commit() error {
+	if db.tx == nil {
+		return errors.New("no transaction in progress")
+	}
+
+	if err := db.tx.Commit(); err != nil {
+		return err
 	}
 
-	return db
+	for _, fn := range db.afterCommit {
+		fn()
+	}
+	db.afterCommit = nil
+
+	return nil
 }
 
-func (db *Database) initDatabase() {
-	query1 := "CREATE TABLE users (login varchar(64) PRIMARY KEY, password varchar(255) NOT NULL, email varchar(255), balance real NOT NULL)"
-	_, err := db.Exec(query1)
-	if err != nil {
-		log.Fatalf("could not create users table: %v", err)
+func (db *Database) Rollback() error {
+	if db.tx == nil {
+		return errors.New("no transaction in progress")
 	}
 
-	query2 := "INSERT INTO users (login,password,balance) VALUES ('test',?,?)"
-	_, err = db.Exec(query2, entity.HashEncodePassword("test"), 100)
-	if err != nil {
-		log.Fatalf("could not insert into users table: %v", err)
+	db.afterCommit = nil
+	return db.tx.Rollback()
+}
+
+func (db *Database) SetRequestID(id string) {
+	db.requestID = id
+}
+
+func (db *Database) AfterCommit(fn func()) {
+	if db.tx == nil {
+		fn()
+		return
 	}
+	db.afterCommit = append(db.afterCommit, fn)
+}
 
-	query3 := "CREATE TABLE market_assets (name varchar(64) PRIMARY KEY, price real NOT NULL)"
-	_, err = db.Exec(query3)
-	if err != nil {
-		log.Fatalf("could not create assets table: %v", err)
+// Exec, Query and QueryRow take queries with ? placeholders for all backends
+
+func (db *Database) Exec(query string, args ...interface{}) (sql.Result, error) {
+	defer observeQuery(query, time.Now())
+	query = db.rebind(query)
+	if db.tx != nil {
+		return db.tx.Exec(query, args...)
 	}
+	return db.DB.Exec(query, args...)
+}
 
-	query31 := `INSERT INTO market_assets (name, price) VALUES 
-			('white_wool',45),
-			('black_wool',42),
-			('toothpaste',8.5),
-			('old_tires',19.2),
-			('olive_oil',127)`
-	_, err = db.Exec(query31)
-	if err != nil {
-		log.Fatalf("could not insert into assets table: %v", err)
+func (db *Database) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer observeQuery(query, time.Now())
+	query = db.rebind(query)
+	if db.tx != nil {
+		return db.tx.Query(query, args...)
 	}
+	return db.DB.Query(query, args...)
+}
 
-	query4 := `CREATE TABLE user_assets (
-    login varchar(64), 
-    asset varchar(255), 
-    amount real NOT NULL, 
-    PRIMARY KEY (login, asset), 
-    FOREIGN KEY (login) REFERENCES users (login),
-    FOREIGN KEY (asset) REFERENCES market_assets (name)
-                      )`
-	_, err = db.Exec(query4)
-	if err != nil {
-		log.Fatalf("could not create accounts table: %v", err)
+func (db *Database) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeQuery(query, time.Now())
+	query = db.rebind(query)
+	if db.tx != nil {
+		return db.tx.QueryRow(query, args...)
 	}
+	return db.DB.QueryRow(query, args...)
+}
 
-	/*
-			query5 := `CREATE TABLE price_history
-		(
-			time INT,
-			asset VARCHAR(255),
-			price REAL,
-			PRIMARY KEY(time, asset),
-			FOREIGN KEY (asset) REFERENCES assets (name)
-		)`
-			_, err = db.Exec(query5)
-			if err != nil {
-				log.Fatalf("could not create price_history table: %v", err)
-			}
-	*/
+// observeQuery records the duration of a statement in the metrics by its first keyword. Reading the rows of a query
+// is not included.
+func observeQuery(query string, start time.Time) {
+	statement := "other"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		statement = strings.ToLower(fields[0])
+	}
+	metrics.DBQueryDuration.WithLabelValues(statement).Observe(time.Since(start).Seconds())
+}
 
-	query6 := `CREATE TABLE transaction_log (
-	time VARCHAR(64),
-	login VARCHAR(64),
-	action VARCHAR(64),
-	unit_price REAL,
-	payed_price REAL,
-	amount REAL,
-	asset VARCHAR(64),
-	balance REAL
-)`
-	_, err = db.Exec(query6)
-	if err != nil {
-		log.Fatalf("failed to create table transaction_log: %v", err)
+func (db *Database) rebind(query string) string {
+	if db.backend == BackendPostgres {
+		return rebind(query)
 	}
+	return query
+}
 
-	query7 := `CREATE TABLE access_log (
-	time VARCHAR(64),
-	duration REAL,
-	login VARCHAR(64),
-	path VARCHAR(255),
-	status INT,
-	address VARCHAR(255)
-)`
-	_, err = db.Exec(query7)
-	if err != nil {
-		log.Fatalf("failed to create table access_log: %v", err)
+// forUpdate returns the clause locking the selected rows until the end of the transaction. sqlite locks the whole
+// database on begin already.
+func (db *Database) forUpdate() string {
+	if db.backend == BackendPostgres && db.tx != nil {
+		return " FOR UPDATE"
 	}
+	return ""
+}
+
+// limit returns a LIMIT value, negative counts select all rows
+func limit(count int) int64 {
+	if count < 0 {
+		return math.MaxInt64
+	}
+	return int64(count)
 }
 
 type TransactionLogEntry struct {
 	Time         string
 	Login        string
 	Action       string
-	PricePerUnit float64
-	PricePayed   float64
-	Amount       float64
+	PricePerUnit decimal.Decimal
+	PricePayed   decimal.Decimal
+	Amount       decimal.Decimal
 	Asset        string
-	Balance      float64
+	Balance      decimal.Decimal
+	// RequestID is the X-Request-ID of the request which made the trade, empty for orders filled in the background
+	RequestID string
 }
 
 type AccessLogEntry struct {
@@ -173,11 +231,21 @@ type AccessLogEntry struct {
 	Login         string
 	Path          string
 	StatusCode    int
+	// APIKeyID is the API key the request was authenticated with, 0 if none
+	APIKeyID int64
+	// Event marks requests which locked a login or address, were rejected by a lockout or lifted one
+	Event string
+	// RequestID is the X-Request-ID of the request
+	RequestID string
 }
 
 func (db *Database) LogAccess(e AccessLogEntry) error {
-	q := `INSERT INTO access_log (time,duration,login,status,address,path) VALUES (?,?,?,?,?,?)`
-	_, err := db.Exec(q, e.Time, e.Duration, e.Login, e.StatusCode, e.RemoteAddress, e.Path)
+	apiKey := sql.NullInt64{Int64: e.APIKeyID, Valid: e.APIKeyID != 0}
+	event := sql.NullString{String: e.Event, Valid: e.Event != ""}
+	requestID := sql.NullString{String: e.RequestID, Valid: e.RequestID != ""}
+
+	q := `INSERT INTO access_log (time,duration,login,status,address,path,api_key,event,request_id) VALUES (?,?,?,?,?,?,?,?,?)`
+	_, err := db.Exec(q, e.Time.Format(timestampFormat), e.Duration, e.Login, e.StatusCode, e.RemoteAddress, e.Path, apiKey, event, requestID)
 	if err != nil {
 		return fmt.Errorf("write access log failed: %v", err)
 	}
@@ -185,24 +253,108 @@ func (db *Database) LogAccess(e AccessLogEntry) error {
 }
 
 func (db *Database) LogTransaction(e TransactionLogEntry) error {
-	q := `INSERT INTO transaction_log (time,login,action,unit_price,payed_price,amount,asset,balance) VALUES (?,?,?,?,?,?,?,?)`
-	_, err := db.Exec(q, e.Time, e.Login, e.Action, e.PricePerUnit, e.PricePayed, e.Amount, e.Asset, e.Balance)
+	if e.RequestID == "" {
+		e.RequestID = db.requestID
+	}
+	requestID := sql.NullString{String: e.RequestID, Valid: e.RequestID != ""}
+
+	q := `INSERT INTO transaction_log (time,login,action,unit_price,payed_price,amount,asset,balance,request_id) VALUES (?,?,?,?,?,?,?,?,?)`
+	_, err := db.Exec(q, e.Time, e.Login, e.Action, e.PricePerUnit, e.PricePayed, e.Amount, e.Asset, e.Balance, requestID)
 	if err != nil {
 		return fmt.Errorf("write transaction log failed: %v", err)
 	}
 	return nil
 }
 
-func (db *Database) GetAssets() ([]entity.MarketAsset, error) {
-	dbMu.Lock()
-	defer dbMu.Unlock()
+func (db *Database) GetAccessLog(count int) ([]AccessLogEntry, error) {
+	q := `SELECT time,duration,login,path,status,address,api_key,event,request_id FROM access_log ORDER BY time DESC LIMIT ?`
+	res, err := db.Query(q, limit(count))
+	if err != nil {
+		return nil, fmt.Errorf("access log query failed: %v", err)
+	}
+	defer res.Close()
+
+	var entries []AccessLogEntry
+	for res.Next() {
+		var e AccessLogEntry
+		var t string
+		var apiKey sql.NullInt64
+		var event, requestID sql.NullString
+		if err = res.Scan(&t, &e.Duration, &e.Login, &e.Path, &e.StatusCode, &e.RemoteAddress, &apiKey, &event, &requestID); err != nil {
+			return nil, fmt.Errorf("scan access log failed: %v", err)
+		}
+		e.Time = parseTimestamp(t)
+		e.APIKeyID = apiKey.Int64
+		e.Event = event.String
+		e.RequestID = requestID.String
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func (db *Database) GetTransactionLog(count int) ([]TransactionLogEntry, error) {
+	q := `SELECT time,login,action,unit_price,payed_price,amount,asset,balance,request_id FROM transaction_log ORDER BY time DESC LIMIT ?`
+	res, err := db.Query(q, limit(count))
+	if err != nil {
+		return nil, fmt.Errorf("transaction log query failed: %v", err)
+	}
+	defer res.Close()
+
+	var entries []TransactionLogEntry
+	for res.Next() {
+		var e TransactionLogEntry
+		var requestID sql.NullString
+		err = res.Scan(&e.Time, &e.Login, &e.Action, &e.PricePerUnit, &e.PricePayed, &e.Amount, &e.Asset, &e.Balance, &requestID)
+		if err != nil {
+			return nil, fmt.Errorf("scan transaction log failed: %v", err)
+		}
+		e.RequestID = requestID.String
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
 
+func (db *Database) ClearLogs() (int64, int64, error) {
+	res, err := db.Exec(`DELETE FROM access_log`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("clearing access log failed: %v", err)
+	}
+	accessRows, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	res, err = db.Exec(`DELETE FROM transaction_log`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("clearing transaction log failed: %v", err)
+	}
+	transactionRows, err := res.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return accessRows, transactionRows, nil
+}
+
+// parseTimestamp parses a time as written by the sqlite driver
+func parseTimestamp(s string) time.Time {
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (db *Database) GetAssets() ([]entity.MarketAsset, error) {
 	var assets []entity.MarketAsset
 
-	q := `SELECT name,price FROM market_assets ORDER BY name`
+	q := `SELECT name,price,halted FROM market_assets ORDER BY name`
 	res, err := db.Query(q)
 	if err != nil {
-		log.Printf("query assets failed: %v", err)
+		logging.Errorf("query assets failed: %v", err)
 		return nil, err
 	}
 	defer res.Close()
@@ -210,27 +362,25 @@ func (db *Database) GetAssets() ([]entity.MarketAsset, error) {
 	now := time.Now()
 	for res.Next() {
 		var n string
-		var p float64
-		if err := res.Scan(&n, &p); err != nil {
-			log.Printf("scan assets failed: %v", err)
+		var p decimal.Decimal
+		var halted bool
+		if err := res.Scan(&n, &p, &halted); err != nil {
+			logging.Errorf("scan assets failed: %v", err)
 			return nil, err
 		}
-		assets = append(assets, entity.MarketAsset{Name: n, Price: decimal.NewFromFloat(p), When: now})
+		assets = append(assets, entity.MarketAsset{Name: n, Price: p, When: now, Halted: halted})
 	}
 
 	return assets, nil
 }
 
 func (db *Database) GetAccounts() ([]*entity.PublicAccount, error) {
-	dbMu.Lock()
-	defer dbMu.Unlock()
-
 	var accList []*entity.PublicAccount
 
-	q := `SELECT login, balance FROM users ORDER BY balance DESC`
+	q := `SELECT login, balance FROM users ORDER BY CAST(balance AS REAL) DESC`
 	res, err := db.Query(q)
 	if err != nil {
-		log.Fatalf("all users query failed: %v", err)
+		return nil, fmt.Errorf("all users query failed: %v", err)
 	}
 	defer res.Close()
 
@@ -238,14 +388,21 @@ func (db *Database) GetAccounts() ([]*entity.PublicAccount, error) {
 		acc := entity.PublicAccount{}
 
 		if err = res.Scan(&acc.Login, &acc.Balance); err != nil {
-			log.Fatalf("scan user's row failed: %v", err)
+			return nil, fmt.Errorf("scan user's row failed: %v", err)
 		}
 
-		if err = db.getAccountsAssets(&acc); err != nil {
+		accList = append(accList, &acc)
+	}
+	if err = res.Err(); err != nil {
+		return nil, fmt.Errorf("all users query failed: %v", err)
+	}
+	// a transaction's connection can not run the assets queries while the rows are open
+	res.Close()
+
+	for _, acc := range accList {
+		if err = db.getAccountsAssets(acc); err != nil {
 			return nil, err
 		}
-
-		accList = append(accList, &acc)
 	}
 
 	return accList, nil
@@ -277,18 +434,7 @@ func (db *Database) getAccountsAssets(acc *entity.PublicAccount) error {
 }
 
 func (db *Database) GetAccount(login string) (*entity.Account, error) {
-	dbMu.Lock()
-	defer dbMu.Unlock()
-
-	q1 := `SELECT password, email, balance FROM users WHERE login = ?`
-	res1, err := db.Query(q1, login)
-	if err != nil {
-		log.Fatalf("users query failed: %v", err)
-	}
-	defer res1.Close()
-	if !res1.Next() {
-		return nil, fmt.Errorf("table users has no such login: %v", login)
-	}
+	q1 := `SELECT password, email, balance, tier, admin, disabled, totp_enabled FROM users WHERE login = ?` + db.forUpdate()
 
 	acc := entity.Account{
 		PublicAccount: entity.PublicAccount{
@@ -299,8 +445,12 @@ func (db *Database) GetAccount(login string) (*entity.Account, error) {
 
 	var pw string
 	var email sql.NullString
-	if err = res1.Scan(&pw, &email, &acc.Balance); err != nil {
-		log.Fatalf("scan user's account row failed: %v", err)
+	err := db.QueryRow(q1, login).Scan(&pw, &email, &acc.Balance, &acc.Tier, &acc.Admin, &acc.Disabled, &acc.TOTPEnabled)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("login %v %w", login, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query user's account failed: %v", err)
 	}
 	if email.Valid {
 		acc.Email = email.String
@@ -315,9 +465,6 @@ func (db *Database) GetAccount(login string) (*entity.Account, error) {
 }
 
 func (db *Database) SaveAccount(acc entity.Account) error {
-	dbMu.Lock()
-	defer dbMu.Unlock()
-
 	q1 := `UPDATE users SET balance = ? WHERE login = ?`
 	_, err := db.Exec(q1, acc.Balance, acc.Login)
 	if err != nil {
@@ -345,9 +492,6 @@ func (db *Database) SaveAccount(acc entity.Account) error {
 }
 
 func (db *Database) GetAssetPrice(assetName string) (decimal.Decimal, error) {
-	dbMu.Lock()
-	defer dbMu.Unlock()
-
 	q := `SELECT price FROM market_assets WHERE name = ?`
 	res, err := db.Query(q, assetName)
 	if err != nil {
@@ -359,48 +503,95 @@ func (db *Database) GetAssetPrice(assetName string) (decimal.Decimal, error) {
 		return decimal.Zero, fmt.Errorf("no such asset: %v", assetName)
 	}
 
-	var priceFloat float64
-	if err = res.Scan(&priceFloat); err != nil {
+	var price decimal.Decimal
+	if err = res.Scan(&price); err != nil {
 		return decimal.Zero, err
 	}
 
-	return decimal.NewFromFloat(priceFloat), nil
+	return price, nil
 }
 
 func (db *Database) SetAssetPrice(assetName string, price decimal.Decimal) error {
-	dbMu.Lock()
-	defer dbMu.Unlock()
-
 	q1 := `UPDATE market_assets SET price = ? WHERE name = ?`
-	priceFloat, _ := price.Float64()
-	_, err := db.Exec(q1, priceFloat, assetName)
+	_, err := db.Exec(q1, price, assetName)
 	if err != nil {
 		return err
 	}
 
-	//q2 := `INSERT INTO price_history (time, asset, price) VALUES (?,?,?)`
-	//_, err = db.Exec(q2, time.Now(), assetName, priceFloat)
-	//if err != nil {
-	//	return err
-	//}
+	q2 := `INSERT INTO price_history (time, asset, price) VALUES (?,?,?) ON CONFLICT (time, asset) DO UPDATE SET price = excluded.price`
+	_, err = db.Exec(q2, time.Now().UnixMilli(), assetName, price)
+	if err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func (db *Database) AddAccount(login string, password string, email string) error {
-	if acc, _ := db.GetAccount(login); acc != nil {
+// GetPriceHistory returns the recorded prices of an asset between from and to, oldest first.
+// All assets are returned if assetName is empty. At most limit entries are returned.
+func (db *Database) GetPriceHistory(assetName string, from, to time.Time, limit int) ([]entity.MarketAsset, error) {
+	q := `SELECT time, asset, price FROM price_history WHERE time >= ? AND time <= ? AND (? = '' OR asset = ?) ORDER BY time, asset LIMIT ?`
+	res, err := db.Query(q, from.UnixMilli(), to.UnixMilli(), assetName, assetName, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query price history failed: %v", err)
+	}
+	defer res.Close()
+
+	history := []entity.MarketAsset{}
+	for res.Next() {
+		var millis int64
+		var n string
+		var p decimal.Decimal
+		if err = res.Scan(&millis, &n, &p); err != nil {
+			return nil, fmt.Errorf("scan price history failed: %v", err)
+		}
+		history = append(history, entity.MarketAsset{Name: n, Price: p, When: time.UnixMilli(millis)})
+	}
+
+	return history, nil
+}
+
+// DownsamplePriceHistory keeps only the last price of every asset per bucket for all entries older than before.
+func (db *Database) DownsamplePriceHistory(before time.Time, bucket time.Duration) (int64, error) {
+	q := `DELETE FROM price_history WHERE time < ? AND (asset, time) NOT IN (
+	SELECT asset, MAX(time) FROM price_history WHERE time < ? GROUP BY asset, time / ?)`
+	res, err := db.Exec(q, before.UnixMilli(), before.UnixMilli(), bucket.Milliseconds())
+	if err != nil {
+		return 0, fmt.Errorf("downsample price history failed: %v", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// PurgePriceHistory deletes all price history entries older than before.
+func (db *Database) PurgePriceHistory(before time.Time) (int64, error) {
+	q := `DELETE FROM price_history WHERE time < ?`
+	res, err := db.Exec(q, before.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("purge price history failed: %v", err)
+	}
+
+	return res.RowsAffected()
+}
+
+func (db *Database) AddAccount(login string, password string, email string, balance decimal.Decimal) error {
+	if _, err := db.GetAccount(login); err == nil {
 		return errors.New("account exists already")
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
 	}
 
 	acc := entity.Account{
 		PublicAccount: entity.PublicAccount{
 			Login:   login,
-			Balance: decimal.NewFromFloat(100),
+			Balance: balance,
 			Assets:  nil,
 		},
 	}
 	if password != "" {
-		acc.SetAndHashPassword(password)
+		if err := acc.SetAndHashPassword(password); err != nil {
+			return err
+		}
 	}
 
 	if email != "" {
@@ -426,16 +617,15 @@ func (db *Database) UpdateAccount(login, password, email string) error {
 	if err != nil {
 		return err
 	}
-	if acc == nil {
-		return fmt.Errorf("login %v not found")
-	}
 
 	if email != "" {
 		acc.Email = email
 	}
 
 	if password != "" {
-		acc.SetAndHashPassword(password)
+		if err = acc.SetAndHashPassword(password); err != nil {
+			return err
+		}
 	}
 
 	q := "UPDATE users SET password=?, email=? WHERE login=?"
@@ -443,15 +633,107 @@ func (db *Database) UpdateAccount(login, password, email string) error {
 	return err
 }
 
+// SetAccountTier changes the rate limit tier of a user, the empty tier selects the default limits
+func (db *Database) SetAccountTier(login string, tier string) error {
+	res, err := db.Exec(`UPDATE users SET tier = ? WHERE login = ?`, tier, login)
+	if err != nil {
+		return fmt.Errorf("update tier of user %v failed: %v", login, err)
+	}
+
+	if n, err := res.RowsAffected(); n != 1 {
+		return fmt.Errorf("login %v not found: %v", login, err)
+	}
+	return nil
+}
+
+// SetAccountAdmin grants or revokes the admin role of a user
+func (db *Database) SetAccountAdmin(login string, admin bool) error {
+	return db.updateUser(login, `UPDATE users SET admin = ? WHERE login = ?`, admin)
+}
+
+// SetAccountDisabled disables or enables a user. Disabled users can not authenticate.
+func (db *Database) SetAccountDisabled(login string, disabled bool) error {
+	return db.updateUser(login, `UPDATE users SET disabled = ? WHERE login = ?`, disabled)
+}
+
+func (db *Database) updateUser(login string, q string, value interface{}) error {
+	res, err := db.Exec(q, value, login)
+	if err != nil {
+		return fmt.Errorf("update user %v failed: %v", login, err)
+	}
+
+	if n, err := res.RowsAffected(); n != 1 {
+		return fmt.Errorf("login %v not found: %v", login, err)
+	}
+	return nil
+}
+
+// GetAsset returns a market asset or nil if there is none
+func (db *Database) GetAsset(name string) (*entity.MarketAsset, error) {
+	asset := entity.MarketAsset{Name: name, When: time.Now()}
+	err := db.QueryRow(`SELECT price, halted FROM market_assets WHERE name = ?`, name).Scan(&asset.Price, &asset.Halted)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query asset %v failed: %v", name, err)
+	}
+	return &asset, nil
+}
+
+// SetAssetHalted halts or resumes trading of an asset
+func (db *Database) SetAssetHalted(name string, halted bool) error {
+	res, err := db.Exec(`UPDATE market_assets SET halted = ? WHERE name = ?`, halted, name)
+	if err != nil {
+		return fmt.Errorf("update asset %v failed: %v", name, err)
+	}
+
+	if n, err := res.RowsAffected(); n != 1 {
+		return fmt.Errorf("asset %v not found: %v", name, err)
+	}
+	return nil
+}
+
+// RemoveMarketAsset deletes an asset and its price history. Assets held by users or referenced by orders
+// can not be removed.
+func (db *Database) RemoveMarketAsset(name string) error {
+	var held, orders int
+	err := db.QueryRow(`SELECT COUNT(*) FROM user_assets WHERE asset = ? AND amount <> 0`, name).Scan(&held)
+	if err != nil {
+		return fmt.Errorf("query holders of asset %v failed: %v", name, err)
+	}
+	if err = db.QueryRow(`SELECT COUNT(*) FROM orders WHERE asset = ?`, name).Scan(&orders); err != nil {
+		return fmt.Errorf("query orders of asset %v failed: %v", name, err)
+	}
+	if held > 0 || orders > 0 {
+		return fmt.Errorf("asset %v is held by %v users and referenced by %v orders, halt it instead", name, held, orders)
+	}
+
+	for _, q := range []string{`DELETE FROM user_assets WHERE asset = ?`, `DELETE FROM price_history WHERE asset = ?`} {
+		if _, err = db.Exec(q, name); err != nil {
+			return fmt.Errorf("remove asset %v failed: %v", name, err)
+		}
+	}
+
+	res, err := db.Exec(`DELETE FROM market_assets WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("remove asset %v failed: %v", name, err)
+	}
+	if n, err := res.RowsAffected(); n != 1 {
+		return fmt.Errorf("asset %v not found: %v", name, err)
+	}
+	return nil
+}
+
 func (db *Database) CreateMarketAsset(asset entity.MarketAsset) error {
 	if asset.Price.IsNegative() || asset.Price.IsZero() {
 		return errors.New("invalid price: must be positive")
 	}
 
 	q := `INSERT INTO market_assets (name,price) VALUES (?,?)`
-	res, err := db.Exec(q, asset.Name, asset.Price.InexactFloat64())
+	res, err := db.Exec(q, asset.Name, asset.Price)
 	if err != nil {
-		if strings.Index(err.Error(), "UNIQUE constraint") >= 0 {
+		if strings.Index(err.Error(), "UNIQUE constraint") >= 0 || strings.Index(err.Error(), "duplicate key") >= 0 {
 			return errors.New("asset already exists")
 		}
 		return err
@@ -473,6 +755,30 @@ func (db *Database) RemoveAccount(account *entity.PublicAccount) error {
 		return fmt.Errorf("delete from user_assets: %v", err)
 	}
 
+	sql = `DELETE FROM orders WHERE login = ?`
+	res, err = db.Exec(sql, account.Login)
+	if err != nil {
+		return fmt.Errorf("delete from orders: %v", err)
+	}
+
+	sql = `DELETE FROM sessions WHERE login = ?`
+	res, err = db.Exec(sql, account.Login)
+	if err != nil {
+		return fmt.Errorf("delete from sessions: %v", err)
+	}
+
+	sql = `DELETE FROM api_keys WHERE login = ?`
+	res, err = db.Exec(sql, account.Login)
+	if err != nil {
+		return fmt.Errorf("delete from api_keys: %v", err)
+	}
+
+	sql = `DELETE FROM email_confirmations WHERE login = ?`
+	res, err = db.Exec(sql, account.Login)
+	if err != nil {
+		return fmt.Errorf("delete from email_confirmations: %v", err)
+	}
+
 	sql = `DELETE FROM users WHERE login = ?`
 	res, err = db.Exec(sql, account.Login)
 	if err != nil {
@@ -485,7 +791,7 @@ func (db *Database) RemoveAccount(account *entity.PublicAccount) error {
 	return nil
 }
 
-func (db *Database) UpdateMarketAsset(name string, price float64) error {
+func (db *Database) UpdateMarketAsset(name string, price decimal.Decimal) error {
 	sql := `UPDATE market_assets SET price = ? WHERE name = ?`
 	res, err := db.Exec(sql, price, name)
 	if err != nil {
@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"errors"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+	"tradingServer/entity"
+)
+
+// forEachBackend runs a test against a new sqlite database, a new memory storage and, if configured, the PostgreSQL
+// test database
+func forEachBackend(t *testing.T, test func(t *testing.T, db Storage)) {
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, openTestDatabase(t, len(migrations)))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		db := openPostgresTestDatabase(t)
+		if _, err := db.MigrateUp(false); err != nil {
+			t.Fatal(err)
+		}
+		test(t, db)
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory())
+	})
+}
+
+func TestAccounts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Storage) {
+		if err := db.AddAccount("alice", "secret", "alice@example.com", decimal.NewFromInt(100)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddAccount("alice", "other", "", decimal.NewFromInt(100)); err == nil {
+			t.Error("AddAccount() of an existing login succeeded")
+		}
+
+		acc, err := db.GetAccount("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acc.Email != "alice@example.com" || !acc.Balance.Equal(decimal.NewFromInt(100)) || !acc.VerifyPassword("secret") {
+			t.Errorf("GetAccount() = %+v, want the added account", acc)
+		}
+		if _, err = db.GetAccount("bob"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetAccount() of an unknown login error = %v, want %v", err, ErrNotFound)
+		}
+
+		acc.Balance = decimal.RequireFromString("91.5")
+		acc.Assets = append(acc.Assets, &entity.UserAsset{Name: "toothpaste", Amount: decimal.NewFromInt(1)})
+		if err = db.SaveAccount(*acc); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.UpdateAccount("alice", "", "alice@example.org"); err != nil {
+			t.Fatal(err)
+		}
+
+		acc, err = db.GetAccount("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !acc.Balance.Equal(decimal.RequireFromString("91.5")) || len(acc.Assets) != 1 || acc.Email != "alice@example.org" {
+			t.Errorf("GetAccount() after update = %+v, want the saved balance, asset and email", acc)
+		}
+
+		accounts, err := db.GetAccounts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// ordered by balance
+		if len(accounts) != 2 || accounts[0].Login != "test" || accounts[1].Login != "alice" {
+			t.Errorf("GetAccounts() returned %v accounts, want test and alice", len(accounts))
+		}
+
+		if err = db.RemoveAccount(&acc.PublicAccount); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.GetAccount("alice"); err == nil {
+			t.Error("GetAccount() of a removed account succeeded")
+		}
+	})
+}
+
+func TestAssetPrices(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Storage) {
+		if err := db.SetAssetPrice("toothpaste", decimal.RequireFromString("8.7")); err != nil {
+			t.Fatal(err)
+		}
+
+		price, err := db.GetAssetPrice("toothpaste")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !price.Equal(decimal.RequireFromString("8.7")) {
+			t.Errorf("GetAssetPrice() = %v, want 8.7", price)
+		}
+		if _, err = db.GetAssetPrice("gold"); err == nil {
+			t.Error("GetAssetPrice() of an unknown asset succeeded")
+		}
+
+		assets, err := db.GetAssets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(assets) != 5 {
+			t.Errorf("GetAssets() returned %v assets, want 5", len(assets))
+		}
+	})
+}
+
+func TestTransactions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Storage) {
+		setBalance := func(s Storage, balance int64) {
+			t.Helper()
+			acc, err := s.GetAccount("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			acc.Balance = decimal.NewFromInt(balance)
+			if err = s.SaveAccount(*acc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		balance := func() int64 {
+			t.Helper()
+			acc, err := db.GetAccount("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return acc.Balance.IntPart()
+		}
+
+		tests := []struct {
+			name        string
+			commit      bool
+			wantBalance int64
+		}{
+			{"rollback", false, 100},
+			{"commit", true, 50},
+		}
+
+		for _, tt := range tests {
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ran := false
+			tx.AfterCommit(func() { ran = true })
+			setBalance(tx, 50)
+
+			if tt.commit {
+				err = tx.Commit()
+			} else {
+				err = tx.Rollback()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := balance(); got != tt.wantBalance {
+				t.Errorf("%v: balance = %v, want %v", tt.name, got, tt.wantBalance)
+			}
+			if ran != tt.commit {
+				t.Errorf("%v: AfterCommit function ran = %v, want %v", tt.name, ran, tt.commit)
+			}
+		}
+
+		ran := false
+		db.AfterCommit(func() { ran = true })
+		if !ran {
+			t.Error("AfterCommit function outside of a transaction did not run")
+		}
+	})
+}
+
+func TestOrders(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Storage) {
+		var ids []int64
+		for _, side := range []string{entity.OrderSideBuy, entity.OrderSideSell} {
+			o := &entity.Order{
+				Login:  "test",
+				Type:   entity.OrderTypeLimit,
+				Side:   side,
+				Asset:  "toothpaste",
+				Amount: decimal.NewFromInt(1),
+				Price:  decimal.RequireFromString("8.25"),
+				Status: entity.OrderStatusOpen,
+			}
+			if err := db.CreateOrder(o); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, o.ID)
+		}
+		if ids[0] == ids[1] {
+			t.Errorf("CreateOrder() assigned ID %v twice", ids[0])
+		}
+
+		if err := db.UpdateOrder(entity.Order{ID: ids[0], Status: entity.OrderStatusFilled, FillPrice: decimal.NewFromInt(8)}); err != nil {
+			t.Fatal(err)
+		}
+
+		o, err := db.GetOrder(ids[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Status != entity.OrderStatusFilled || !o.FillPrice.Equal(decimal.NewFromInt(8)) || !o.Price.Equal(decimal.RequireFromString("8.25")) {
+			t.Errorf("GetOrder() = %+v, want the filled order", o)
+		}
+
+		tests := []struct {
+			name     string
+			openOnly bool
+			want     []int64
+		}{
+			{"all orders, newest first", false, []int64{ids[1], ids[0]}},
+			{"open orders", true, []int64{ids[1]}},
+		}
+
+		for _, tt := range tests {
+			orders, err := db.GetOrders("test", tt.openOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for _, o := range orders {
+				got = append(got, o.ID)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("%v: GetOrders() = %v, want %v", tt.name, got, tt.want)
+			}
+		}
+
+		open, err := db.GetOpenOrders()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(open) != 1 || open[0].ID != ids[1] {
+			t.Errorf("GetOpenOrders() returned %v orders, want order %v", len(open), ids[1])
+		}
+	})
+}
+
+func TestMarketAssets(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Storage) {
+		if err := db.CreateMarketAsset(entity.MarketAsset{Name: "gold", Price: decimal.NewFromInt(50)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetAssetHalted("gold", true); err != nil {
+			t.Fatal(err)
+		}
+
+		asset, err := db.GetAsset("gold")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if asset == nil || !asset.Halted || !asset.Price.Equal(decimal.NewFromInt(50)) {
+			t.Errorf("GetAsset() = %+v, want the halted asset", asset)
+		}
+		if asset, err = db.GetAsset("silver"); err != nil || asset != nil {
+			t.Errorf("GetAsset() of an unknown asset = %+v, %v, want nil", asset, err)
+		}
+		if err = db.SetAssetHalted("silver", true); err == nil {
+			t.Error("SetAssetHalted() of an unknown asset succeeded")
+		}
+
+		// an asset held by a user can only be halted
+		acc, err := db.GetAccount("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		held := *acc
+		held.Assets = append(held.Assets, &entity.UserAsset{Name: "gold", Amount: decimal.NewFromInt(1)})
+		if err = db.SaveAccount(held); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.RemoveMarketAsset("gold"); err == nil {
+			t.Error("RemoveMarketAsset() of a held asset succeeded")
+		}
+
+		// and removed once it is sold
+		held.Assets[len(held.Assets)-1].Amount = decimal.Zero
+		if err = db.SaveAccount(held); err != nil {
+			t.Fatal(err)
+		}
+		if err = db.RemoveMarketAsset("gold"); err != nil {
+			t.Fatal(err)
+		}
+		if asset, err = db.GetAsset("gold"); err != nil || asset != nil {
+			t.Errorf("GetAsset() of a removed asset = %+v, %v, want nil", asset, err)
+		}
+	})
+}
+
+func TestAccountRoles(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Storage) {
+		if err := db.SetAccountAdmin("test", true); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetAccountDisabled("test", true); err != nil {
+			t.Fatal(err)
+		}
+
+		acc, err := db.GetAccount("test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !acc.Admin || !acc.Disabled {
+			t.Errorf("GetAccount() = %+v, want a disabled admin", acc)
+		}
+
+		if err = db.SetAccountAdmin("nobody", true); err == nil {
+			t.Error("SetAccountAdmin() of an unknown login succeeded")
+		}
+		if err = db.SetAccountDisabled("nobody", true); err == nil {
+			t.Error("SetAccountDisabled() of an unknown login succeeded")
+		}
+	})
+}
+
+func TestInviteCodes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Storage) {
+		now := time.Now().Truncate(time.Second)
+		for _, i := range []entity.InviteCode{
+			{Code: "TWICE", Created: now, MaxUses: 2},
+			{Code: "UNLIMITED", Created: now},
+			{Code: "EXPIRING", Created: now, Expires: now.Add(time.Hour)},
+		} {
+			if err := db.CreateInviteCode(i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.CreateInviteCode(entity.InviteCode{Code: "TWICE", Created: now}); err == nil {
+			t.Error("CreateInviteCode() of an existing code succeeded")
+		}
+
+		tests := []struct {
+			name string
+			code string
+			when time.Time
+			want bool
+		}{
+			{"first use", "TWICE", now, true},
+			{"last use", "TWICE", now, true},
+			{"used up", "TWICE", now, false},
+			{"no limit", "UNLIMITED", now, true},
+			{"no limit again", "UNLIMITED", now, true},
+			{"before expiry", "EXPIRING", now, true},
+			{"after expiry", "EXPIRING", now.Add(time.Hour), false},
+			{"unknown", "NONE", now, false},
+		}
+
+		for _, tt := range tests {
+			if ok, err := db.UseInviteCode(tt.code, tt.when); err != nil || ok != tt.want {
+				t.Errorf("%v: UseInviteCode(%v) = %v, %v, want %v", tt.name, tt.code, ok, err, tt.want)
+			}
+		}
+
+		codes, err := db.GetInviteCodes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		uses := make(map[string]int)
+		for _, i := range codes {
+			uses[i.Code] = i.Uses
+		}
+		// failed uses are not counted
+		if uses["TWICE"] != 2 || uses["UNLIMITED"] != 2 || uses["EXPIRING"] != 1 {
+			t.Errorf("GetInviteCodes() counted uses %v, want TWICE:2 UNLIMITED:2 EXPIRING:1", uses)
+		}
+
+		if found, err := db.DeleteInviteCode("UNLIMITED"); err != nil || !found {
+			t.Errorf("DeleteInviteCode() = %v, %v, want true", found, err)
+		}
+		if ok, err := db.UseInviteCode("UNLIMITED", now); err != nil || ok {
+			t.Errorf("UseInviteCode() of a deleted code = %v, %v, want false", ok, err)
+		}
+		if found, err := db.DeleteInviteCode("UNLIMITED"); err != nil || found {
+			t.Errorf("second DeleteInviteCode() = %v, %v, want false", found, err)
+		}
+	})
+}
+
+func TestUseQuote(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, db Storage) {
+		now := time.Now()
+
+		tests := []struct {
+			name      string
+			signature string
+			expires   time.Time
+			now       time.Time
+			want      bool
+		}{
+			{"first use", "a", now.Add(time.Minute), now, true},
+			{"replay", "a", now.Add(time.Minute), now, false},
+			{"other quote", "b", now.Add(time.Second), now, true},
+			// expired quotes are forgotten, they are rejected before they get here
+			{"after expiry", "b", now.Add(time.Second), now.Add(time.Hour), true},
+		}
+
+		for _, tt := range tests {
+			if ok, err := db.UseQuote(tt.signature, tt.expires, tt.now); err != nil || ok != tt.want {
+				t.Errorf("%v: UseQuote(%v) = %v, %v, want %v", tt.name, tt.signature, ok, err, tt.want)
+			}
+		}
+
+		// a use rolled back with its trade does not count
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := tx.UseQuote("c", now.Add(time.Minute), now); err != nil || !ok {
+			t.Fatalf("UseQuote() in transaction = %v, %v, want true", ok, err)
+		}
+		if err = tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := db.UseQuote("c", now.Add(time.Minute), now); err != nil || !ok {
+			t.Errorf("UseQuote() after rollback = %v, %v, want true", ok, err)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"tradingServer/entity"
+)
+
+func (db *Database) GetTOTP(login string) (*entity.TOTP, error) {
+	var t entity.TOTP
+	var recovery string
+
+	q := `SELECT totp_secret, totp_enabled, totp_recovery, totp_last_step FROM users WHERE login = ?` + db.forUpdate()
+	err := db.QueryRow(q, login).Scan(&t.Secret, &t.Enabled, &recovery, &t.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("login %v not found", login)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query totp of user %v failed: %v", login, err)
+	}
+
+	t.RecoveryCodeHashes = splitList(recovery)
+	return &t, nil
+}
+
+func (db *Database) SaveTOTP(login string, t entity.TOTP) error {
+	q := `UPDATE users SET totp_secret = ?, totp_enabled = ?, totp_recovery = ?, totp_last_step = ? WHERE login = ?`
+	res, err := db.Exec(q, t.Secret, t.Enabled, strings.Join(t.RecoveryCodeHashes, ","), t.LastStep, login)
+	if err != nil {
+		return fmt.Errorf("update totp of user %v failed: %v", login, err)
+	}
+
+	if n, err := res.RowsAffected(); n != 1 {
+		return fmt.Errorf("login %v not found: %v", login, err)
+	}
+	return nil
+}